@@ -3,10 +3,10 @@ package agent
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"github.com/golang/protobuf/proto"
-	"io"
 	pb "main/proto"
 	"main/utility"
 	"net"
@@ -20,9 +20,13 @@ type Handler func([]string)
 type Agent struct {
 	traderId uint32
 	tradeId  uint32
+	lastSeq  uint64
 	funcMap  map[string]Handler
 	send     chan string
-	orders   []*pb.Order
+
+	// stopHeartbeat ends the heartbeat of the last logon, only the
+	// reading goroutine touches it
+	stopHeartbeat context.CancelFunc
 }
 
 func NewAgent() *Agent {
@@ -35,7 +39,11 @@ func NewAgent() *Agent {
 	p.funcMap["b"] = p.Buy
 	p.funcMap["s"] = p.Sell
 	p.funcMap["c"] = p.Cancel
+	p.funcMap["a"] = p.Amend
 	p.funcMap["l"] = p.OrderList
+	p.funcMap["p"] = p.PositionList
+	p.funcMap["i"] = p.Logon
+	p.funcMap["r"] = p.Resend
 
 	return p
 }
@@ -62,12 +70,8 @@ func (p *Agent) Run() {
 			n, err := conn.Read(buffer)
 			result.Write(buffer[0:n])
 			if err != nil {
-				if err == io.EOF {
-					continue
-				} else {
-					fmt.Println("read err:", err)
-					break
-				}
+				fmt.Println("read err:", err)
+				os.Exit(1)
 			} else {
 				scanner := bufio.NewScanner(result)
 				scanner.Split(packetSlit)
@@ -79,13 +83,49 @@ func (p *Agent) Run() {
 						proto.Unmarshal(scannedPack.Data, t)
 						p.traderId = t.GetTraderId()
 						fmt.Println(t.GetTraderId())
-					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.Buy)) == 0 {
+						p.startHeartbeat(time.Duration(t.GetHeartbeatInterval()) * time.Second)
+					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.TestRequest)) == 0 {
+						t := &pb.KeepAlive{}
+						proto.Unmarshal(scannedPack.Data, t)
+						data, _ := proto.Marshal(&pb.KeepAlive{TestReqId: t.GetTestReqId()})
+						p.send <- p.Pack(data, pb.Heartbeat)
+					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.Buy)) == 0 ||
+						bytes.Compare(scannedPack.GetTag(), []byte(pb.Cancel)) == 0 ||
+						bytes.Compare(scannedPack.GetTag(), []byte(pb.NotCancelled)) == 0 ||
+						bytes.Compare(scannedPack.GetTag(), []byte(pb.Amend)) == 0 ||
+						bytes.Compare(scannedPack.GetTag(), []byte(pb.Rejected)) == 0 ||
+						bytes.Compare(scannedPack.GetTag(), []byte(pb.Expired)) == 0 {
 						t := &pb.Order{}
 						proto.Unmarshal(scannedPack.Data, t)
+						if t.GetSeqNum() > p.lastSeq {
+							p.lastSeq = t.GetSeqNum()
+						}
 						fmt.Println(t)
-					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.Cancel)) == 0 {
-						t := &pb.Order{}
+					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.List)) == 0 {
+						t := &pb.OrderList{}
+						proto.Unmarshal(scannedPack.Data, t)
+						fmt.Printf("%d open orders\n", len(t.GetOrders()))
+						for _, o := range t.GetOrders() {
+							fmt.Printf("stock:%d orderId:%d tradeId:%d kind:%d price:%d remaining:%d inBook:%v\n",
+								o.GetStockId(), o.GetOrderId(), o.GetTradeId(), o.GetKind(), o.GetPrice(), o.GetRemaining(),
+								time.Duration(o.GetTimeInBook()).Round(time.Millisecond))
+						}
+					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.Positions)) == 0 {
+						t := &pb.PositionList{}
 						proto.Unmarshal(scannedPack.Data, t)
+						for _, o := range t.GetPositions() {
+							fmt.Printf("stock:%d net:%d bought:%d sold:%d cost:%d avgCost:%d.%04d realized:%d\n",
+								o.GetStockId(), o.GetNet(), o.GetBought(), o.GetSold(), o.GetCost(),
+								o.GetAvgCost()/10000, o.GetAvgCost()%10000, o.GetRealizedPnl())
+						}
+					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.Logout)) == 0 {
+						t := &pb.LogoutReason{}
+						proto.Unmarshal(scannedPack.Data, t)
+						fmt.Println("logout:", t.GetText())
+					} else if bytes.Compare(scannedPack.GetTag(), []byte(pb.SeqReset)) == 0 {
+						t := &pb.SequenceReset{}
+						proto.Unmarshal(scannedPack.Data, t)
+						p.lastSeq = t.GetNewSeqNum() - 1
 						fmt.Println(t)
 					}
 				}
@@ -117,12 +157,41 @@ func (p *Agent) Run() {
 			fmt.Println("text can't split")
 			continue
 		}
-		fmt.Println("len[%d] - args:%v", len(args), args)
+		fmt.Printf("len[%d] - args:%v\n", len(args), args)
 		args2 := args[1:]
 		p.DoCommandFunc(args[0], args2)
 	}
 }
 
+// startHeartbeat sends heartbeats at the interval the engine gave the
+// last logon, the ones of an earlier logon stop.
+func (p *Agent) startHeartbeat(interval time.Duration) {
+	if p.stopHeartbeat != nil {
+		p.stopHeartbeat()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.stopHeartbeat = cancel
+	go p.heartbeat(ctx, interval)
+}
+
+// heartbeat keeps the session alive while the user is idle at the prompt.
+func (p *Agent) heartbeat(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, _ := proto.Marshal(&pb.KeepAlive{})
+			p.send <- p.Pack(data, pb.Heartbeat)
+		}
+	}
+}
+
 func (p *Agent) DoCommandFunc(cmd string, args []string) {
 	if hander, found := p.funcMap[cmd]; found {
 		hander(args)
@@ -136,23 +205,39 @@ func (p *Agent) Buy(args []string) {
 	}
 
 	stockId, _ := utility.Interface2uint64(args[0])
-	quantity, _ := utility.Interface2uint64(args[1])
-	price, _ := utility.Interface2uint64(args[2])
+	quantity, price, err := decimals(args[1], args[2])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	o := &pb.Order{
-		Uuid:     p.traderId,
-		StockId:  stockId,
-		TradeId:  p.tradeId,
-		Kind:     pb.BUY,
-		Price:    price,
-		Quantity: quantity,
+		Uuid:            p.traderId,
+		StockId:         stockId,
+		TradeId:         p.tradeId,
+		Kind:            pb.BUY,
+		DecimalPrice:    price,
+		DecimalQuantity: quantity,
 	}
+	o.TimeInForce, o.ExpireDate = timeInForce(args[3:])
 	data, _ := proto.Marshal(o)
 	p.send <- p.Pack(data, pb.Buy)
-	p.orders = append(p.orders, o)
 	p.tradeId++
 }
 
+// timeInForce reads the optional last argument of a buy or sell, "day" for
+// a day order or a YYYYMMDD date for good till date, none is good till cancel.
+func timeInForce(args []string) (int32, uint32) {
+	if len(args) == 0 || args[0] == "" {
+		return pb.TIF_GTC, 0
+	}
+	if args[0] == "day" {
+		return pb.TIF_DAY, 0
+	}
+	date, _ := utility.Interface2uint32(args[0])
+	return pb.TIF_GTD, date
+}
+
 func (p *Agent) Sell(args []string) {
 	if len(args) < 3 {
 		fmt.Println("args not enough.")
@@ -160,19 +245,23 @@ func (p *Agent) Sell(args []string) {
 	}
 
 	stockId, _ := utility.Interface2uint64(args[0])
-	quantity, _ := utility.Interface2uint64(args[1])
-	price, _ := utility.Interface2uint64(args[2])
+	quantity, price, err := decimals(args[1], args[2])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 
 	o := &pb.Order{
-		Uuid:     p.traderId,
-		StockId:  stockId,
-		Kind:     pb.SELL,
-		Price:    price,
-		Quantity: quantity,
+		Uuid:            p.traderId,
+		StockId:         stockId,
+		TradeId:         p.tradeId,
+		Kind:            pb.SELL,
+		DecimalPrice:    price,
+		DecimalQuantity: quantity,
 	}
+	o.TimeInForce, o.ExpireDate = timeInForce(args[3:])
 	data, _ := proto.Marshal(o)
 	p.send <- p.Pack(data, pb.Sell)
-	p.orders = append(p.orders, o)
 	p.tradeId++
 }
 
@@ -183,24 +272,136 @@ func (p *Agent) Cancel(args []string) {
 	}
 
 	stockId, _ := utility.Interface2uint64(args[0])
-	tradeId, _ := utility.Interface2uint32(args[1])
+	orderId, tradeId := orderRef(args[1])
 
 	o := &pb.Order{
 		Uuid:    p.traderId,
+		OrderId: orderId,
 		TradeId: tradeId,
 		StockId: stockId,
 		Kind:    pb.CANCEL,
 	}
 	data, _ := proto.Marshal(o)
 	p.send <- p.Pack(data, pb.Cancel)
-	p.orders = append(p.orders, o)
 	p.tradeId++
 }
 
+func (p *Agent) Amend(args []string) {
+	if len(args) < 4 {
+		fmt.Println("args not enough.")
+		return
+	}
+
+	stockId, _ := utility.Interface2uint64(args[0])
+	orderId, tradeId := orderRef(args[1])
+	quantity, price, err := decimals(args[2], args[3])
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	o := &pb.Order{
+		Uuid:            p.traderId,
+		OrderId:         orderId,
+		TradeId:         tradeId,
+		StockId:         stockId,
+		Kind:            pb.AMEND,
+		DecimalPrice:    price,
+		DecimalQuantity: quantity,
+	}
+	data, _ := proto.Marshal(o)
+	p.send <- p.Pack(data, pb.Amend)
+}
+
+// decimals reads the quantity and price of an order, both may have
+// decimal places up to the scales of the stock, "b 1 0.5 101.25".
+func decimals(quantity string, price string) (*pb.Decimal, *pb.Decimal, error) {
+	q, err := pb.ParseDecimal(quantity)
+	if err != nil {
+		return nil, nil, err
+	}
+	pr, err := pb.ParseDecimal(price)
+	if err != nil {
+		return nil, nil, err
+	}
+	return q, pr, nil
+}
+
+// orderRef reads the order a cancel or amend names, "#" and the order id
+// the engine gave it or else the trade id it was sent with.
+func orderRef(arg string) (uint64, uint32) {
+	if strings.HasPrefix(arg, "#") {
+		orderId, _ := utility.Interface2uint64(arg[1:])
+		return orderId, 0
+	}
+	tradeId, _ := utility.Interface2uint32(arg)
+	return 0, tradeId
+}
+
+// OrderList asks the engine for the trader's open orders, of one stock if given.
 func (p *Agent) OrderList(args []string) {
-	for _, order := range p.orders {
-		fmt.Println(order)
+	o := &pb.Order{
+		Uuid: p.traderId,
+		Kind: pb.LIST,
+	}
+	if len(args) > 0 && args[0] != "" {
+		o.StockId, _ = utility.Interface2uint64(args[0])
+	}
+	data, _ := proto.Marshal(o)
+	p.send <- p.Pack(data, pb.List)
+}
+
+// PositionList asks the engine for the trader's positions, of one stock if given.
+func (p *Agent) PositionList(args []string) {
+	o := &pb.Order{
+		Uuid: p.traderId,
+		Kind: pb.POSITION,
 	}
+	if len(args) > 0 && args[0] != "" {
+		o.StockId, _ = utility.Interface2uint64(args[0])
+	}
+	data, _ := proto.Marshal(o)
+	p.send <- p.Pack(data, pb.Positions)
+}
+
+// Logon resumes a previous trader, reports after the last one seen are replayed.
+func (p *Agent) Logon(args []string) {
+	if len(args) < 1 {
+		fmt.Println("args not enough.")
+		return
+	}
+
+	traderId, _ := utility.Interface2uint32(args[0])
+	nextSeq := p.lastSeq + 1
+	if len(args) > 1 {
+		nextSeq, _ = utility.Interface2uint64(args[1])
+	}
+
+	token := ""
+	if len(args) > 2 {
+		token = args[2]
+	}
+
+	role := ""
+	if len(args) > 3 {
+		role = args[3]
+	}
+
+	data, _ := proto.Marshal(&pb.Logon{TraderId: traderId, NextSeqNum: nextSeq, Token: token, Role: role})
+	p.send <- p.Pack(data, pb.Login)
+}
+
+func (p *Agent) Resend(args []string) {
+	r := &pb.ResendRequest{BeginSeq: 1}
+	if len(args) > 0 {
+		r.BeginSeq, _ = utility.Interface2uint64(args[0])
+	}
+	if len(args) > 1 {
+		r.EndSeq, _ = utility.Interface2uint64(args[1])
+	}
+
+	data, _ := proto.Marshal(r)
+	p.send <- p.Pack(data, pb.Resend)
 }
 
 func packetSlit(data []byte, atEOF bool) (advance int, token []byte, err error) {
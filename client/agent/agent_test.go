@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"bytes"
+	pb "main/proto"
+	"testing"
+	"time"
+)
+
+// heartbeats counts the heartbeats the agent queues within d.
+func heartbeats(t *testing.T, p *Agent, d time.Duration) int {
+	t.Helper()
+	n := 0
+	timeout := time.After(d)
+	for {
+		select {
+		case data := <-p.send:
+			packet := new(pb.Packet)
+			if err := packet.Unpack(bytes.NewReader([]byte(data))); err != nil {
+				t.Fatal(err)
+			}
+			if string(packet.GetTag()) != pb.Heartbeat {
+				t.Fatalf("queued %q, want a heartbeat", packet.GetTag())
+			}
+			n++
+		case <-timeout:
+			return n
+		}
+	}
+}
+
+func TestHeartbeatSent(t *testing.T) {
+	p := NewAgent()
+	p.startHeartbeat(10 * time.Millisecond)
+	defer p.stopHeartbeat()
+
+	if n := heartbeats(t, p, 200*time.Millisecond); n == 0 {
+		t.Fatal("no heartbeat while idle")
+	}
+}
+
+func TestLogonReplacesHeartbeat(t *testing.T) {
+	for _, interval := range []time.Duration{time.Hour, 0} {
+		p := NewAgent()
+		p.startHeartbeat(10 * time.Millisecond)
+		if n := heartbeats(t, p, 100*time.Millisecond); n == 0 {
+			t.Fatal("no heartbeat while idle")
+		}
+
+		// Logged on again, the first heartbeat may still have one under way
+		p.startHeartbeat(interval)
+		if n := heartbeats(t, p, 200*time.Millisecond); n > 1 {
+			t.Errorf("logged on again at %v: %d heartbeats, the old ones kept going", interval, n)
+		}
+		p.stopHeartbeat()
+	}
+}
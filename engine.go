@@ -1,16 +1,53 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"main/gateway/fix"
+	"main/gateway/rest"
+	"main/gateway/rpc"
+	"main/gateway/ws"
 	"main/matcher"
 	"math/rand"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 var Matcher *matcher.TradeMatcher
 
 func init() {
-	Matcher = matcher.NewMatcher()
+	cfg := matcher.DefaultConfig()
+	// Every trader logs on with its token, nobody trades without the list
+	cfg.TokenFile = os.Getenv("ENGINE_TOKEN_FILE")
+	if cfg.TokenFile == "" {
+		log.Fatal("ENGINE_TOKEN_FILE must name the \"trader_id token\" file")
+	}
+	// Admin commands stay off unless the operator token is provided
+	cfg.AdminToken = os.Getenv("ENGINE_ADMIN_TOKEN")
+	if dir := os.Getenv("ENGINE_JOURNAL_DIR"); dir != "" {
+		cfg.JournalDir = dir
+	}
+	// A primary streams its input to the standby connecting here, both
+	// ends share the secret
+	cfg.Replication.Listen = os.Getenv("ENGINE_REPLICATION")
+	cfg.Replication.Secret = os.Getenv("ENGINE_REPLICATION_SECRET")
+	// A cluster replica names itself and every peer as "id=host:port,..."
+	if id := os.Getenv("ENGINE_CLUSTER_ID"); id != "" {
+		cluster, err := parseCluster(id, os.Getenv("ENGINE_CLUSTER_PEERS"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		cfg.Cluster = cluster
+		cfg.Cluster.Secret = os.Getenv("ENGINE_CLUSTER_SECRET")
+	}
+
+	var err error
+	Matcher, err = matcher.NewMatcherWithConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
@@ -19,6 +56,47 @@ func main() {
 	rand.Seed(time.Now().UTC().UnixNano())
 
 	if Matcher != nil {
+		// A standby follows its primary and only takes sessions once that is lost
+		if primary := os.Getenv("ENGINE_FOLLOW"); primary != "" {
+			if err := Matcher.Follow(primary); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("promoted to primary")
+		}
+
+		go func() {
+			err := fix.NewAcceptor(Matcher, "ENGINE").Start("tcp", "0.0.0.0:9878")
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
+		go func() {
+			// Browsers may connect from the pages of the origins listed, "https://host,..."
+			var origins []string
+			if list := os.Getenv("ENGINE_WS_ORIGINS"); list != "" {
+				origins = strings.Split(list, ",")
+			}
+			err := ws.NewGateway(Matcher, origins...).Start("0.0.0.0:8080")
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
+		go func() {
+			err := rpc.NewServer(Matcher).Start("tcp", "0.0.0.0:9090")
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
+		go func() {
+			err := rest.NewServer(Matcher).Start("0.0.0.0:8081")
+			if err != nil {
+				log.Println(err)
+			}
+		}()
+
 		err := Matcher.Start("tcp", "0.0.0.0:8000")
 		if err != nil {
 			log.Println(err)
@@ -28,3 +106,23 @@ func main() {
 	finish := make(chan bool)
 	<-finish
 }
+
+func parseCluster(id string, peers string) (matcher.Cluster, error) {
+	cluster := matcher.Cluster{Peers: make(map[uint64]string)}
+	var err error
+	if cluster.Id, err = strconv.ParseUint(id, 10, 64); err != nil {
+		return cluster, err
+	}
+	for _, peer := range strings.Split(peers, ",") {
+		kv := strings.SplitN(strings.TrimSpace(peer), "=", 2)
+		if len(kv) != 2 {
+			return cluster, fmt.Errorf("cluster peer %q is not id=host:port", peer)
+		}
+		peerId, err := strconv.ParseUint(kv[0], 10, 64)
+		if err != nil {
+			return cluster, err
+		}
+		cluster.Peers[peerId] = kv[1]
+	}
+	return cluster, nil
+}
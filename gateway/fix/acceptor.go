@@ -0,0 +1,119 @@
+package fix
+
+import (
+	"bufio"
+	"log"
+	"main/matcher"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Acceptor accepts FIX 4.4 initiators and trades for them through the
+// same TradeMatcher native clients use. Every counterparty, known by its
+// SenderCompID, keeps one Session and one trader id for the life of the
+// process, so sequence numbers and reports survive reconnects. When the
+// matcher requires authentication the Logon names the trader in
+// Username(553) and carries its token in Password(554).
+type Acceptor struct {
+	matcher *matcher.TradeMatcher
+	compID  string
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewAcceptor(m *matcher.TradeMatcher, compID string) *Acceptor {
+	return &Acceptor{
+		matcher:  m,
+		compID:   compID,
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (a *Acceptor) Start(network string, addr string) error {
+	sock, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	log.Println("Wait for FIX initiators on", addr)
+
+	for {
+		conn, err := sock.Accept()
+		if err != nil {
+			return err
+		}
+		log.Println(conn.RemoteAddr().String(), "FIX connect success")
+		go a.serve(conn)
+	}
+}
+
+// serve expects a Logon first and hands the connection to the session of its sender.
+func (a *Acceptor) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	raw, err := ReadMessage(r)
+	if err != nil {
+		log.Println("fix:", err)
+		conn.Close()
+		return
+	}
+	msg, err := Parse(raw)
+	if err != nil || msg.Type() != MsgLogon {
+		log.Println("fix: first message must be a Logon")
+		conn.Close()
+		return
+	}
+	if msg.Get(TagTargetCompID) != a.compID || msg.Get(TagSenderCompID) == "" {
+		log.Println("fix: unknown comp ids in logon", msg)
+		conn.Close()
+		return
+	}
+
+	var traderId uint32
+	if a.matcher.AuthRequired() {
+		id, err := strconv.ParseUint(msg.Get(TagUsername), 10, 32)
+		if err != nil || !a.matcher.Authenticate(uint32(id), msg.Get(TagPassword)) {
+			log.Println("fix: authentication failed for", msg.Get(TagSenderCompID))
+			conn.Close()
+			return
+		}
+		traderId = uint32(id)
+	}
+
+	s := a.session(msg.Get(TagSenderCompID), traderId)
+	if s == nil {
+		log.Println("fix:", msg.Get(TagSenderCompID), "trades for another trader")
+		conn.Close()
+		return
+	}
+	c := newConnection(conn)
+	if !s.logon(c, msg) {
+		conn.Close()
+		return
+	}
+	s.run(c, r)
+}
+
+// session finds or makes the session of senderCompID trading for
+// traderId, a new trader id is handed out when traderId is 0. It is nil
+// when the session already trades for someone else.
+func (a *Acceptor) session(senderCompID string, traderId uint32) *Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, found := a.sessions[senderCompID]
+	if found {
+		if traderId != 0 && s.traderId != traderId {
+			return nil
+		}
+		return s
+	}
+	if traderId == 0 {
+		traderId = a.matcher.NextTraderId()
+	}
+	s = newSession(a, senderCompID, traderId)
+	a.sessions[senderCompID] = s
+	a.matcher.Bind(s)
+	return s
+}
@@ -0,0 +1,290 @@
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	pb "main/proto"
+	"strconv"
+	"time"
+)
+
+const (
+	BeginString = "FIX.4.4"
+	soh         = '\x01'
+	timeFormat  = "20060102-15:04:05.000"
+
+	// MaxBodyLength bounds BodyLength(9), nothing the gateway takes comes
+	// near it and a peer cannot make it allocate more.
+	MaxBodyLength = 16 << 10
+)
+
+// Tags used by the gateway.
+const (
+	TagAvgPx            = 6
+	TagBeginSeqNo       = 7
+	TagBeginString      = 8
+	TagBodyLength       = 9
+	TagCheckSum         = 10
+	TagClOrdID          = 11
+	TagCommission       = 12
+	TagCommType         = 13
+	TagCumQty           = 14
+	TagEndSeqNo         = 16
+	TagExecID           = 17
+	TagLastPx           = 31
+	TagLastQty          = 32
+	TagMsgSeqNum        = 34
+	TagMsgType          = 35
+	TagNewSeqNo         = 36
+	TagOrderID          = 37
+	TagOrderQty         = 38
+	TagOrdStatus        = 39
+	TagOrdType          = 40
+	TagOrigClOrdID      = 41
+	TagPossDupFlag      = 43
+	TagPrice            = 44
+	TagRefSeqNum        = 45
+	TagSenderCompID     = 49
+	TagSendingTime      = 52
+	TagSide             = 54
+	TagSymbol           = 55
+	TagTargetCompID     = 56
+	TagText             = 58
+	TagTimeInForce      = 59
+	TagTransactTime     = 60
+	TagCxlRejReason     = 102
+	TagOrdRejReason     = 103
+	TagHeartBtInt       = 108
+	TagTestReqID        = 112
+	TagOrigSendingTime  = 122
+	TagGapFillFlag      = 123
+	TagResetSeqNumFlag  = 141
+	TagExecType         = 150
+	TagLeavesQty        = 151
+	TagExpireDate       = 432
+	TagCxlRejResponseTo = 434
+	TagUsername         = 553
+	TagPassword         = 554
+)
+
+// Message types used by the gateway.
+const (
+	MsgHeartbeat                 = "0"
+	MsgTestRequest               = "1"
+	MsgResendRequest             = "2"
+	MsgReject                    = "3"
+	MsgSequenceReset             = "4"
+	MsgLogout                    = "5"
+	MsgExecutionReport           = "8"
+	MsgOrderCancelReject         = "9"
+	MsgLogon                     = "A"
+	MsgNewOrderSingle            = "D"
+	MsgOrderCancelRequest        = "F"
+	MsgOrderCancelReplaceRequest = "G"
+)
+
+var (
+	ErrGarbled  = errors.New("fix: garbled message")
+	ErrChecksum = errors.New("fix: checksum mismatch")
+)
+
+type field struct {
+	tag   int
+	value string
+}
+
+// Message is a FIX message as an ordered list of tag=value fields, the
+// standard header fields 8, 9 and 10 are handled by Bytes and Parse.
+type Message struct {
+	fields []field
+}
+
+func NewMessage(msgType string) *Message {
+	m := &Message{}
+	m.Set(TagMsgType, msgType)
+	return m
+}
+
+func (m *Message) Type() string {
+	return m.Get(TagMsgType)
+}
+
+func (m *Message) Has(tag int) bool {
+	for _, f := range m.fields {
+		if f.tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Message) Get(tag int) string {
+	for _, f := range m.fields {
+		if f.tag == tag {
+			return f.value
+		}
+	}
+	return ""
+}
+
+func (m *Message) GetUint(tag int) (uint64, error) {
+	return strconv.ParseUint(m.Get(tag), 10, 64)
+}
+
+// Set replaces the value of tag, or appends the field when it is not present.
+func (m *Message) Set(tag int, value string) *Message {
+	for i := range m.fields {
+		if m.fields[i].tag == tag {
+			m.fields[i].value = value
+			return m
+		}
+	}
+	m.fields = append(m.fields, field{tag, value})
+	return m
+}
+
+func (m *Message) SetUint(tag int, value uint64) *Message {
+	return m.Set(tag, strconv.FormatUint(value, 10))
+}
+
+// SetDecimal sets units with the last scale digits after the decimal point.
+func (m *Message) SetDecimal(tag int, units uint64, scale uint32) *Message {
+	return m.Set(tag, pb.NewDecimal(units, scale).Text())
+}
+
+func (m *Message) SetTime(tag int, t time.Time) *Message {
+	return m.Set(tag, t.UTC().Format(timeFormat))
+}
+
+func (m *Message) Copy() *Message {
+	c := &Message{fields: make([]field, len(m.fields))}
+	copy(c.fields, m.fields)
+	return c
+}
+
+// Bytes renders the message with BodyLength and CheckSum computed.
+func (m *Message) Bytes() []byte {
+	body := bytes.NewBuffer(nil)
+	// MsgType always leads the body
+	writeField(body, TagMsgType, m.Type())
+	for _, f := range m.fields {
+		if f.tag != TagMsgType {
+			writeField(body, f.tag, f.value)
+		}
+	}
+
+	out := bytes.NewBuffer(nil)
+	writeField(out, TagBeginString, BeginString)
+	writeField(out, TagBodyLength, strconv.Itoa(body.Len()))
+	out.Write(body.Bytes())
+	writeField(out, TagCheckSum, fmt.Sprintf("%03d", checksum(out.Bytes())))
+	return out.Bytes()
+}
+
+func (m *Message) String() string {
+	return string(bytes.ReplaceAll(m.Bytes(), []byte{soh}, []byte{'|'}))
+}
+
+func writeField(b *bytes.Buffer, tag int, value string) {
+	b.WriteString(strconv.Itoa(tag))
+	b.WriteByte('=')
+	b.WriteString(value)
+	b.WriteByte(soh)
+}
+
+func checksum(data []byte) int {
+	sum := 0
+	for _, c := range data {
+		sum += int(c)
+	}
+	return sum % 256
+}
+
+// ReadMessage reads one raw message off the stream using BodyLength to find its end.
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	begin, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(begin, []byte("8="+BeginString+"\x01")) {
+		return nil, ErrGarbled
+	}
+	length, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(length, []byte("9=")) {
+		return nil, ErrGarbled
+	}
+	n, err := strconv.Atoi(string(length[2 : len(length)-1]))
+	if err != nil || n <= 0 || n > MaxBodyLength {
+		return nil, ErrGarbled
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	trailer, err := readField(r)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 0, len(begin)+len(length)+n+len(trailer))
+	raw = append(raw, begin...)
+	raw = append(raw, length...)
+	raw = append(raw, body...)
+	raw = append(raw, trailer...)
+	return raw, nil
+}
+
+// readField reads up to the next SOH, a field longer than r can buffer is
+// garbage rather than something to keep reading.
+func readField(r *bufio.Reader) ([]byte, error) {
+	data, err := r.ReadSlice(soh)
+	if err == bufio.ErrBufferFull {
+		return nil, ErrGarbled
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Parse splits a raw message into fields and verifies its checksum.
+func Parse(raw []byte) (*Message, error) {
+	end := bytes.LastIndex(raw[:len(raw)-1], []byte{soh}) + 1
+	trailer := raw[end:]
+	if !bytes.HasPrefix(trailer, []byte("10=")) {
+		return nil, ErrGarbled
+	}
+	sum, err := strconv.Atoi(string(trailer[3 : len(trailer)-1]))
+	if err != nil {
+		return nil, ErrGarbled
+	}
+	if sum != checksum(raw[:end]) {
+		return nil, ErrChecksum
+	}
+
+	m := &Message{}
+	for _, part := range bytes.Split(raw[:end-1], []byte{soh}) {
+		eq := bytes.IndexByte(part, '=')
+		if eq <= 0 {
+			return nil, ErrGarbled
+		}
+		tag, err := strconv.Atoi(string(part[:eq]))
+		if err != nil {
+			return nil, ErrGarbled
+		}
+		if tag == TagBeginString || tag == TagBodyLength {
+			continue
+		}
+		m.fields = append(m.fields, field{tag, string(part[eq+1:])})
+	}
+	if m.Type() == "" {
+		return nil, ErrGarbled
+	}
+	return m, nil
+}
@@ -0,0 +1,773 @@
+package fix
+
+import (
+	"bufio"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"main/matcher"
+	pb "main/proto"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultHeartBtInt = 30 * time.Second
+	heartbeatTick     = time.Second
+)
+
+// Order states of OrdStatus(39) used by the gateway.
+const (
+	statusNew             = "0"
+	statusPartiallyFilled = "1"
+	statusFilled          = "2"
+	statusCanceled        = "4"
+	statusRejected        = "8"
+	statusExpired         = "C"
+)
+
+// timeInForce maps TimeInForce(59), orders without one rest until cancelled.
+var timeInForce = map[string]int32{
+	"":  pb.TIF_GTC,
+	"0": pb.TIF_DAY,
+	"1": pb.TIF_GTC,
+	"6": pb.TIF_GTD,
+}
+
+// connection is one TCP connection of a counterparty.
+type connection struct {
+	conn     net.Conn
+	out      chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// interval, lastRecv and lastSend are accessed atomically, in nanoseconds
+	interval int64
+	lastRecv int64
+	lastSend int64
+}
+
+func newConnection(conn net.Conn) *connection {
+	now := time.Now().UnixNano()
+	return &connection{
+		conn:     conn,
+		out:      make(chan []byte, 65535),
+		done:     make(chan struct{}),
+		interval: int64(defaultHeartBtInt),
+		lastRecv: now,
+		lastSend: now,
+	}
+}
+
+// write queues data without ever blocking, the matcher calls in through
+// Session.Send. A counterparty too slow to take its messages is dropped.
+func (c *connection) write(data []byte) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	select {
+	case c.out <- data:
+	default:
+		log.Println("fix:", c.conn.RemoteAddr(), "is not reading, dropping the session")
+		c.drop()
+	}
+}
+
+// drop hangs up at once, what was not written stays stored for the resend
+// the counterparty asks for at its next logon.
+func (c *connection) drop() {
+	c.close()
+	c.conn.Close()
+}
+
+// close asks the writer to flush what is queued, a Logout included, and hang up.
+func (c *connection) close() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
+// pending is a cancel or replace request waiting for the matcher's answer.
+type pending struct {
+	msgType  string
+	clOrdID  string
+	orderQty uint64
+	price    uint64
+}
+
+// order is the FIX view of an order, quantities and prices are in the
+// units of the last decimal place of its stock. The matcher knows it by
+// orderId once it accepted it, by the ClOrdID it came with until then.
+type order struct {
+	clOrdID  string
+	tradeId  uint32
+	orderId  uint64
+	clientId string
+	symbol   string
+	stockId  uint64
+	scale    matcher.Scale
+	side     string
+	orderQty uint64
+	price    uint64
+	cumQty   uint64
+	// notional sums quantity times price of the fills, for AvgPx only
+	notional float64
+	status   string
+	pending  *pending
+}
+
+func (o *order) leavesQty() uint64 {
+	if o.terminal() {
+		return 0
+	}
+	return o.orderQty - o.cumQty
+}
+
+func (o *order) terminal() bool {
+	return o.status == statusFilled || o.status == statusCanceled || o.status == statusRejected || o.status == statusExpired
+}
+
+// Session is the FIX state of one counterparty. It stays bound to the
+// matcher while the counterparty is away, reports produced meanwhile are
+// numbered and stored and reach it through a ResendRequest.
+type Session struct {
+	acceptor *Acceptor
+	compID   string
+	traderId uint32
+
+	mu          sync.Mutex
+	c           *connection
+	inSeq       uint64
+	outSeq      uint64
+	sent        map[uint64]*Message
+	orders      map[string]*order
+	byTradeId   map[uint32]*order
+	nextTradeId uint32
+	execId      uint64
+}
+
+func newSession(a *Acceptor, compID string, traderId uint32) *Session {
+	return &Session{
+		acceptor:    a,
+		compID:      compID,
+		traderId:    traderId,
+		inSeq:       1,
+		outSeq:      1,
+		sent:        make(map[uint64]*Message),
+		orders:      make(map[string]*order),
+		byTradeId:   make(map[uint32]*order),
+		nextTradeId: 1,
+	}
+}
+
+func (s *Session) TraderId() uint32 {
+	return s.traderId
+}
+
+// Stop drops the current connection, the session itself lives on.
+func (s *Session) Stop() {
+	s.mu.Lock()
+	c := s.c
+	s.c = nil
+	s.mu.Unlock()
+
+	if c != nil {
+		c.close()
+	}
+}
+
+// logon attaches c after validating the Logon, it reports whether c was accepted.
+func (s *Session) logon(c *connection, msg *Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.c != nil {
+		log.Printf("fix: %s is already logged on", s.compID)
+		return false
+	}
+	heartBtInt, err := msg.GetUint(TagHeartBtInt)
+	if err != nil || heartBtInt == 0 {
+		log.Printf("fix: %s sent a logon without HeartBtInt", s.compID)
+		return false
+	}
+	atomic.StoreInt64(&c.interval, int64(time.Duration(heartBtInt)*time.Second))
+
+	if msg.Get(TagResetSeqNumFlag) == "Y" {
+		s.inSeq = 1
+		s.outSeq = 1
+		s.sent = make(map[uint64]*Message)
+	}
+	s.c = c
+	go s.writer(c)
+
+	reply := NewMessage(MsgLogon).Set(98, "0").SetUint(TagHeartBtInt, heartBtInt)
+	if msg.Get(TagResetSeqNumFlag) == "Y" {
+		reply.Set(TagResetSeqNumFlag, "Y")
+	}
+	s.send(reply)
+
+	s.checkSeq(msg)
+	return true
+}
+
+// run reads c until it closes, then detaches it.
+func (s *Session) run(c *connection, r *bufio.Reader) {
+	go s.heartbeat(c)
+	defer func() {
+		s.mu.Lock()
+		if s.c == c {
+			s.c = nil
+		}
+		s.mu.Unlock()
+		c.close()
+	}()
+
+	for {
+		raw, err := ReadMessage(r)
+		if err != nil {
+			log.Println("fix read err:", err)
+			return
+		}
+		atomic.StoreInt64(&c.lastRecv, time.Now().UnixNano())
+		msg, err := Parse(raw)
+		if err == ErrChecksum {
+			// A message with a bad checksum is ignored, the gap brings it back
+			continue
+		}
+		if err != nil {
+			log.Println("fix:", err)
+			return
+		}
+		if !s.handle(c, msg) {
+			return
+		}
+	}
+}
+
+func (s *Session) writer(c *connection) {
+	for {
+		select {
+		case data := <-c.out:
+			if _, err := c.conn.Write(data); err != nil {
+				log.Println(err)
+			}
+			atomic.StoreInt64(&c.lastSend, time.Now().UnixNano())
+		case <-c.done:
+			for {
+				select {
+				case data := <-c.out:
+					c.conn.Write(data)
+				default:
+					c.conn.Close()
+					log.Printf("%s FIX is disconnected\n", c.conn.RemoteAddr().String())
+					return
+				}
+			}
+		}
+	}
+}
+
+// heartbeat mirrors the native session: Heartbeat when idle, TestRequest
+// once the counterparty is silent past the interval, Logout a interval later.
+func (s *Session) heartbeat(c *connection) {
+	ticker := time.NewTicker(heartbeatTick)
+	defer ticker.Stop()
+
+	var testReqID string
+	var testSentAt int64
+	for {
+		select {
+		case <-c.done:
+			return
+		case t := <-ticker.C:
+			now := t.UnixNano()
+			interval := atomic.LoadInt64(&c.interval)
+			lastRecv := atomic.LoadInt64(&c.lastRecv)
+
+			s.mu.Lock()
+			if now-atomic.LoadInt64(&c.lastSend) >= interval {
+				s.send(NewMessage(MsgHeartbeat))
+			}
+			if testReqID != "" {
+				if lastRecv > testSentAt {
+					testReqID = ""
+				} else if now-testSentAt >= interval {
+					s.send(NewMessage(MsgLogout).Set(TagText, "test request not answered"))
+					s.mu.Unlock()
+					c.close()
+					return
+				}
+			} else if now-lastRecv >= interval+interval/5 {
+				testReqID = strconv.FormatInt(now, 10)
+				testSentAt = now
+				s.send(NewMessage(MsgTestRequest).Set(TagTestReqID, testReqID))
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// send numbers and stores msg, it goes out at once if the counterparty is
+// connected. The caller must hold s.mu.
+func (s *Session) send(msg *Message) {
+	now := time.Now()
+	msg.SetUint(TagMsgSeqNum, s.outSeq)
+	msg.Set(TagSenderCompID, s.acceptor.compID)
+	msg.Set(TagTargetCompID, s.compID)
+	msg.SetTime(TagSendingTime, now)
+	s.sent[s.outSeq] = msg
+	s.outSeq++
+	if s.c != nil {
+		s.c.write(msg.Bytes())
+	}
+}
+
+// checkSeq enforces MsgSeqNum(34) and reports whether msg is the one expected.
+// The caller must hold s.mu.
+func (s *Session) checkSeq(msg *Message) bool {
+	seq, err := msg.GetUint(TagMsgSeqNum)
+	if err != nil {
+		s.send(NewMessage(MsgReject).Set(TagRefSeqNum, msg.Get(TagMsgSeqNum)).Set(TagText, "MsgSeqNum missing"))
+		return false
+	}
+	switch {
+	case seq > s.inSeq:
+		s.send(NewMessage(MsgResendRequest).SetUint(TagBeginSeqNo, s.inSeq).Set(TagEndSeqNo, "0"))
+		if msg.Type() == MsgLogon {
+			// The logon itself is taken, only what came before it is missing
+			return true
+		}
+		return false
+	case seq < s.inSeq:
+		if msg.Get(TagPossDupFlag) == "Y" {
+			return false
+		}
+		s.send(NewMessage(MsgLogout).Set(TagText, "MsgSeqNum too low, expecting "+strconv.FormatUint(s.inSeq, 10)))
+		if s.c != nil {
+			go s.c.close()
+		}
+		return false
+	}
+	s.inSeq++
+	return true
+}
+
+// handle processes one message of a logged on counterparty, false ends the connection.
+func (s *Session) handle(c *connection, msg *Message) bool {
+	s.mu.Lock()
+
+	if msg.Type() == MsgSequenceReset && msg.Get(TagGapFillFlag) != "Y" {
+		// Reset mode ignores MsgSeqNum altogether
+		if newSeq, err := msg.GetUint(TagNewSeqNo); err == nil && newSeq > s.inSeq {
+			s.inSeq = newSeq
+		}
+		s.mu.Unlock()
+		return true
+	}
+	if !s.checkSeq(msg) {
+		s.mu.Unlock()
+		return s.c == c
+	}
+
+	var submit *pb.Order
+	var tag string
+	switch msg.Type() {
+	case MsgHeartbeat:
+	case MsgTestRequest:
+		s.send(NewMessage(MsgHeartbeat).Set(TagTestReqID, msg.Get(TagTestReqID)))
+	case MsgResendRequest:
+		s.resend(msg)
+	case MsgSequenceReset:
+		if newSeq, err := msg.GetUint(TagNewSeqNo); err == nil && newSeq > s.inSeq {
+			s.inSeq = newSeq
+		}
+	case MsgLogout:
+		s.send(NewMessage(MsgLogout))
+		s.mu.Unlock()
+		return false
+	case MsgLogon:
+		s.send(NewMessage(MsgReject).Set(TagRefSeqNum, msg.Get(TagMsgSeqNum)).Set(TagText, "already logged on"))
+	case MsgNewOrderSingle:
+		submit, tag = s.newOrderSingle(msg)
+	case MsgOrderCancelRequest:
+		submit, tag = s.cancelRequest(msg)
+	case MsgOrderCancelReplaceRequest:
+		submit, tag = s.cancelReplaceRequest(msg)
+	default:
+		s.send(NewMessage(MsgReject).Set(TagRefSeqNum, msg.Get(TagMsgSeqNum)).Set(TagText, "unsupported MsgType "+msg.Type()))
+	}
+	s.mu.Unlock()
+
+	// Submitting outside the lock, the matcher delivers reports through it
+	if submit != nil {
+		s.acceptor.matcher.Submit(submit, tag)
+	}
+	return true
+}
+
+// resend replays application messages as possible duplicates and covers
+// the administrative ones with a gap fill. The caller must hold s.mu.
+func (s *Session) resend(msg *Message) {
+	begin, _ := msg.GetUint(TagBeginSeqNo)
+	end, _ := msg.GetUint(TagEndSeqNo)
+	if begin == 0 {
+		begin = 1
+	}
+	if end == 0 || end >= s.outSeq {
+		end = s.outSeq - 1
+	}
+
+	var gapFrom uint64
+	flushGap := func(next uint64) {
+		if gapFrom == 0 {
+			return
+		}
+		gap := NewMessage(MsgSequenceReset).
+			SetUint(TagMsgSeqNum, gapFrom).
+			Set(TagSenderCompID, s.acceptor.compID).
+			Set(TagTargetCompID, s.compID).
+			SetTime(TagSendingTime, time.Now()).
+			Set(TagPossDupFlag, "Y").
+			Set(TagGapFillFlag, "Y").
+			SetUint(TagNewSeqNo, next)
+		s.write(gap)
+		gapFrom = 0
+	}
+	for seq := begin; seq <= end; seq++ {
+		stored, found := s.sent[seq]
+		if !found || (stored.Type() != MsgExecutionReport && stored.Type() != MsgOrderCancelReject) {
+			if gapFrom == 0 {
+				gapFrom = seq
+			}
+			continue
+		}
+		flushGap(seq)
+		dup := stored.Copy()
+		dup.Set(TagPossDupFlag, "Y")
+		dup.Set(TagOrigSendingTime, stored.Get(TagSendingTime))
+		dup.SetTime(TagSendingTime, time.Now())
+		s.write(dup)
+	}
+	flushGap(end + 1)
+}
+
+// write sends msg as is, without numbering it. The caller must hold s.mu.
+func (s *Session) write(msg *Message) {
+	if s.c != nil {
+		s.c.write(msg.Bytes())
+	}
+}
+
+func (s *Session) newOrderSingle(msg *Message) (*pb.Order, string) {
+	clOrdID := msg.Get(TagClOrdID)
+	o := &order{
+		clOrdID: clOrdID,
+		symbol:  msg.Get(TagSymbol),
+		side:    msg.Get(TagSide),
+		status:  statusNew,
+	}
+	orderQty, qtyErr := pb.ParseDecimal(msg.Get(TagOrderQty))
+	price, priceErr := pb.ParseDecimal(msg.Get(TagPrice))
+	tif, knownTif := timeInForce[msg.Get(TagTimeInForce)]
+	expireDate, _ := msg.GetUint(TagExpireDate)
+
+	reason := ""
+	stockId, err := strconv.ParseUint(o.symbol, 10, 64)
+	if err == nil {
+		o.scale = s.acceptor.matcher.Scale(stockId)
+	}
+	switch {
+	case clOrdID == "":
+		reason = "ClOrdID missing"
+	case s.orders[clOrdID] != nil:
+		reason = "duplicate ClOrdID"
+	case err != nil:
+		reason = "unknown Symbol"
+	case o.side != "1" && o.side != "2":
+		reason = "unsupported Side"
+	case msg.Get(TagOrdType) != "2":
+		reason = "only limit orders are supported"
+	case qtyErr != nil || orderQty.GetUnits() == 0:
+		reason = "OrderQty must be positive"
+	case priceErr != nil || price.GetUnits() == 0:
+		reason = "Price must be positive"
+	case !knownTif:
+		reason = "unsupported TimeInForce"
+	case tif == pb.TIF_GTD && expireDate == 0:
+		reason = "ExpireDate missing"
+	default:
+		reason = o.decimals(orderQty, price)
+	}
+	if reason != "" {
+		o.status = statusRejected
+		s.send(s.executionReport(o, "8").Set(TagOrdRejReason, "99").Set(TagText, reason))
+		return nil, ""
+	}
+
+	o.stockId = stockId
+	o.tradeId = s.nextTradeId
+	o.clientId = clOrdID
+	s.nextTradeId++
+	s.orders[clOrdID] = o
+	s.byTradeId[o.tradeId] = o
+
+	kind, tag := int32(pb.BUY), pb.Buy
+	if o.side == "2" {
+		kind, tag = pb.SELL, pb.Sell
+	}
+	return &pb.Order{
+		Uuid:            s.traderId,
+		TradeId:         o.tradeId,
+		ClientOrderId:   clOrdID,
+		StockId:         o.stockId,
+		Kind:            kind,
+		Price:           o.price,
+		Quantity:        o.orderQty,
+		DecimalPrice:    price,
+		DecimalQuantity: orderQty,
+		TimeInForce:     tif,
+		ExpireDate:      uint32(expireDate),
+	}, tag
+}
+
+// decimals reads OrderQty and Price into the units of the stock of o, it
+// returns what is wrong with them when they do not fit.
+func (o *order) decimals(orderQty *pb.Decimal, price *pb.Decimal) string {
+	var err error
+	if o.orderQty, err = orderQty.Rescale(o.scale.Quantity); err != nil {
+		return "OrderQty: " + err.Error()
+	}
+	if o.price, err = price.Rescale(o.scale.Price); err != nil {
+		return "Price: " + err.Error()
+	}
+	return ""
+}
+
+func (s *Session) cancelRequest(msg *Message) (*pb.Order, string) {
+	o, ok := s.pendable(msg, "1")
+	if !ok {
+		return nil, ""
+	}
+	o.pending = &pending{msgType: MsgOrderCancelRequest, clOrdID: msg.Get(TagClOrdID)}
+	return &pb.Order{
+		Uuid:          s.traderId,
+		TradeId:       o.tradeId,
+		OrderId:       o.orderId,
+		ClientOrderId: o.clientId,
+		StockId:       o.stockId,
+		Kind:          pb.CANCEL,
+	}, pb.Cancel
+}
+
+func (s *Session) cancelReplaceRequest(msg *Message) (*pb.Order, string) {
+	o, ok := s.pendable(msg, "2")
+	if !ok {
+		return nil, ""
+	}
+	// A missing Price keeps the one the order has
+	orderQty, price := uint64(0), o.price
+	if d, err := pb.ParseDecimal(msg.Get(TagOrderQty)); err == nil {
+		if orderQty, err = d.Rescale(o.scale.Quantity); err != nil {
+			s.send(s.cancelReject(o, msg.Get(TagClOrdID), "2", "99", "OrderQty: "+err.Error()))
+			return nil, ""
+		}
+	}
+	if d, err := pb.ParseDecimal(msg.Get(TagPrice)); err == nil && d.GetUnits() != 0 {
+		if price, err = d.Rescale(o.scale.Price); err != nil {
+			s.send(s.cancelReject(o, msg.Get(TagClOrdID), "2", "99", "Price: "+err.Error()))
+			return nil, ""
+		}
+	}
+	if orderQty <= o.cumQty {
+		s.send(s.cancelReject(o, msg.Get(TagClOrdID), "2", "99", "OrderQty must exceed CumQty"))
+		return nil, ""
+	}
+
+	// The engine takes the fills it has made by the time it amends off
+	// OrderQty, some may not have reached this session yet
+	o.pending = &pending{msgType: MsgOrderCancelReplaceRequest, clOrdID: msg.Get(TagClOrdID), orderQty: orderQty, price: price}
+	return &pb.Order{
+		Uuid:          s.traderId,
+		TradeId:       o.tradeId,
+		OrderId:       o.orderId,
+		ClientOrderId: o.clientId,
+		StockId:       o.stockId,
+		Kind:          pb.AMEND,
+		Price:         price,
+		OrderQuantity: orderQty,
+		DecimalPrice:  pb.NewDecimal(price, o.scale.Price),
+	}, pb.Amend
+}
+
+// pendable finds the order OrigClOrdID refers to, rejecting the request
+// when there is none or another request is still pending on it.
+func (s *Session) pendable(msg *Message, responseTo string) (*order, bool) {
+	clOrdID := msg.Get(TagClOrdID)
+	o := s.orders[msg.Get(TagOrigClOrdID)]
+	switch {
+	case o == nil || o.terminal():
+		s.send(s.cancelReject(&order{clOrdID: msg.Get(TagOrigClOrdID), status: statusRejected}, clOrdID, responseTo, "1", "unknown order"))
+		return nil, false
+	case clOrdID == "" || s.orders[clOrdID] != nil:
+		s.send(s.cancelReject(o, clOrdID, responseTo, "99", "duplicate ClOrdID"))
+		return nil, false
+	case o.pending != nil:
+		s.send(s.cancelReject(o, clOrdID, responseTo, "3", "a request is already pending"))
+		return nil, false
+	}
+	return o, true
+}
+
+// Send translates the matcher's reports into ExecutionReports and OrderCancelRejects.
+func (s *Session) Send(msg proto.Message, tag string) {
+	report, ok := msg.(*pb.Order)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.byTradeId[report.GetTradeId()]
+	if o == nil {
+		return
+	}
+	if report.GetOrderId() != 0 {
+		o.orderId = report.GetOrderId()
+	}
+	switch tag {
+	case pb.Buy, pb.Sell:
+		o.cumQty += report.GetQuantity()
+		o.notional += float64(report.GetQuantity()) * float64(report.GetPrice())
+		o.status = statusPartiallyFilled
+		if o.cumQty >= o.orderQty {
+			o.status = statusFilled
+		}
+		// CommType 3 is an absolute amount, a rebate is a negative commission
+		s.send(s.executionReport(o, "F").
+			SetDecimal(TagLastQty, report.GetQuantity(), o.scale.Quantity).
+			SetDecimal(TagLastPx, report.GetPrice(), o.scale.Price).
+			Set(TagCommission, amount(report.GetFee(), o.scale.Price)).
+			Set(TagCommType, "3"))
+	case pb.Cancel:
+		o.status = statusCanceled
+		er := s.executionReport(o, "4")
+		if p := o.pending; p != nil && p.msgType == MsgOrderCancelRequest {
+			er.Set(TagClOrdID, p.clOrdID).Set(TagOrigClOrdID, o.clOrdID)
+			s.orders[p.clOrdID] = o
+			o.clOrdID = p.clOrdID
+		}
+		o.pending = nil
+		s.send(er)
+	case pb.Expired:
+		o.status = statusExpired
+		o.pending = nil
+		s.send(s.executionReport(o, "C"))
+	case pb.Amend:
+		p := o.pending
+		if p == nil || p.msgType != MsgOrderCancelReplaceRequest {
+			return
+		}
+		origClOrdID := o.clOrdID
+		o.orderQty = p.orderQty
+		o.price = p.price
+		o.clOrdID = p.clOrdID
+		o.pending = nil
+		s.orders[p.clOrdID] = o
+		s.send(s.executionReport(o, "5").Set(TagOrigClOrdID, origClOrdID))
+	case pb.NotCancelled:
+		p := o.pending
+		if p == nil {
+			return
+		}
+		o.pending = nil
+		responseTo := "1"
+		if p.msgType == MsgOrderCancelReplaceRequest {
+			responseTo = "2"
+		}
+		s.send(s.cancelReject(o, p.clOrdID, responseTo, "0", "too late to cancel"))
+	case pb.Rejected:
+		if p := o.pending; p != nil {
+			// The cancel or replace was turned away, the order itself stands
+			o.pending = nil
+			responseTo := "1"
+			if p.msgType == MsgOrderCancelReplaceRequest {
+				responseTo = "2"
+			}
+			s.send(s.cancelReject(o, p.clOrdID, responseTo, "99", report.GetReason()))
+			return
+		}
+		o.status = statusRejected
+		// OrdRejReason 3 is order exceeds limit, every risk reject is one
+		s.send(s.executionReport(o, "8").Set(TagOrdRejReason, "3").Set(TagText, report.GetReason()))
+	}
+}
+
+// Accepted acknowledges a new order once the matcher took it, with the
+// order id the matcher gave it.
+func (s *Session) Accepted(accepted *pb.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.byTradeId[accepted.GetTradeId()]
+	if o == nil {
+		return
+	}
+	o.orderId = accepted.GetOrderId()
+	s.send(s.executionReport(o, "0"))
+}
+
+// orderID is OrderID(37) of o, the matcher's id of it.
+func (o *order) orderID() string {
+	if o.orderId == 0 {
+		return "NONE"
+	}
+	return strconv.FormatUint(o.orderId, 10)
+}
+
+func (s *Session) executionReport(o *order, execType string) *Message {
+	s.execId++
+	er := NewMessage(MsgExecutionReport).
+		Set(TagOrderID, o.orderID()).
+		Set(TagClOrdID, o.clOrdID).
+		SetUint(TagExecID, s.execId).
+		Set(TagExecType, execType).
+		Set(TagOrdStatus, o.status).
+		Set(TagSymbol, o.symbol).
+		Set(TagSide, o.side).
+		SetDecimal(TagOrderQty, o.orderQty, o.scale.Quantity).
+		SetDecimal(TagPrice, o.price, o.scale.Price).
+		SetDecimal(TagLeavesQty, o.leavesQty(), o.scale.Quantity).
+		SetDecimal(TagCumQty, o.cumQty, o.scale.Quantity).
+		Set(TagAvgPx, "0").
+		SetTime(TagTransactTime, time.Now())
+	if o.cumQty > 0 {
+		avgPx := o.notional / float64(o.cumQty) / float64(pb.Pow10(o.scale.Price))
+		er.Set(TagAvgPx, strconv.FormatFloat(avgPx, 'f', -1, 64))
+	}
+	return er
+}
+
+// amount formats cash in units of a price of scale decimal places, a
+// negative amount is a rebate.
+func amount(units int64, scale uint32) string {
+	if units < 0 {
+		return "-" + pb.NewDecimal(uint64(-units), scale).Text()
+	}
+	return pb.NewDecimal(uint64(units), scale).Text()
+}
+
+func (s *Session) cancelReject(o *order, clOrdID string, responseTo string, reason string, text string) *Message {
+	return NewMessage(MsgOrderCancelReject).
+		Set(TagOrderID, o.orderID()).
+		Set(TagClOrdID, clOrdID).
+		Set(TagOrigClOrdID, o.clOrdID).
+		Set(TagOrdStatus, o.status).
+		Set(TagCxlRejResponseTo, responseTo).
+		Set(TagCxlRejReason, reason).
+		Set(TagText, text)
+}
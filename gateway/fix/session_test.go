@@ -0,0 +1,48 @@
+package fix
+
+import (
+	pb "main/proto"
+	"net"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds how long a test waits for the gateway.
+const testTimeout = 5 * time.Second
+
+func TestStalledCounterpartyDropped(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	// Nothing writes out or reads the other end, the counterparty is stalled
+	c := newConnection(server)
+	c.out = make(chan []byte, 1)
+	s := newSession(NewAcceptor(nil, "ENGINE"), "CLIENT", 1)
+	s.c = c
+	o := &order{clOrdID: "a", tradeId: 1, stockId: 1, side: "1", orderQty: 10, price: 100, status: statusNew}
+	s.orders["a"] = o
+	s.byTradeId[1] = o
+
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			s.Send(&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Price: 100, Quantity: 1}, pb.Buy)
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(testTimeout):
+		t.Fatal("Send blocked on a counterparty nobody writes to")
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Fatal("connection not dropped once full")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.sent) != 3 || o.cumQty != 3 {
+		t.Fatalf("%d reports stored for %d filled, want all 3 kept for the resend", len(s.sent), o.cumQty)
+	}
+}
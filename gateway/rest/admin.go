@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"log"
+	pb "main/proto"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AdminTokenHeader carries the operator token every admin request needs.
+const AdminTokenHeader = "X-Admin-Token"
+
+// AdminStatus is what the admin commands have switched off.
+type AdminStatus struct {
+	Halted          bool     `json:"halted"`
+	DisabledTraders []uint32 `json:"disabledTraders"`
+}
+
+var stockActions = map[string]int32{
+	"cancel": pb.ADMIN_CANCEL_STOCK,
+	"open":   pb.ADMIN_OPEN_STOCK,
+	"close":  pb.ADMIN_CLOSE_STOCK,
+}
+
+// admin serves the kill switches. Commands run on the matching goroutine
+// after every order already queued, so they are answered 202 Accepted.
+//
+//	GET  /admin/status
+//	GET  /admin/audit/{date}?format=csv|jsonl         the audit trail of a trading day, jsonl by default
+//	POST /admin/traders/{traderId}/disable?reason=  cancel its orders and block new ones
+//	POST /admin/traders/{traderId}/enable
+//	POST /admin/stocks/{stockId}/cancel?reason=     cancel every order of the stock
+//	POST /admin/kill?reason=                        cancel everything and stop accepting orders
+//	POST /admin/resume
+//	POST /admin/stocks/{stockId}/open|close         start or end the session of the stock
+//	POST /admin/eod                                 run the end of day job now
+func (s *Server) admin(w http.ResponseWriter, r *http.Request, parts []string) {
+	if !s.matcher.AuthenticateAdmin(r.Header.Get(AdminTokenHeader)) {
+		writeError(w, http.StatusForbidden, "admin token required")
+		return
+	}
+	if len(parts) == 2 && parts[0] == "audit" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+			return
+		}
+		s.audit(w, r, parts[1])
+		return
+	}
+	if len(parts) == 1 && parts[0] == "status" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+			return
+		}
+		writeJSON(w, http.StatusOK, &AdminStatus{
+			Halted:          s.matcher.Halted(),
+			DisabledTraders: s.matcher.DisabledTraders(),
+		})
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "only POST is supported")
+		return
+	}
+
+	cmd := &pb.AdminCommand{Reason: r.URL.Query().Get("reason")}
+	switch {
+	case len(parts) == 1 && parts[0] == "kill":
+		cmd.Action = pb.ADMIN_KILL
+	case len(parts) == 1 && parts[0] == "resume":
+		cmd.Action = pb.ADMIN_RESUME
+	case len(parts) == 1 && parts[0] == "eod":
+		cmd.Action = pb.ADMIN_END_OF_DAY
+	case len(parts) == 3 && parts[0] == "traders" && (parts[2] == "disable" || parts[2] == "enable"):
+		traderId, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil || traderId == 0 {
+			writeError(w, http.StatusBadRequest, "trader id must be a non zero uint32")
+			return
+		}
+		cmd.TraderId = uint32(traderId)
+		cmd.Action = pb.ADMIN_DISABLE_TRADER
+		if parts[2] == "enable" {
+			cmd.Action = pb.ADMIN_ENABLE_TRADER
+		}
+	case len(parts) == 3 && parts[0] == "stocks" && stockActions[parts[2]] != 0:
+		stockId, err := strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "stock id must be a uint64")
+			return
+		}
+		cmd.StockId = stockId
+		cmd.Action = stockActions[parts[2]]
+	default:
+		writeError(w, http.StatusNotFound, "unknown admin command")
+		return
+	}
+
+	if err := s.matcher.Admin(cmd); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, cmd)
+}
+
+// audit streams the audit trail of date, "2006-01-02", as CSV or JSON Lines.
+func (s *Server) audit(w http.ResponseWriter, r *http.Request, date string) {
+	if _, err := time.Parse("2006-01-02", date); err != nil {
+		writeError(w, http.StatusBadRequest, "date must be YYYY-MM-DD")
+		return
+	}
+	var err error
+	switch r.URL.Query().Get("format") {
+	case "", "jsonl":
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = s.matcher.WriteAuditJSONL(w, date)
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		err = s.matcher.WriteAuditCSV(w, date)
+	default:
+		writeError(w, http.StatusBadRequest, "format must be csv or jsonl")
+		return
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,268 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"main/matcher"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Headers identifying the trader behind a request for trader scoped resources.
+const (
+	TraderIdHeader = "X-Trader-Id"
+	TokenHeader    = "X-Token"
+)
+
+const defaultTradeLimit = 20
+
+// Server answers read only HTTP queries from the matcher's read models, a
+// query never waits for the matching goroutine. The operator commands
+// under /admin are described on admin.
+//
+//	GET /traders/{traderId}/orders            open orders of the trader
+//	GET /traders/{traderId}/orders/{tradeId}  one order, open or not
+//	GET /traders/{traderId}/positions         positions and P&L per stock
+//	GET /traders/{traderId}/account           cash and holdings
+//	GET /traders/{traderId}/fees              what the trader traded and paid today
+//	GET /traders/{traderId}/ledger            ledger entries touching the trader
+//	GET /books/{stockId}?levels=n             the last published depth
+//	GET /trades/{stockId}?limit=n             the latest trades, newest first
+//	GET /instruments                          every known stock
+//	GET /instruments/{stockId}                one stock
+type Server struct {
+	matcher *matcher.TradeMatcher
+}
+
+func NewServer(m *matcher.TradeMatcher) *Server {
+	return &Server{matcher: m}
+}
+
+func (s *Server) Start(addr string) error {
+	log.Println("Wait for REST queries on", addr)
+	return http.ListenAndServe(addr, s)
+}
+
+// Error is the body of every failed query.
+type Error struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] == "admin" {
+		s.admin(w, r, parts[1:])
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "only GET is supported")
+		return
+	}
+	switch parts[0] {
+	case "traders":
+		s.traders(w, r, parts[1:])
+	case "books":
+		s.books(w, r, parts[1:])
+	case "trades":
+		s.trades(w, r, parts[1:])
+	case "instruments":
+		s.instruments(w, parts[1:])
+	default:
+		writeError(w, http.StatusNotFound, "unknown resource")
+	}
+}
+
+func (s *Server) traders(w http.ResponseWriter, r *http.Request, parts []string) {
+	if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[1] != "orders" && parts[1] != "client-orders") {
+		writeError(w, http.StatusNotFound, "unknown resource")
+		return
+	}
+	traderId, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || traderId == 0 {
+		writeError(w, http.StatusBadRequest, "trader id must be a non zero uint32")
+		return
+	}
+	if !s.authorized(r, uint32(traderId)) {
+		writeError(w, http.StatusUnauthorized, "authentication failed")
+		return
+	}
+
+	switch {
+	case parts[1] == "positions":
+		writeJSON(w, http.StatusOK, s.matcher.Positions(uint32(traderId)))
+	case parts[1] == "account":
+		writeJSON(w, http.StatusOK, s.matcher.Account(uint32(traderId)))
+	case parts[1] == "fees":
+		writeJSON(w, http.StatusOK, s.matcher.FeeSummary(uint32(traderId)))
+	case parts[1] == "ledger":
+		writeJSON(w, http.StatusOK, s.matcher.LedgerHistory(uint32(traderId)))
+	case parts[1] == "orders" && len(parts) == 2:
+		writeJSON(w, http.StatusOK, s.matcher.OpenOrders(uint32(traderId)))
+	case parts[1] == "orders":
+		orderId, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "order id must be a uint64")
+			return
+		}
+		order, found := s.matcher.OrderStatus(uint32(traderId), orderId)
+		writeOrder(w, order, found)
+	case parts[1] == "client-orders":
+		order, found := s.matcher.ClientOrderStatus(uint32(traderId), parts[2])
+		writeOrder(w, order, found)
+	default:
+		writeError(w, http.StatusNotFound, "unknown resource")
+	}
+}
+
+// writeOrder answers an order status query.
+func writeOrder(w http.ResponseWriter, order matcher.OrderView, found bool) {
+	if !found {
+		writeError(w, http.StatusNotFound, "order not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// authorized checks the caller is the trader it asks about. Without tokens
+// to check only callers on this host are answered.
+func (s *Server) authorized(r *http.Request, traderId uint32) bool {
+	if !s.matcher.AuthRequired() {
+		return loopback(r)
+	}
+	caller, err := strconv.ParseUint(r.Header.Get(TraderIdHeader), 10, 32)
+	if err != nil || uint32(caller) != traderId {
+		return false
+	}
+	return s.matcher.Authenticate(traderId, r.Header.Get(TokenHeader))
+}
+
+// loopback reports whether r comes from this host.
+func loopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *Server) books(w http.ResponseWriter, r *http.Request, parts []string) {
+	stockId, ok := stockIdOf(w, parts)
+	if !ok {
+		return
+	}
+	levels, ok := queryInt(w, r, "levels", matcher.DepthLevels)
+	if !ok {
+		return
+	}
+
+	d := s.matcher.Depth(stockId)
+	book := &Book{StockId: stockId, Bids: []Level{}, Asks: []Level{}, Timestamp: d.GetTimestamp()}
+	for i, l := range d.GetBids() {
+		if i == levels {
+			break
+		}
+		book.Bids = append(book.Bids, Level{Price: l.GetPrice(), Quantity: l.GetQuantity(), Orders: l.GetOrders()})
+	}
+	for i, l := range d.GetAsks() {
+		if i == levels {
+			break
+		}
+		book.Asks = append(book.Asks, Level{Price: l.GetPrice(), Quantity: l.GetQuantity(), Orders: l.GetOrders()})
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+func (s *Server) trades(w http.ResponseWriter, r *http.Request, parts []string) {
+	stockId, ok := stockIdOf(w, parts)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", defaultTradeLimit)
+	if !ok {
+		return
+	}
+
+	trades := make([]Trade, 0, limit)
+	for _, t := range s.matcher.RecentTrades(stockId, limit) {
+		trades = append(trades, Trade{Price: t.GetPrice(), Quantity: t.GetQuantity(), Timestamp: t.GetTimestamp()})
+	}
+	writeJSON(w, http.StatusOK, trades)
+}
+
+func (s *Server) instruments(w http.ResponseWriter, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		writeJSON(w, http.StatusOK, s.matcher.Instruments())
+		return
+	}
+	stockId, ok := stockIdOf(w, parts)
+	if !ok {
+		return
+	}
+	instrument, found := s.matcher.Instrument(stockId)
+	if !found {
+		writeError(w, http.StatusNotFound, "instrument not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, instrument)
+}
+
+// Book is the JSON form of a Depth, bids and asks best first.
+type Book struct {
+	StockId   uint64  `json:"stockId"`
+	Bids      []Level `json:"bids"`
+	Asks      []Level `json:"asks"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+type Level struct {
+	Price    uint64 `json:"price"`
+	Quantity uint64 `json:"quantity"`
+	Orders   uint32 `json:"orders"`
+}
+
+type Trade struct {
+	Price     uint64 `json:"price"`
+	Quantity  uint64 `json:"quantity"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func stockIdOf(w http.ResponseWriter, parts []string) (uint64, bool) {
+	if len(parts) != 1 {
+		writeError(w, http.StatusNotFound, "unknown resource")
+		return 0, false
+	}
+	stockId, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "stock id must be a uint64")
+		return 0, false
+	}
+	return stockId, true
+}
+
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		writeError(w, http.StatusBadRequest, name+" must be a positive integer")
+		return 0, false
+	}
+	return n, true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, text string) {
+	writeJSON(w, code, &Error{Error: text})
+}
@@ -0,0 +1,72 @@
+package rest
+
+import (
+	"main/matcher"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestServer(t *testing.T, cfg matcher.Config) *Server {
+	m, err := matcher.NewMatcherWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewServer(m)
+}
+
+// get queries s from remoteAddr with the headers given as name, value pairs.
+func get(s *Server, path string, remoteAddr string, headers ...string) int {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.RemoteAddr = remoteAddr
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestTraderQueriesWithoutTokensStayLocal(t *testing.T) {
+	s := newTestServer(t, matcher.Config{})
+	for _, c := range []struct {
+		remoteAddr string
+		want       int
+	}{
+		{"127.0.0.1:4000", http.StatusOK},
+		{"[::1]:4000", http.StatusOK},
+		{"192.0.2.1:4000", http.StatusUnauthorized},
+		{"[2001:db8::1]:4000", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		if got := get(s, "/traders/1/orders", c.remoteAddr); got != c.want {
+			t.Errorf("query from %q answered %d, want %d", c.remoteAddr, got, c.want)
+		}
+	}
+	if got := get(s, "/instruments", "192.0.2.1:4000"); got != http.StatusOK {
+		t.Errorf("instruments answered %d to a remote caller, want %d", got, http.StatusOK)
+	}
+}
+
+func TestTraderQueriesCheckToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("1 secret\n2 other\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, matcher.Config{TokenFile: path})
+	for _, c := range []struct {
+		headers []string
+		want    int
+	}{
+		{[]string{TraderIdHeader, "1", TokenHeader, "secret"}, http.StatusOK},
+		{[]string{TraderIdHeader, "1", TokenHeader, "other"}, http.StatusUnauthorized},
+		{[]string{TraderIdHeader, "2", TokenHeader, "other"}, http.StatusUnauthorized},
+		{nil, http.StatusUnauthorized},
+	} {
+		if got := get(s, "/traders/1/positions", "127.0.0.1:4000", c.headers...); got != c.want {
+			t.Errorf("query with %v answered %d, want %d", c.headers, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package rpc
+
+import (
+	"context"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"main/matcher"
+	pb "main/proto"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Metadata keys identifying the caller of every RPC.
+const (
+	TraderIdKey = "trader-id"
+	TokenKey    = "token"
+)
+
+// Server exposes the TradeMatcher as the gRPC TradeService.
+type Server struct {
+	pb.UnimplementedTradeServiceServer
+	matcher *matcher.TradeMatcher
+	grpc    *grpc.Server
+}
+
+func NewServer(m *matcher.TradeMatcher) *Server {
+	s := &Server{
+		matcher: m,
+		grpc:    grpc.NewServer(),
+	}
+	pb.RegisterTradeServiceServer(s.grpc, s)
+	return s
+}
+
+func (s *Server) Start(network string, addr string) error {
+	lis, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	log.Println("Wait for gRPC clients on", addr)
+	return s.Serve(lis)
+}
+
+// Serve answers RPCs on lis until Stop, a loopback listener is enough for tests.
+func (s *Server) Serve(lis net.Listener) error {
+	return s.grpc.Serve(lis)
+}
+
+func (s *Server) Stop() {
+	s.grpc.Stop()
+}
+
+// trader authenticates the caller from the trader-id and token metadata,
+// nobody is let in when the matcher has no tokens to check.
+func (s *Server) trader(ctx context.Context) (uint32, error) {
+	if !s.matcher.AuthRequired() {
+		return 0, status.Error(codes.Unauthenticated, "trading over gRPC needs the engine's token file")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md.Get(TraderIdKey)
+	if len(ids) == 0 {
+		return 0, status.Error(codes.Unauthenticated, "trader-id metadata missing")
+	}
+	traderId, err := strconv.ParseUint(ids[0], 10, 32)
+	if err != nil || traderId == 0 {
+		return 0, status.Error(codes.InvalidArgument, "trader-id must be a non zero uint32")
+	}
+	token := ""
+	if tokens := md.Get(TokenKey); len(tokens) > 0 {
+		token = tokens[0]
+	}
+	if !s.matcher.Authenticate(uint32(traderId), token) {
+		return 0, status.Error(codes.Unauthenticated, "authentication failed")
+	}
+	return uint32(traderId), nil
+}
+
+// submit queues order for the caller's trader, the ack only says the
+// matcher took it, the outcome arrives on SubscribeExecutions.
+func (s *Server) submit(ctx context.Context, order *pb.Order, kinds map[int32]string) (*pb.OrderAck, error) {
+	traderId, err := s.trader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ack := &pb.OrderAck{TraderId: traderId, TradeId: order.GetTradeId(), ClientOrderId: order.GetClientOrderId()}
+	tag, found := kinds[order.GetKind()]
+	switch {
+	case !found:
+		ack.Reason = "unsupported order kind"
+	case !named(order):
+		ack.Reason = "trade_id or client_order_id must be set"
+	case order.GetKind() != pb.CANCEL && order.GetQuantity() == 0 && order.GetDecimalQuantity().GetUnits() == 0:
+		ack.Reason = "quantity must be positive"
+	default:
+		order.Uuid = traderId
+		s.matcher.Submit(order, tag)
+		ack.Accepted = true
+	}
+	return ack, nil
+}
+
+// named reports whether the trader gave order an id, a cancel or an amend
+// may name its order by the id the matcher gave it instead.
+func named(order *pb.Order) bool {
+	if order.GetTradeId() != 0 || order.GetClientOrderId() != "" {
+		return true
+	}
+	return order.GetKind() != pb.BUY && order.GetKind() != pb.SELL && order.GetOrderId() != 0
+}
+
+func (s *Server) SubmitOrder(ctx context.Context, order *pb.Order) (*pb.OrderAck, error) {
+	return s.submit(ctx, order, map[int32]string{pb.BUY: pb.Buy, pb.SELL: pb.Sell})
+}
+
+func (s *Server) CancelOrder(ctx context.Context, order *pb.Order) (*pb.OrderAck, error) {
+	order.Kind = pb.CANCEL
+	return s.submit(ctx, order, map[int32]string{pb.CANCEL: pb.Cancel})
+}
+
+func (s *Server) AmendOrder(ctx context.Context, order *pb.Order) (*pb.OrderAck, error) {
+	order.Kind = pb.AMEND
+	return s.submit(ctx, order, map[int32]string{pb.AMEND: pb.Amend})
+}
+
+// SubscribeExecutions binds the stream as the trader's client, replaying
+// reports from next_seq_num first. A later client of the trader ends it, so
+// does falling too far behind, the reports stay to subscribe again from.
+func (s *Server) SubscribeExecutions(sub *pb.ExecutionSubscription, stream pb.TradeService_SubscribeExecutionsServer) error {
+	traderId, err := s.trader(stream.Context())
+	if err != nil {
+		return err
+	}
+
+	c := &executionStream{
+		traderId: traderId,
+		reports:  make(chan *pb.Order, 65535),
+		done:     make(chan struct{}),
+	}
+	s.matcher.Bind(c)
+	defer s.matcher.Unbind(c)
+	defer c.Stop()
+	if sub.GetNextSeqNum() != 0 {
+		// Replayed while the stream drains, a long replay does not overrun it
+		go s.matcher.Resend(c, &pb.ResendRequest{BeginSeq: sub.GetNextSeqNum()})
+	}
+
+	for {
+		select {
+		case report := <-c.reports:
+			if err := stream.Send(report); err != nil {
+				return err
+			}
+		case <-c.done:
+			return c.err
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// SubscribeBook streams the current depth of each stock, then every trade and depth change.
+func (s *Server) SubscribeBook(sub *pb.BookSubscription, stream pb.TradeService_SubscribeBookServer) error {
+	if _, err := s.trader(stream.Context()); err != nil {
+		return err
+	}
+
+	l := &bookStream{
+		stocks:  make(map[uint64]bool),
+		updates: make(chan *pb.MarketDataUpdate, 65535),
+	}
+	for _, stockId := range sub.GetStockIds() {
+		l.stocks[stockId] = true
+	}
+	s.matcher.Subscribe(l)
+	defer s.matcher.Unsubscribe(l)
+	for _, stockId := range sub.GetStockIds() {
+		if err := stream.Send(&pb.MarketDataUpdate{Depth: s.matcher.Depth(stockId)}); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case update := <-l.updates:
+			if err := stream.Send(update); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// executionStream is the matcher.Client behind SubscribeExecutions. The
+// matcher never waits on it, a reader too slow to keep up is dropped.
+type executionStream struct {
+	traderId uint32
+	reports  chan *pb.Order
+	done     chan struct{}
+	stopOnce sync.Once
+	err      error
+}
+
+func (e *executionStream) TraderId() uint32 {
+	return e.traderId
+}
+
+func (e *executionStream) Send(msg proto.Message, tag string) {
+	report, ok := msg.(*pb.Order)
+	if !ok {
+		return
+	}
+	select {
+	case e.reports <- report:
+	case <-e.done:
+	default:
+		e.end(status.Error(codes.ResourceExhausted, "reports not read in time, subscribe again from next_seq_num"))
+	}
+}
+
+func (e *executionStream) Stop() {
+	e.end(status.Error(codes.Aborted, "another client took over the trader"))
+}
+
+// end closes the stream for err, the first reason is the one it ends with.
+func (e *executionStream) end(err error) {
+	e.stopOnce.Do(func() {
+		e.err = err
+		close(e.done)
+	})
+}
+
+// bookStream is the matcher.MarketDataListener behind SubscribeBook, a
+// reader too slow to keep up loses updates rather than stalling the matcher.
+type bookStream struct {
+	stocks  map[uint64]bool
+	updates chan *pb.MarketDataUpdate
+}
+
+func (b *bookStream) OnTrade(t *pb.Trade) {
+	if b.stocks[t.GetStockId()] {
+		b.offer(&pb.MarketDataUpdate{Trade: t})
+	}
+}
+
+func (b *bookStream) OnDepth(d *pb.Depth) {
+	if b.stocks[d.GetStockId()] {
+		b.offer(&pb.MarketDataUpdate{Depth: d})
+	}
+}
+
+func (b *bookStream) offer(update *pb.MarketDataUpdate) {
+	select {
+	case b.updates <- update:
+	default:
+	}
+}
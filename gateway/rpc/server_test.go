@@ -0,0 +1,145 @@
+package rpc
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"main/matcher"
+	pb "main/proto"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds how long a test waits for the engine.
+const testTimeout = 5 * time.Second
+
+// testTokens lets traders 1 and 2 in, each with its id as the token.
+const testTokens = "1 1\n2 2\n"
+
+// newTestClient serves a fresh matcher over an in-memory connection.
+func newTestClient(t *testing.T, cfg matcher.Config) pb.TradeServiceClient {
+	m, err := matcher.NewMatcherWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go m.Start("tcp", "127.0.0.1:0")
+
+	lis := bufconn.Listen(1 << 20)
+	s := NewServer(m)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewTradeServiceClient(conn)
+}
+
+// withTokens configures a matcher letting in the traders of testTokens.
+func withTokens(t *testing.T) matcher.Config {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(testTokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return matcher.Config{TokenFile: path}
+}
+
+func asTrader(ctx context.Context, traderId string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, TraderIdKey, traderId, TokenKey, traderId)
+}
+
+func submit(t *testing.T, client pb.TradeServiceClient, traderId string, order *pb.Order) {
+	t.Helper()
+	ack, err := client.SubmitOrder(asTrader(context.Background(), traderId), order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ack.GetAccepted() {
+		t.Fatalf("order %v not accepted: %s", order, ack.GetReason())
+	}
+}
+
+func TestSubmitReportsOnExecutionStream(t *testing.T) {
+	client := newTestClient(t, withTokens(t))
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	// Replaying from the first report catches whatever came before the stream was bound
+	stream, err := client.SubscribeExecutions(asTrader(ctx, "1"), &pb.ExecutionSubscription{NextSeqNum: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	submit(t, client, "2", &pb.Order{TradeId: 1, StockId: 1, Kind: pb.SELL, Price: 100, Quantity: 10})
+	submit(t, client, "1", &pb.Order{TradeId: 1, StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 4})
+	fill, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fill.GetSeqNum() != 1 || fill.GetTradeId() != 1 || fill.GetQuantity() != 4 || fill.GetPrice() != 100 {
+		t.Fatalf("trader 1 got %v, want a fill of 4 at 100", fill)
+	}
+
+	ack, err := client.SubmitOrder(asTrader(ctx, "1"), &pb.Order{TradeId: 2, StockId: 1, Kind: pb.BUY, Price: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.GetAccepted() {
+		t.Fatal("order without a quantity accepted")
+	}
+	if _, err := client.SubmitOrder(ctx, &pb.Order{TradeId: 3, StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("order without a trader-id got %v, want Unauthenticated", err)
+	}
+	impostor := metadata.AppendToOutgoingContext(ctx, TraderIdKey, "1", TokenKey, "2")
+	if _, err := client.SubmitOrder(impostor, &pb.Order{TradeId: 3, StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("order with another trader's token got %v, want Unauthenticated", err)
+	}
+}
+
+func TestTradingNeedsTokens(t *testing.T) {
+	client := newTestClient(t, matcher.Config{})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if _, err := client.SubmitOrder(asTrader(ctx, "1"), &pb.Order{TradeId: 1, StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("order on a matcher without tokens got %v, want Unauthenticated", err)
+	}
+	stream, err := client.SubscribeExecutions(asTrader(ctx, "1"), &pb.ExecutionSubscription{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("execution stream on a matcher without tokens got %v, want Unauthenticated", err)
+	}
+}
+
+func TestSlowExecutionStreamDropped(t *testing.T) {
+	e := &executionStream{traderId: 1, reports: make(chan *pb.Order, 1), done: make(chan struct{})}
+	sent := make(chan struct{})
+	go func() {
+		e.Send(&pb.Order{SeqNum: 1}, pb.Buy)
+		e.Send(&pb.Order{SeqNum: 2}, pb.Buy)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(testTimeout):
+		t.Fatal("Send blocked on a stream nobody reads")
+	}
+	select {
+	case <-e.done:
+	default:
+		t.Fatal("stream not ended once full")
+	}
+	if status.Code(e.err) != codes.ResourceExhausted {
+		t.Fatalf("stream ended with %v, want ResourceExhausted", e.err)
+	}
+}
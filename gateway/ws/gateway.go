@@ -0,0 +1,348 @@
+package ws
+
+import (
+	"encoding/json"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"log"
+	"main/matcher"
+	pb "main/proto"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pingInterval = 30 * time.Second
+	readTimeout  = 2 * pingInterval
+)
+
+// Envelope is the JSON frame in both directions, Data carries the
+// protobuf JSON mapping of the message Type stands for.
+//
+// Inbound types: logon (Logon), order (Order, kind picks buy, sell, cancel,
+// amend, list or position), resend (ResendRequest), subscribe and unsubscribe (Subscription).
+// Outbound types: session (TradeSession), fill, cancelled, notCancelled,
+// amended and rejected (Order), orders (OrderList), positions (PositionList),
+// seqReset (SequenceReset), logout (LogoutReason), trade (Trade), depth
+// (Depth) and error (a plain text).
+type Envelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Subscription selects the stocks whose market data a client receives.
+type Subscription struct {
+	StockIds []uint64 `json:"stockIds"`
+}
+
+var reportTypes = map[string]string{
+	pb.TraderID:     "session",
+	pb.Buy:          "fill",
+	pb.Sell:         "fill",
+	pb.Cancel:       "cancelled",
+	pb.NotCancelled: "notCancelled",
+	pb.Amend:        "amended",
+	pb.List:         "orders",
+	pb.Rejected:     "rejected",
+	pb.Expired:      "expired",
+	pb.Positions:    "positions",
+	pb.SeqReset:     "seqReset",
+	pb.Logout:       "logout",
+}
+
+var orderTags = map[int32]string{
+	pb.BUY:      pb.Buy,
+	pb.SELL:     pb.Sell,
+	pb.CANCEL:   pb.Cancel,
+	pb.AMEND:    pb.Amend,
+	pb.LIST:     pb.List,
+	pb.POSITION: pb.Positions,
+}
+
+// Gateway lets browser clients trade over WebSocket with JSON, orders go
+// through the same TradeMatcher and the same authentication as native sessions.
+type Gateway struct {
+	matcher  *matcher.TradeMatcher
+	upgrader websocket.Upgrader
+	origins  map[string]bool
+}
+
+// NewGateway takes browser connections from its own origin and the
+// origins listed, such as "https://dashboard.example.com".
+func NewGateway(m *matcher.TradeMatcher, origins ...string) *Gateway {
+	g := &Gateway{matcher: m, origins: make(map[string]bool)}
+	for _, origin := range origins {
+		g.origins[strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))] = true
+	}
+	g.upgrader.CheckOrigin = g.checkOrigin
+	return g
+}
+
+// checkOrigin keeps pages of other sites from trading on the session of a
+// browser, which without authentication any connection gets.
+func (g *Gateway) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not a browser
+		return true
+	}
+	if g.origins[strings.ToLower(origin)] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func (g *Gateway) Start(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", g)
+	log.Println("Wait for WebSocket clients on", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	log.Println(conn.RemoteAddr().String(), "websocket connect success")
+
+	c := &client{
+		gateway: g,
+		conn:    conn,
+		out:     make(chan []byte, 65535),
+		done:    make(chan struct{}),
+		stocks:  make(map[uint64]bool),
+	}
+	go c.writer()
+	if !g.matcher.AuthRequired() {
+		c.SetTraderId(g.matcher.NextTraderId())
+		g.matcher.Bind(c)
+		c.Send(&pb.TradeSession{TraderId: c.TraderId()}, pb.TraderID)
+	}
+	c.reader()
+}
+
+// client is one WebSocket connection, it is a matcher.Client for the
+// trader it logged on as and a market data listener for its subscriptions.
+type client struct {
+	gateway  *Gateway
+	conn     *websocket.Conn
+	traderId uint32 // accessed atomically
+	bound    uint32 // accessed atomically, set once reports are routed here
+	lagging  uint32 // accessed atomically, set once a report found the buffer full
+	out      chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.Mutex
+	stocks map[uint64]bool
+}
+
+func (c *client) TraderId() uint32 {
+	return atomic.LoadUint32(&c.traderId)
+}
+
+func (c *client) SetTraderId(traderId uint32) {
+	atomic.StoreUint32(&c.traderId, traderId)
+	atomic.StoreUint32(&c.bound, 1)
+}
+
+func (c *client) HeartbeatInterval() time.Duration {
+	return pingInterval
+}
+
+func (c *client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+		c.gateway.matcher.Unsubscribe(c)
+		c.gateway.matcher.Unbind(c)
+	})
+}
+
+func (c *client) Send(msg proto.Message, tag string) {
+	kind, found := reportTypes[tag]
+	if !found {
+		return
+	}
+	c.push(kind, msg, true)
+}
+
+func (c *client) OnTrade(t *pb.Trade) {
+	if c.subscribed(t.GetStockId()) {
+		c.push("trade", t, false)
+	}
+}
+
+func (c *client) OnDepth(d *pb.Depth) {
+	if c.subscribed(d.GetStockId()) {
+		c.push("depth", d, false)
+	}
+}
+
+func (c *client) subscribed(stockId uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stocks[stockId]
+}
+
+// push queues a frame without ever stalling the matcher. Market data is
+// dropped when the client falls behind, a report it has no room for ends
+// the connection instead and the client logs on again to have the rest
+// resent.
+func (c *client) push(kind string, msg proto.Message, report bool) {
+	if atomic.LoadUint32(&c.lagging) == 1 {
+		return
+	}
+	data, err := protojson.Marshal(proto.MessageV2(msg))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	frame, _ := json.Marshal(&Envelope{Type: kind, Data: data})
+	select {
+	case c.out <- frame:
+	default:
+		if report && atomic.CompareAndSwapUint32(&c.lagging, 0, 1) {
+			log.Println("websocket of trader", c.TraderId(), "too slow for its reports, disconnecting")
+			// Stop unbinds, which waits for the matcher this is called from
+			go c.Stop()
+		}
+	}
+}
+
+func (c *client) error(text string) {
+	data, _ := json.Marshal(text)
+	frame, _ := json.Marshal(&Envelope{Type: "error", Data: data})
+	select {
+	case c.out <- frame:
+	case <-c.done:
+	}
+}
+
+func (c *client) writer() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case frame := <-c.out:
+			if err := c.conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				log.Println(err)
+			}
+		case <-ticker.C:
+			c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		case <-c.done:
+			for {
+				select {
+				case frame := <-c.out:
+					c.conn.WriteMessage(websocket.TextMessage, frame)
+				default:
+					c.conn.Close()
+					log.Println(c.conn.RemoteAddr().String(), "websocket is disconnected")
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *client) reader() {
+	defer c.Stop()
+
+	c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			log.Println("websocket read err:", err)
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+
+		env := &Envelope{}
+		if err := json.Unmarshal(data, env); err != nil {
+			c.error(err.Error())
+			continue
+		}
+		if !c.handle(env) {
+			return
+		}
+	}
+}
+
+// handle dispatches one inbound frame, false ends the connection.
+func (c *client) handle(env *Envelope) bool {
+	m := c.gateway.matcher
+	switch env.Type {
+	case "logon":
+		l := &pb.Logon{}
+		if err := protojson.Unmarshal(env.Data, l); err != nil {
+			c.error(err.Error())
+			return true
+		}
+		return m.Logon(c, l)
+	case "subscribe", "unsubscribe":
+		sub := &Subscription{}
+		if err := json.Unmarshal(env.Data, sub); err != nil {
+			c.error(err.Error())
+			return true
+		}
+		c.mu.Lock()
+		for _, stockId := range sub.StockIds {
+			if env.Type == "subscribe" {
+				c.stocks[stockId] = true
+			} else {
+				delete(c.stocks, stockId)
+			}
+		}
+		c.mu.Unlock()
+		if env.Type == "subscribe" {
+			m.Subscribe(c)
+			for _, stockId := range sub.StockIds {
+				c.push("depth", m.Depth(stockId), false)
+			}
+		}
+		return true
+	}
+
+	if atomic.LoadUint32(&c.bound) == 0 {
+		c.push("logout", &pb.LogoutReason{Text: "logon required"}, true)
+		return false
+	}
+	switch env.Type {
+	case "order":
+		o := &pb.Order{}
+		if err := protojson.Unmarshal(env.Data, o); err != nil {
+			c.error(err.Error())
+			return true
+		}
+		tag, found := orderTags[o.GetKind()]
+		if !found {
+			c.error("unsupported order kind")
+			return true
+		}
+		o.Uuid = c.TraderId()
+		m.Submit(o, tag)
+	case "resend":
+		r := &pb.ResendRequest{}
+		if err := protojson.Unmarshal(env.Data, r); err != nil {
+			c.error(err.Error())
+			return true
+		}
+		m.Resend(c, r)
+	default:
+		c.error("unsupported type " + env.Type)
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+package ws
+
+import (
+	"main/matcher"
+	pb "main/proto"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds how long a test waits for the gateway.
+const testTimeout = 5 * time.Second
+
+func TestSlowClientDropped(t *testing.T) {
+	m, err := matcher.NewMatcherWithConfig(matcher.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &client{
+		gateway: NewGateway(m),
+		out:     make(chan []byte, 1),
+		done:    make(chan struct{}),
+		stocks:  map[uint64]bool{1: true},
+	}
+	sent := make(chan struct{})
+	go func() {
+		// Market data the client has no room for is dropped
+		c.OnTrade(&pb.Trade{StockId: 1})
+		c.OnTrade(&pb.Trade{StockId: 1})
+		select {
+		case <-c.done:
+			t.Error("client stopped for market data")
+		default:
+		}
+		c.Send(&pb.Order{SeqNum: 1}, pb.Buy)
+		c.Send(&pb.Order{SeqNum: 2}, pb.Buy)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(testTimeout):
+		t.Fatal("Send blocked on a client nobody reads")
+	}
+	select {
+	case <-c.done:
+	case <-time.After(testTimeout):
+		t.Fatal("client not stopped once its reports had no room")
+	}
+	if len(c.out) != 1 {
+		t.Fatalf("%d frames queued, want the first trade alone", len(c.out))
+	}
+}
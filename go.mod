@@ -2,9 +2,16 @@ module main
 
 go 1.18
 
-require google.golang.org/protobuf v1.28.1
+require (
+	github.com/golang/protobuf v1.5.2
+	github.com/gorilla/websocket v1.5.0
+	google.golang.org/grpc v1.50.1
+	google.golang.org/protobuf v1.28.1
+)
 
 require (
-	github.com/fmstephe/flib v0.0.0-20170802081819-76e5765dde32 // indirect
-	github.com/golang/protobuf v1.5.2 // indirect
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974 // indirect
+	golang.org/x/sys v0.0.0-20210119212857-b64e53b001e4 // indirect
+	golang.org/x/text v0.3.3 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
 )
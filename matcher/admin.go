@@ -0,0 +1,199 @@
+package matcher
+
+import (
+	"errors"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"main/matcher/journal"
+	pb "main/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrUnknownAdminAction = errors.New("unknown admin action")
+
+// adminState is what the admin commands switched off. The matching
+// goroutine decides on it, the lock is for readers elsewhere.
+type adminState struct {
+	mu       sync.RWMutex
+	journal  *journal.Journal
+	disabled map[uint32]bool
+	halted   bool
+	// journaled is how many commands the journal holds, replayed counts
+	// down those a recovery runs again
+	journaled int
+	replayed  int
+}
+
+// newAdminState replays the journal so blocks survive a restart. When the
+// input journal is matched again the commands are run again with it.
+func newAdminState(j *journal.Journal) (*adminState, error) {
+	a := &adminState{
+		journal:  j,
+		disabled: make(map[uint32]bool),
+	}
+	if j == nil {
+		return a, nil
+	}
+	err := j.Replay(func(packet *pb.Packet) error {
+		cmd := &pb.AdminCommand{}
+		if err := proto.Unmarshal(packet.Data, cmd); err != nil {
+			return err
+		}
+		a.apply(cmd)
+		a.journaled++
+		return nil
+	})
+	return a, err
+}
+
+// record journals a command unless a recovery runs it again.
+func (a *adminState) record(data []byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.replayed > 0 {
+		a.replayed--
+		return nil
+	}
+	if a.journal == nil {
+		return nil
+	}
+	a.journaled++
+	return a.journal.Append(pb.Admin, data)
+}
+
+// replay starts over from no command, a recovery runs them again in
+// between the orders they came in with.
+func (a *adminState) replay() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.disabled = make(map[uint32]bool)
+	a.halted = false
+	a.replayed = a.journaled
+}
+
+// recovered ends a recovery, commands the journal missed are written from now on.
+func (a *adminState) recovered() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.replayed = 0
+}
+
+func (a *adminState) apply(cmd *pb.AdminCommand) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	switch cmd.GetAction() {
+	case pb.ADMIN_DISABLE_TRADER:
+		a.disabled[cmd.GetTraderId()] = true
+	case pb.ADMIN_ENABLE_TRADER:
+		delete(a.disabled, cmd.GetTraderId())
+	case pb.ADMIN_KILL:
+		a.halted = true
+	case pb.ADMIN_RESUME:
+		a.halted = false
+	}
+}
+
+// blocked returns why the trader may not send new orders, nil when it may.
+func (a *adminState) blocked(traderId uint32) *Reject {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	switch {
+	case a.halted:
+		return &Reject{pb.REJECT_HALTED, "the engine is not accepting orders"}
+	case a.disabled[traderId]:
+		return &Reject{pb.REJECT_TRADER_DISABLED, "the trader is disabled"}
+	}
+	return nil
+}
+
+// Admin queues a command for the matching goroutine, it runs in between
+// two orders so every order sees either the state before it or after it.
+func (m *TradeMatcher) Admin(cmd *pb.AdminCommand) error {
+	if cmd.GetAction() <= pb.ADMIN_NONE || cmd.GetAction() > pb.ADMIN_END_OF_DAY {
+		return ErrUnknownAdminAction
+	}
+	if cmd.GetTimestamp() == 0 {
+		cmd.Timestamp = time.Now().UnixNano()
+	}
+	data, err := proto.Marshal(cmd)
+	if err != nil {
+		return err
+	}
+	m.queue(0, pb.NewPacket(pb.Admin, data))
+	return nil
+}
+
+// Halted reports whether a kill switch stopped the engine accepting orders.
+func (m *TradeMatcher) Halted() bool {
+	m.admin.mu.RLock()
+	defer m.admin.mu.RUnlock()
+	return m.admin.halted
+}
+
+// DisabledTraders returns the traders blocked from sending new orders.
+func (m *TradeMatcher) DisabledTraders() []uint32 {
+	m.admin.mu.RLock()
+	defer m.admin.mu.RUnlock()
+
+	result := make([]uint32, 0, len(m.admin.disabled))
+	for traderId := range m.admin.disabled {
+		result = append(result, traderId)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// runAdmin journals a command and carries it out on the workers owning the
+// books it touches, behind the orders they already have queued.
+func (m *TradeMatcher) runAdmin(packet *pb.Packet) {
+	cmd := &pb.AdminCommand{}
+	if err := proto.Unmarshal(packet.Data, cmd); err != nil {
+		log.Println(err)
+		return
+	}
+	log.Println("admin:", cmd)
+	if err := m.admin.record(packet.Data); err != nil {
+		log.Println(err)
+	}
+	m.admin.apply(cmd)
+	reason := "admin"
+	if cmd.GetReason() != "" {
+		reason += ": " + cmd.GetReason()
+	}
+
+	switch cmd.GetAction() {
+	case pb.ADMIN_DISABLE_TRADER:
+		m.eachShard(func(w *worker) {
+			for _, stockId := range w.stockIds() {
+				w.cancelAll(stockId, w.matchQueues[stockId].TraderOrders(cmd.GetTraderId()), reason)
+			}
+		})
+	case pb.ADMIN_CANCEL_STOCK:
+		m.onShard(cmd.GetStockId(), func(w *worker) {
+			if q, found := w.matchQueues[cmd.GetStockId()]; found {
+				w.cancelAll(cmd.GetStockId(), q.Orders(), reason)
+			}
+		})
+	case pb.ADMIN_KILL:
+		m.eachShard(func(w *worker) {
+			for _, stockId := range w.stockIds() {
+				w.cancelAll(stockId, w.matchQueues[stockId].Orders(), reason)
+			}
+		})
+	case pb.ADMIN_OPEN_STOCK:
+		m.onShard(cmd.GetStockId(), func(w *worker) {
+			m.instruments.setStatus(cmd.GetStockId(), InstrumentTrading)
+		})
+	case pb.ADMIN_CLOSE_STOCK:
+		m.onShard(cmd.GetStockId(), func(w *worker) {
+			w.closeStock(cmd.GetStockId(), time.Unix(0, cmd.GetTimestamp()))
+		})
+	case pb.ADMIN_END_OF_DAY:
+		m.endOfDay(time.Unix(0, cmd.GetTimestamp()))
+	}
+}
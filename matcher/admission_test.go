@@ -0,0 +1,57 @@
+package matcher
+
+import (
+	pb "main/proto"
+	"testing"
+)
+
+func TestDuplicateClientOrderIdRejected(t *testing.T) {
+	m := startTestMatcher(t, Config{JournalDir: t.TempDir()})
+	trader := bindTestClient(m, 1)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 1, ClientOrderId: "a", StockId: 1, Kind: pb.BUY, Price: 90, Quantity: 5}, pb.Buy)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 2, ClientOrderId: "a", StockId: 1, Kind: pb.BUY, Price: 80, Quantity: 5}, pb.Buy)
+	reject := trader.wait(t, 1)[0]
+	if reject.GetRejectCode() != pb.REJECT_DUPLICATE_ORDER || reject.GetTradeId() != 2 {
+		t.Fatalf("second order got %v, want it rejected as a duplicate", reject)
+	}
+	bids, _ := m.Book(1)
+	if len(bids) != 1 || bids[0].Price != 90 {
+		t.Fatalf("bids are %v, want the first order alone", bids)
+	}
+
+	// Once the first is gone its name is free again
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 3, ClientOrderId: "a", StockId: 1, Kind: pb.CANCEL}, pb.Cancel)
+	trader.wait(t, 1)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 4, ClientOrderId: "a", StockId: 1, Kind: pb.BUY, Price: 80, Quantity: 5}, pb.Buy)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 4, ClientOrderId: "a", StockId: 1, Kind: pb.CANCEL}, pb.Cancel)
+	if cancelled := trader.wait(t, 1)[0]; cancelled.GetPrice() != 80 {
+		t.Fatalf("cancel got %v, want the order at 80", cancelled)
+	}
+}
+
+func TestReplaceCountsFillsInFlight(t *testing.T) {
+	m := startTestMatcher(t, Config{})
+	buyer := bindTestClient(m, 1)
+	bindTestClient(m, 2)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 10}, pb.Buy)
+	// The fill lands after the buyer sent its replace for 8 in total, having seen none
+	m.Submit(&pb.Order{Uuid: 2, TradeId: 1, StockId: 1, Kind: pb.SELL, Price: 100, Quantity: 4}, pb.Sell)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Kind: pb.AMEND, Price: 99, OrderQuantity: 8}, pb.Amend)
+
+	reports := buyer.wait(t, 2)
+	if reports[0].GetQuantity() != 4 {
+		t.Fatalf("buyer got %v, want a fill of 4", reports[0])
+	}
+	if amended := reports[1]; amended.GetKind() != pb.AMEND || amended.GetQuantity() != 4 || amended.GetPrice() != 99 {
+		t.Fatalf("buyer got %v, want 4 left at 99", amended)
+	}
+	// Replacing down to what has filled leaves nothing to amend
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Kind: pb.AMEND, Price: 99, OrderQuantity: 4}, pb.Amend)
+	if reject := buyer.wait(t, 1)[0]; reject.GetRejectCode() != pb.REJECT_OVERFILLED {
+		t.Fatalf("buyer got %v, want the replace rejected as overfilled", reject)
+	}
+	// The book was published before the reject was matched
+	if bids, _ := m.Book(1); len(bids) != 1 || bids[0].Quantity != 4 || bids[0].Price != 99 {
+		t.Fatalf("bids are %v, want the 4 left of the 8 at 99", bids)
+	}
+}
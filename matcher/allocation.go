@@ -0,0 +1,251 @@
+package matcher
+
+import (
+	"log"
+	"main/matcher/pqueue"
+	pb "main/proto"
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// Allocation splits what an aggressor takes from a price level among the
+// orders resting there. It is given the aggressor's quantity and the
+// resting sizes in time priority, and returns the share of each. The
+// shares must add up to the quantity, or to the whole level when the
+// aggressor takes more, and no share may exceed its order.
+type Allocation interface {
+	Allocate(quantity uint64, resting []uint64) []uint64
+}
+
+// FIFO fills the resting orders one after another in time priority, it is
+// what every stock without an allocation of its own matches by.
+type FIFO struct{}
+
+func (FIFO) Allocate(quantity uint64, resting []uint64) []uint64 {
+	shares := make([]uint64, len(resting))
+	for i, size := range resting {
+		if quantity == 0 {
+			break
+		}
+		shares[i] = size
+		if size > quantity {
+			shares[i] = quantity
+		}
+		quantity -= shares[i]
+	}
+	return shares
+}
+
+// Rounding decides who gets the lots left over once the pro-rata shares
+// are rounded down.
+type Rounding int
+
+const (
+	// RemainderByTime hands the leftover lots out in time priority.
+	RemainderByTime Rounding = iota
+	// RemainderBySize hands them out to the largest orders first, time
+	// priority breaking ties.
+	RemainderBySize
+)
+
+// ProRata splits the quantity in proportion to the resting sizes.
+type ProRata struct {
+	// MinAllocation is the smallest share worth giving, smaller shares go
+	// to the remainder instead. Zero keeps every share.
+	MinAllocation uint64
+	// Rounding picks who gets the remainder.
+	Rounding Rounding
+}
+
+func (p ProRata) Allocate(quantity uint64, resting []uint64) []uint64 {
+	hi, total := sizeSum(resting)
+	shares := make([]uint64, len(resting))
+	if hi == 0 && quantity >= total {
+		copy(shares, resting)
+		return shares
+	}
+
+	weights := resting
+	if hi != 0 {
+		// The level holds more than a uint64 adds up to, weigh the orders by
+		// their sizes shifted down until the total fits and leave what the
+		// coarser weights lose to the remainder.
+		shift := uint(bits.Len64(hi))
+		weights = make([]uint64, len(resting))
+		total = 0
+		for i, size := range resting {
+			weights[i] = size >> shift
+			total += weights[i]
+		}
+	}
+
+	left := quantity
+	for i, weight := range weights {
+		if total == 0 {
+			break
+		}
+		share := mulDiv(quantity, weight, total)
+		if share > resting[i] {
+			share = resting[i]
+		}
+		if share < p.MinAllocation {
+			share = 0
+		}
+		shares[i] = share
+		left -= share
+	}
+
+	order := make([]int, len(resting))
+	for i := range order {
+		order[i] = i
+	}
+	if p.Rounding == RemainderBySize {
+		sort.SliceStable(order, func(i, j int) bool { return resting[order[i]] > resting[order[j]] })
+	}
+	for _, i := range order {
+		if left == 0 {
+			break
+		}
+		more := resting[i] - shares[i]
+		if more > left {
+			more = left
+		}
+		shares[i] += more
+		left -= more
+	}
+	return shares
+}
+
+// Hybrid fills the first order in time priority before splitting the rest
+// pro-rata, the first order taking part in the split with what it has left.
+type Hybrid struct {
+	// TopOrderMax caps what the first order takes up front, zero lets it take all of its size.
+	TopOrderMax uint64
+	ProRata     ProRata
+}
+
+func (h Hybrid) Allocate(quantity uint64, resting []uint64) []uint64 {
+	if len(resting) == 0 {
+		return nil
+	}
+	top := resting[0]
+	if h.TopOrderMax != 0 && top > h.TopOrderMax {
+		top = h.TopOrderMax
+	}
+	if top > quantity {
+		top = quantity
+	}
+	rest := make([]uint64, len(resting))
+	copy(rest, resting)
+	rest[0] -= top
+
+	shares := h.ProRata.Allocate(quantity-top, rest)
+	shares[0] += top
+	return shares
+}
+
+// sizeSum adds up sizes in 128 bits, hi is zero unless the sum overflows
+// a uint64.
+func sizeSum(sizes []uint64) (hi uint64, lo uint64) {
+	var carry uint64
+	for _, size := range sizes {
+		lo, carry = bits.Add64(lo, size, 0)
+		hi += carry
+	}
+	return hi, lo
+}
+
+// mulDiv returns a*b/c rounded down without overflowing on the way, it
+// saturates at the largest uint64 when the result does not fit, never
+// when a <= c.
+func mulDiv(a uint64, b uint64, c uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	if hi >= c {
+		return math.MaxUint64
+	}
+	q, _ := bits.Div64(hi, lo, c)
+	return q
+}
+
+// allocate matches aggressor a, sequenced at now, against the other side of
+// q level by level while the prices cross, splitting each level by alloc.
+// It reports whether a was used up.
+func (w *worker) allocate(a *pqueue.OrderNode, q *pqueue.MatchQueues, alloc Allocation, now time.Time) bool {
+	for {
+		var level []*pqueue.OrderNode
+		if a.Kind() == pb.BUY {
+			level = q.BestSells()
+		} else {
+			level = q.BestBuys()
+		}
+		if len(level) == 0 || !crosses(a, level[0]) {
+			return false
+		}
+
+		resting := make([]uint64, len(level))
+		for i, o := range level {
+			resting[i] = o.Quantity()
+		}
+		shares := alloc.Allocate(a.Quantity(), resting)
+		if !validShares(shares, a.Quantity(), resting) {
+			log.Printf("allocation of stock %d is off, filling in time priority", a.StockId())
+			shares = FIFO{}.Allocate(a.Quantity(), resting)
+		}
+		for i, o := range level {
+			if shares[i] != 0 {
+				w.fill(a, o, shares[i], now)
+			}
+		}
+		if a.Quantity() == 0 {
+			w.slab.Free(a)
+			return true
+		}
+	}
+}
+
+func crosses(a *pqueue.OrderNode, resting *pqueue.OrderNode) bool {
+	if a.Kind() == pb.BUY {
+		return a.Price() >= resting.Price()
+	}
+	return resting.Price() >= a.Price()
+}
+
+// validShares checks an allocation keeps the promises of Allocation.
+func validShares(shares []uint64, quantity uint64, resting []uint64) bool {
+	if len(shares) != len(resting) {
+		return false
+	}
+	want := quantity
+	if hi, total := sizeSum(resting); hi == 0 && total < want {
+		want = total
+	}
+	var sum, carry uint64
+	for i, share := range shares {
+		if share > resting[i] {
+			return false
+		}
+		sum, carry = bits.Add64(sum, share, 0)
+		if carry != 0 {
+			return false
+		}
+	}
+	return sum == want
+}
+
+// fill trades quantity between aggressor a and the resting order o, taking
+// o off the book once it is used up.
+func (w *worker) fill(a *pqueue.OrderNode, o *pqueue.OrderNode, quantity uint64, now time.Time) {
+	b, s := a, o
+	if a.Kind() == pb.SELL {
+		b, s = o, a
+	}
+	a.ReduceQuantity(quantity)
+	o.ReduceQuantity(quantity)
+	w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price(b.Price(), s.Price()), quantity, a.Kind(), now)
+	if o.Quantity() == 0 {
+		o.Remove()
+		w.slab.Free(o)
+	}
+}
@@ -0,0 +1,65 @@
+package matcher
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	const max = math.MaxUint64
+	for _, c := range []struct {
+		name     string
+		alloc    Allocation
+		quantity uint64
+		resting  []uint64
+		want     []uint64
+	}{
+		{"fifo", FIFO{}, 7, []uint64{3, 3, 3}, []uint64{3, 3, 1}},
+		{"pro-rata takes the level", ProRata{}, 10, []uint64{3, 3, 3}, []uint64{3, 3, 3}},
+		{"pro-rata remainder by time", ProRata{}, 7, []uint64{3, 3, 3}, []uint64{3, 2, 2}},
+		{"pro-rata remainder by time", ProRata{}, 10, []uint64{2, 5, 5, 8}, []uint64{2, 2, 2, 4}},
+		{"pro-rata remainder by size", ProRata{Rounding: RemainderBySize}, 10, []uint64{2, 5, 5, 8}, []uint64{1, 2, 2, 5}},
+		{"pro-rata size ties by time", ProRata{Rounding: RemainderBySize}, 2, []uint64{1, 3, 3}, []uint64{0, 2, 0}},
+		{"min allocation by time", ProRata{MinAllocation: 2}, 10, []uint64{8, 2, 5, 5}, []uint64{6, 0, 2, 2}},
+		{"min allocation by size", ProRata{MinAllocation: 2, Rounding: RemainderBySize}, 10, []uint64{2, 5, 5, 8}, []uint64{0, 2, 2, 6}},
+		{"pro-rata level over a uint64", ProRata{}, 3, []uint64{max, max, 1}, []uint64{2, 1, 0}},
+		{"pro-rata all of a uint64", ProRata{}, max, []uint64{max, max}, []uint64{1 << 63, 1<<63 - 1}},
+		{"hybrid top capped", Hybrid{TopOrderMax: 2}, 10, []uint64{4, 6, 10}, []uint64{4, 2, 4}},
+		{"hybrid top uncapped", Hybrid{}, 5, []uint64{3, 4, 4}, []uint64{3, 1, 1}},
+		{"hybrid top takes all", Hybrid{}, 2, []uint64{5, 5}, []uint64{2, 0}},
+		{"hybrid min allocation by size", Hybrid{TopOrderMax: 1, ProRata: ProRata{MinAllocation: 2, Rounding: RemainderBySize}}, 9, []uint64{3, 2, 10}, []uint64{1, 0, 8}},
+		{"hybrid level over a uint64", Hybrid{TopOrderMax: 1}, 4, []uint64{max, max, max}, []uint64{2, 1, 1}},
+	} {
+		got := c.alloc.Allocate(c.quantity, c.resting)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: %d against %v is split %v, want %v", c.name, c.quantity, c.resting, got, c.want)
+		}
+		if !validShares(got, c.quantity, c.resting) {
+			t.Errorf("%s: %v does not split %d against %v", c.name, got, c.quantity, c.resting)
+		}
+	}
+}
+
+func TestValidShares(t *testing.T) {
+	const max = math.MaxUint64
+	for _, c := range []struct {
+		shares   []uint64
+		quantity uint64
+		resting  []uint64
+		want     bool
+	}{
+		{[]uint64{2, 1}, 3, []uint64{2, 2}, true},
+		{[]uint64{2, 2}, 9, []uint64{2, 2}, true},
+		{[]uint64{2, 0}, 3, []uint64{2, 2}, false},
+		{[]uint64{3, 0}, 3, []uint64{2, 2}, false},
+		{[]uint64{3}, 3, []uint64{2, 2}, false},
+		{[]uint64{max, 0}, max, []uint64{max, max}, true},
+		{[]uint64{max, 1}, max, []uint64{max, max}, false},
+		{[]uint64{max, max}, max, []uint64{max, max}, false},
+	} {
+		if got := validShares(c.shares, c.quantity, c.resting); got != c.want {
+			t.Errorf("%v for %d against %v is valid: %v, want %v", c.shares, c.quantity, c.resting, got, c.want)
+		}
+	}
+}
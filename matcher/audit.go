@@ -0,0 +1,233 @@
+package matcher
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"main/matcher/journal"
+	"main/matcher/pqueue"
+	pb "main/proto"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audit events of the order lifecycle.
+const (
+	AuditReceived        = "received"
+	AuditAccepted        = "accepted"
+	AuditRejected        = "rejected"
+	AuditAmended         = "amended"
+	AuditPartiallyFilled = "partially_filled"
+	AuditFilled          = "filled"
+	AuditCancelled       = "cancelled"
+	AuditExpired         = "expired"
+)
+
+// auditTag tags the audit records in their journal.
+const auditTag = "audit"
+
+// AuditEvent is one step in the life of an order. Quantity is what the
+// event is about, the order size or the fill, Remaining what is left after it.
+type AuditEvent struct {
+	Seq           uint64 `json:"seq"`
+	Time          int64  `json:"time"`
+	Date          string `json:"date"`
+	Event         string `json:"event"`
+	TraderId      uint32 `json:"traderId"`
+	SessionId     uint64 `json:"sessionId"`
+	OrderId       uint64 `json:"orderId"`
+	TradeId       uint32 `json:"tradeId"`
+	ClientOrderId string `json:"clientOrderId,omitempty"`
+	StockId       uint64 `json:"stockId"`
+	Kind          int32  `json:"kind"`
+	Price         uint64 `json:"price"`
+	Quantity      uint64 `json:"quantity"`
+	Remaining     uint64 `json:"remaining"`
+	Reason        string `json:"reason,omitempty"`
+}
+
+var auditHeader = []string{"seq", "time", "date", "event", "trader_id", "session_id", "order_id", "trade_id", "client_order_id", "stock_id", "kind", "price", "quantity", "remaining", "reason"}
+
+func (e *AuditEvent) record() []string {
+	return []string{
+		strconv.FormatUint(e.Seq, 10),
+		strconv.FormatInt(e.Time, 10),
+		e.Date,
+		e.Event,
+		strconv.FormatUint(uint64(e.TraderId), 10),
+		strconv.FormatUint(e.SessionId, 10),
+		strconv.FormatUint(e.OrderId, 10),
+		strconv.FormatUint(uint64(e.TradeId), 10),
+		e.ClientOrderId,
+		strconv.FormatUint(e.StockId, 10),
+		strconv.FormatInt(int64(e.Kind), 10),
+		strconv.FormatUint(e.Price, 10),
+		strconv.FormatUint(e.Quantity, 10),
+		strconv.FormatUint(e.Remaining, 10),
+		e.Reason,
+	}
+}
+
+// auditTrail is the append only record of every order event. Events are
+// journaled before they are kept so nothing exported can be lost later.
+type auditTrail struct {
+	mu      sync.RWMutex
+	journal *journal.Journal
+	events  []AuditEvent
+	// replayed counts down the events of the journal a recovery records again
+	replayed int
+}
+
+func newAuditTrail(j *journal.Journal) (*auditTrail, error) {
+	a := &auditTrail{journal: j}
+	if j == nil {
+		return a, nil
+	}
+	err := j.Replay(func(packet *pb.Packet) error {
+		e := AuditEvent{}
+		if err := json.Unmarshal(packet.Data, &e); err != nil {
+			return err
+		}
+		a.events = append(a.events, e)
+		return nil
+	})
+	return a, err
+}
+
+func (a *auditTrail) append(e AuditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.replayed > 0 {
+		a.replayed--
+		return
+	}
+	e.Seq = uint64(len(a.events)) + 1
+	if a.journal != nil {
+		data, err := json.Marshal(&e)
+		if err == nil {
+			err = a.journal.Append(auditTag, data)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	a.events = append(a.events, e)
+}
+
+// replay lets the next events, as many as the journal holds, pass as the
+// ones a recovery records again.
+func (a *auditTrail) replay() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.replayed = len(a.events)
+}
+
+// recovered ends a recovery, events the journal missed are kept from now on.
+func (a *auditTrail) recovered() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.replayed = 0
+}
+
+// audit records an event of the matching goroutine stamped with the time
+// and the session of its trader, the caller must not hold m.r.
+func (m *TradeMatcher) audit(e AuditEvent) {
+	now := time.Now()
+	m.r.RLock()
+	e.SessionId = m.sessionIds[e.TraderId]
+	m.r.RUnlock()
+
+	e.Time = now.UnixNano()
+	e.Date = now.In(m.schedule.Calendar.location()).Format("2006-01-02")
+	m.auditTrail.append(e)
+}
+
+func (m *TradeMatcher) auditOrder(event string, order *pb.Order, remaining uint64, reason string) {
+	m.audit(AuditEvent{
+		Event:         event,
+		TraderId:      order.GetUuid(),
+		OrderId:       order.GetOrderId(),
+		TradeId:       order.GetTradeId(),
+		ClientOrderId: order.GetClientOrderId(),
+		StockId:       order.GetStockId(),
+		Kind:          order.GetKind(),
+		Price:         order.GetPrice(),
+		Quantity:      order.GetQuantity(),
+		Remaining:     remaining,
+		Reason:        reason,
+	})
+}
+
+func (m *TradeMatcher) auditNode(event string, o *pqueue.OrderNode, remaining uint64, reason string) {
+	m.audit(nodeEvent(event, o, o.Price(), o.Quantity(), remaining, reason))
+}
+
+// auditFill records one side of a fill with what the order has left.
+func (m *TradeMatcher) auditFill(o *pqueue.OrderNode, price uint64, quantity uint64) {
+	v, _ := m.OrderStatus(o.Uuid(), o.OrderId())
+	event := AuditFilled
+	if v.Remaining != 0 {
+		event = AuditPartiallyFilled
+	}
+	m.audit(nodeEvent(event, o, price, quantity, v.Remaining, ""))
+}
+
+func nodeEvent(event string, o *pqueue.OrderNode, price uint64, quantity uint64, remaining uint64, reason string) AuditEvent {
+	return AuditEvent{
+		Event:         event,
+		TraderId:      o.Uuid(),
+		OrderId:       o.OrderId(),
+		TradeId:       o.TradeId(),
+		ClientOrderId: o.ClientOrderId(),
+		StockId:       o.StockId(),
+		Kind:          o.Kind(),
+		Price:         price,
+		Quantity:      quantity,
+		Remaining:     remaining,
+		Reason:        reason,
+	}
+}
+
+// AuditTrail returns the events of a trading day, "2006-01-02", in order.
+func (m *TradeMatcher) AuditTrail(date string) []AuditEvent {
+	a := m.auditTrail
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := []AuditEvent{}
+	for _, e := range a.events {
+		if e.Date == date {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// WriteAuditCSV exports the events of a trading day with a header line.
+func (m *TradeMatcher) WriteAuditCSV(w io.Writer, date string) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(auditHeader); err != nil {
+		return err
+	}
+	for _, e := range m.AuditTrail(date) {
+		if err := cw.Write(e.record()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteAuditJSONL exports the events of a trading day one JSON object a line.
+func (m *TradeMatcher) WriteAuditJSONL(w io.Writer, date string) error {
+	enc := json.NewEncoder(w)
+	for _, e := range m.AuditTrail(date) {
+		if err := enc.Encode(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
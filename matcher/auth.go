@@ -0,0 +1,89 @@
+package matcher
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Authenticator checks the credentials a client presents at logon, every
+// gateway goes through the one configured on the TradeMatcher.
+type Authenticator interface {
+	Authenticate(traderId uint32, token string) bool
+}
+
+// TokenAuthenticator accepts a trader when it presents its shared token.
+type TokenAuthenticator struct {
+	mu     sync.RWMutex
+	tokens map[uint32]string
+}
+
+func NewTokenAuthenticator() *TokenAuthenticator {
+	return &TokenAuthenticator{tokens: make(map[uint32]string)}
+}
+
+// LoadTokenFile reads "trader_id token" lines, blank lines and # comments are skipped.
+func LoadTokenFile(path string) (*TokenAuthenticator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	a := NewTokenAuthenticator()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want \"trader_id token\"", path, line)
+		}
+		traderId, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		a.SetToken(uint32(traderId), fields[1])
+	}
+	return a, scanner.Err()
+}
+
+func (a *TokenAuthenticator) SetToken(traderId uint32, token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tokens[traderId] = token
+}
+
+func (a *TokenAuthenticator) Authenticate(traderId uint32, token string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	expected, found := a.tokens[traderId]
+	return found && token != "" && token == expected
+}
+
+// Authenticate reports whether a client may act for traderId, every
+// client may when the matcher has no Authenticator, so callers taking a
+// trader id from the client have to check AuthRequired first. Drop copy
+// ids only logon with the drop copy role.
+func (m *TradeMatcher) Authenticate(traderId uint32, token string) bool {
+	if m.dropCopies.stream(traderId) != nil {
+		return false
+	}
+	return m.auth == nil || m.auth.Authenticate(traderId, token)
+}
+
+// AuthRequired reports whether clients have to logon before trading.
+func (m *TradeMatcher) AuthRequired() bool {
+	return m.auth != nil
+}
+
+// AuthenticateAdmin reports whether token is the operator token, nothing
+// passes when the matcher has none configured.
+func (m *TradeMatcher) AuthenticateAdmin(token string) bool {
+	return m.adminToken != "" && token == m.adminToken
+}
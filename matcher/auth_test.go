@@ -0,0 +1,86 @@
+package matcher
+
+import (
+	pb "main/proto"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeTokenFile saves "trader_id token" lines for a matcher to load.
+func writeTokenFile(t *testing.T, lines string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// logonClient is a test client that can logon as another trader.
+type logonClient struct {
+	*testClient
+	id      uint32 // accessed atomically
+	stopped int32  // accessed atomically
+}
+
+func bindLogonClient(m *TradeMatcher, traderId uint32) *logonClient {
+	c := &logonClient{testClient: &testClient{traderId: traderId, reports: make(chan *pb.Order, 1024)}, id: traderId}
+	m.Bind(c)
+	return c
+}
+
+func (c *logonClient) TraderId() uint32 {
+	return atomic.LoadUint32(&c.id)
+}
+
+func (c *logonClient) SetTraderId(traderId uint32) {
+	atomic.StoreUint32(&c.id, traderId)
+}
+
+func (c *logonClient) HeartbeatInterval() time.Duration {
+	return time.Second
+}
+
+func (c *logonClient) Stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+}
+
+func (c *logonClient) isStopped() bool {
+	return atomic.LoadInt32(&c.stopped) == 1
+}
+
+func TestLogonWithoutTokensKeepsTraderId(t *testing.T) {
+	m := startTestMatcher(t, Config{})
+	owner := bindLogonClient(m, 5)
+	impostor := bindLogonClient(m, 9)
+
+	if m.Logon(impostor, &pb.Logon{TraderId: 5}) {
+		t.Fatal("logon as another trader passed without a token file")
+	}
+	if !impostor.isStopped() || owner.isStopped() || impostor.TraderId() != 9 {
+		t.Fatal("the impostor should be stopped and the trader left trading")
+	}
+	if !m.Logon(owner, &pb.Logon{TraderId: 5}) {
+		t.Fatal("logon as the trader the session already is was refused")
+	}
+}
+
+func TestLogonChecksToken(t *testing.T) {
+	m := startTestMatcher(t, Config{TokenFile: writeTokenFile(t, "# traders\n5 secret\n")})
+	owner := bindLogonClient(m, 5)
+
+	impostor := bindLogonClient(m, 9)
+	if m.Logon(impostor, &pb.Logon{TraderId: 5, Token: "guess"}) || owner.isStopped() {
+		t.Fatal("logon with the wrong token passed")
+	}
+	reconnected := bindLogonClient(m, 10)
+	if !m.Logon(reconnected, &pb.Logon{TraderId: 5, Token: "secret"}) || reconnected.TraderId() != 5 {
+		t.Fatal("logon with the token was refused")
+	}
+	if !owner.isStopped() {
+		t.Fatal("the session the trader left was not stopped")
+	}
+}
@@ -0,0 +1,169 @@
+package matcher
+
+import (
+	"fmt"
+	pb "main/proto"
+	"time"
+)
+
+// Calendar says which days are trading days.
+type Calendar struct {
+	// Location is the time zone of the sessions, nil is local time.
+	Location *time.Location
+	// Holidays are the "2006-01-02" dates without trading besides weekends.
+	Holidays []string
+	// Weekends trades on Saturdays and Sundays too.
+	Weekends bool
+}
+
+func (c Calendar) location() *time.Location {
+	if c.Location == nil {
+		return time.Local
+	}
+	return c.Location
+}
+
+func (c Calendar) TradingDay(t time.Time) bool {
+	t = t.In(c.location())
+	if !c.Weekends && (t.Weekday() == time.Saturday || t.Weekday() == time.Sunday) {
+		return false
+	}
+	day := t.Format("2006-01-02")
+	for _, holiday := range c.Holidays {
+		if holiday == day {
+			return false
+		}
+	}
+	return true
+}
+
+// TradingHours is when a stock trades on trading days, as offsets from
+// midnight. A zero Close trades around the clock, holidays included.
+type TradingHours struct {
+	Open  time.Duration
+	Close time.Duration
+}
+
+func (s TradingHours) always() bool {
+	return s.Close == 0
+}
+
+func (s TradingHours) open(t time.Time, c Calendar) bool {
+	if s.always() {
+		return true
+	}
+	since := sinceMidnight(t, c)
+	return c.TradingDay(t) && since >= s.Open && since < s.Close
+}
+
+// Schedule is the trading calendar and the session of every stock.
+type Schedule struct {
+	Calendar Calendar
+	// Default is the session of every stock not listed in Instruments.
+	Default     TradingHours
+	Instruments map[uint64]TradingHours
+	// EndOfDay is when the end of day job runs on trading days, zero runs
+	// it at the latest close and never when no stock ever closes.
+	EndOfDay time.Duration
+}
+
+func (s Schedule) hours(stockId uint64) TradingHours {
+	if session, found := s.Instruments[stockId]; found {
+		return session
+	}
+	return s.Default
+}
+
+func (s Schedule) endOfDay() time.Duration {
+	if s.EndOfDay != 0 {
+		return s.EndOfDay
+	}
+	last := s.Default.Close
+	for _, session := range s.Instruments {
+		if session.Close > last {
+			last = session.Close
+		}
+	}
+	return last
+}
+
+func sinceMidnight(t time.Time, c Calendar) time.Duration {
+	t = t.In(c.location())
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+}
+
+// dateOf is the YYYYMMDD of t in the calendar's time zone, the form good
+// till date orders carry their expire date in.
+func dateOf(t time.Time, c Calendar) uint32 {
+	t = t.In(c.location())
+	return uint32(t.Year()*10000 + int(t.Month())*100 + t.Day())
+}
+
+// tradable rejects orders outside the session of their stock and good
+// till date orders already expired.
+func (m *TradeMatcher) tradable(order *pb.Order, now time.Time) *Reject {
+	if !m.schedule.hours(order.GetStockId()).open(now, m.schedule.Calendar) {
+		return &Reject{pb.REJECT_MARKET_CLOSED, fmt.Sprintf("stock %d is not in its trading session", order.GetStockId())}
+	}
+	if order.GetKind() == pb.AMEND {
+		return nil
+	}
+	switch order.GetTimeInForce() {
+	case pb.TIF_GTC, pb.TIF_DAY:
+		return nil
+	case pb.TIF_GTD:
+		if today := dateOf(now, m.schedule.Calendar); order.GetExpireDate() < today {
+			return &Reject{pb.REJECT_INVALID_EXPIRY, fmt.Sprintf("expire date %d is before %d", order.GetExpireDate(), today)}
+		}
+		return nil
+	}
+	return &Reject{pb.REJECT_INVALID_EXPIRY, fmt.Sprintf("unknown time in force %d", order.GetTimeInForce())}
+}
+
+// runSchedule opens and closes the stocks with a session and starts the
+// end of day job, all as admin commands so they run in between orders.
+func (m *TradeMatcher) runSchedule() {
+	s := m.schedule
+	eod := s.endOfDay()
+	if eod == 0 && s.Default.always() && len(s.Instruments) == 0 {
+		return
+	}
+	now := time.Now()
+	lastEOD := ""
+	if eod != 0 && sinceMidnight(now, s.Calendar) >= eod {
+		// Started after today's job would have run, the books are new anyway
+		lastEOD = now.In(s.Calendar.location()).Format("2006-01-02")
+	}
+	sent := make(map[uint64]bool)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for now = range ticker.C {
+		for _, v := range m.Instruments() {
+			session := s.hours(v.StockId)
+			if session.always() {
+				continue
+			}
+			open, found := sent[v.StockId]
+			if !found {
+				open = v.Status != InstrumentClosed
+			}
+			want := session.open(now, s.Calendar)
+			if want != open {
+				action := int32(pb.ADMIN_CLOSE_STOCK)
+				if want {
+					action = pb.ADMIN_OPEN_STOCK
+				}
+				m.Admin(&pb.AdminCommand{Action: action, StockId: v.StockId, Reason: "schedule"})
+			}
+			sent[v.StockId] = want
+		}
+
+		day := now.In(s.Calendar.location()).Format("2006-01-02")
+		if eod != 0 && day != lastEOD && s.Calendar.TradingDay(now) && sinceMidnight(now, s.Calendar) >= eod {
+			lastEOD = day
+			m.Admin(&pb.AdminCommand{Action: pb.ADMIN_END_OF_DAY, Reason: "schedule"})
+		}
+	}
+}
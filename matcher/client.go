@@ -0,0 +1,86 @@
+package matcher
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "main/proto"
+	"time"
+)
+
+// Client is anything execution reports of a trader can be delivered to,
+// the native Session as well as the gateways speaking other protocols.
+type Client interface {
+	TraderId() uint32
+	Send(msg proto.Message, tag string)
+	Stop()
+}
+
+// Rebindable is a Client that can take over the trader named at logon.
+type Rebindable interface {
+	Client
+	SetTraderId(traderId uint32)
+	HeartbeatInterval() time.Duration
+}
+
+// Acknowledger is a Client told of every new order of its trader the
+// matcher accepted, with the order id it gave it, before any fill of it is
+// reported. Accepted must not keep order.
+type Acknowledger interface {
+	Client
+	Accepted(order *pb.Order)
+}
+
+// acknowledge tells the client of the trader of order it was accepted, if
+// the client wants to know.
+func (m *TradeMatcher) acknowledge(order *pb.Order) {
+	m.r.RLock()
+	defer m.r.RUnlock()
+	if a, ok := m.sessions[order.GetUuid()].(Acknowledger); ok && !m.recovering {
+		a.Accepted(order)
+	}
+}
+
+// NextTraderId hands out a trader id no connected client is using.
+func (m *TradeMatcher) NextTraderId() uint32 {
+	m.r.Lock()
+	defer m.r.Unlock()
+
+	for {
+		traderId := m.traderId
+		m.traderId++
+		if _, found := m.sessions[traderId]; !found && traderId != 0 && m.dropCopies.stream(traderId) == nil {
+			return traderId
+		}
+	}
+}
+
+// Bind routes the reports of c's trader to c, a client previously bound
+// to the same trader is stopped.
+func (m *TradeMatcher) Bind(c Client) {
+	m.r.Lock()
+	old, found := m.sessions[c.TraderId()]
+	m.sessions[c.TraderId()] = c
+	m.sessionSeq++
+	m.sessionIds[c.TraderId()] = m.sessionSeq
+	m.r.Unlock()
+
+	if found && old != c {
+		old.Stop()
+	}
+}
+
+// Unbind forgets c so its trader no longer receives reports through it.
+func (m *TradeMatcher) Unbind(c Client) {
+	m.r.Lock()
+	defer m.r.Unlock()
+
+	if m.sessions[c.TraderId()] == c {
+		delete(m.sessions, c.TraderId())
+		delete(m.sessionIds, c.TraderId())
+	}
+}
+
+// Submit queues an order for the matching goroutine as if a native session had sent it.
+func (m *TradeMatcher) Submit(order *pb.Order, tag string) {
+	data, _ := proto.Marshal(order)
+	m.enqueue(order.GetUuid(), pb.NewPacket(tag, data))
+}
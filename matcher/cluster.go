@@ -0,0 +1,140 @@
+package matcher
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"main/matcher/journal"
+	"main/matcher/raft"
+	pb "main/proto"
+	"path/filepath"
+	"time"
+)
+
+var errClusterStandby = errors.New("a cluster replicates its input itself, it takes no standby")
+
+var errPeerSecret = errors.New("replicas and standbys need a secret to know each other by")
+
+// Cluster makes the matcher one replica of an input sequenced by raft.
+type Cluster struct {
+	// Id names this replica in Peers, zero runs the matcher on its own.
+	Id uint64
+	// Peers maps the id of every replica, this one included, to the
+	// address its raft node listens on.
+	Peers map[uint64]string
+	// Secret is shared by the replicas, they only talk to peers knowing it.
+	Secret string
+}
+
+// newClusterNode returns the raft node of cfg talking TCP to its peers,
+// nil when the matcher runs on its own. It keeps its state in raft.jnl of
+// journalDir, applied is how far the input journal got before a restart.
+func newClusterNode(cfg Cluster, journalDir string, applied uint64) (*raft.Node, error) {
+	if cfg.Id == 0 {
+		return nil, nil
+	}
+	if cfg.Secret == "" {
+		return nil, errPeerSecret
+	}
+	peers := make([]uint64, 0, len(cfg.Peers))
+	for id := range cfg.Peers {
+		peers = append(peers, id)
+	}
+	rc := raft.Config{Id: cfg.Id, Peers: peers, Applied: applied}
+	if journalDir != "" {
+		j, err := journal.Open(filepath.Join(journalDir, "raft.jnl"))
+		if err != nil {
+			return nil, err
+		}
+		rc.Storage = raft.NewJournalStorage(j)
+	}
+	return raft.NewNode(rc, raft.NewRPCTransport(cfg.Peers, cfg.Secret))
+}
+
+// JoinCluster makes the matcher the replica of the cluster node belongs
+// to, call it before Start. Every inbound message is committed by a
+// majority of the replicas before any of them matches it, and all match
+// the committed input in the same order, so they hold the same books and
+// number the same reports. Only the leader takes orders, the other
+// replicas turn them away. Traders moving to a new leader catch up by resend.
+func (m *TradeMatcher) JoinCluster(node *raft.Node) error {
+	if len(m.workers) > 1 {
+		return errReplicatedShards
+	}
+	if m.replication.Listen != "" {
+		return errClusterStandby
+	}
+	m.cluster = node
+	return nil
+}
+
+// propose hands packet and what waits behind it to the cluster as one
+// entry, turning them away when this replica does not lead. Those it took
+// and lost with its post are turned away once a new leader overwrites them.
+func (m *TradeMatcher) propose(packet *pb.Packet, more chan *pb.Packet) {
+	packets := batchOf(packet, more)
+	now := time.Now().UnixNano()
+	batch := &pb.InputBatch{}
+	for _, p := range packets {
+		batch.Records = append(batch.Records, &pb.InputRecord{
+			Timestamp: now,
+			Tag:       string(p.GetTag()),
+			Data:      p.Data,
+		})
+	}
+	data, err := proto.Marshal(batch)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if _, ok := m.cluster.Propose(data); ok {
+		return
+	}
+	m.turnAwayRecords(batch.GetRecords(), "not the leader")
+}
+
+// turnAwayRecords turns away records this replica cannot sequence,
+// naming the replica to go to when it is known.
+func (m *TradeMatcher) turnAwayRecords(records []*pb.InputRecord, reason string) {
+	if leader := m.cluster.Leader(); leader != 0 && leader != m.cluster.Id() {
+		reason = fmt.Sprintf("%s, replica %d leads", reason, leader)
+	}
+	for _, r := range records {
+		if r.GetTag() == pb.Admin {
+			log.Println("admin:", reason)
+			continue
+		}
+		order := &pb.Order{}
+		proto.Unmarshal(r.GetData(), order)
+		m.turnAway(order.GetUuid(), pb.NewPacket(r.GetTag(), r.GetData()), pb.REJECT_NOT_LEADER, reason)
+	}
+}
+
+// applyCommitted routes what the cluster committed, every replica in the
+// same order, and turns away what this replica proposed and lost.
+func (m *TradeMatcher) applyCommitted() {
+	for {
+		select {
+		case e := <-m.cluster.Committed():
+			batch := &pb.InputBatch{}
+			if err := proto.Unmarshal(e.Data, batch); err != nil {
+				log.Println(err)
+				continue
+			}
+			if err := m.input.committed(batch.GetRecords(), e.Index); err != nil {
+				log.Println(err)
+			}
+			for _, r := range batch.GetRecords() {
+				m.route(r)
+			}
+		case e := <-m.cluster.Lost():
+			batch := &pb.InputBatch{}
+			if err := proto.Unmarshal(e.Data, batch); err != nil {
+				log.Println(err)
+				continue
+			}
+			m.turnAwayRecords(batch.GetRecords(), "lost with the leadership")
+		}
+	}
+}
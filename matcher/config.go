@@ -0,0 +1,60 @@
+package matcher
+
+// Config holds the settings of a TradeMatcher.
+type Config struct {
+	// JournalDir is where the journals are kept, empty keeps everything in memory.
+	JournalDir string
+	// TokenFile lists the "trader_id token" pairs allowed to logon, empty
+	// keeps every session trading as the trader id it was handed.
+	TokenFile string
+	// AdminToken lets operators run admin commands, empty disables them.
+	AdminToken string
+	// AccountsFile holds the opening cash and holdings, once set traders can
+	// only buy with cash and sell shares they have.
+	AccountsFile string
+	// RateLimits throttles the orders and cancels of every trader.
+	RateLimits RateLimits
+	// Fees prices every fill, an empty schedule charges nothing.
+	Fees FeeSchedule
+	// Allocations splits the fills at a price level of the stocks listed,
+	// the others match in time priority.
+	Allocations map[uint64]Allocation
+	// Scales gives the stocks listed decimal prices and quantities, the
+	// others trade in whole units. Notionals, cash and fees are in units of
+	// the price, so stocks settling in the same cash should share a price scale.
+	Scales map[uint64]Scale
+	// RiskLimits applies to every trader without limits of its own.
+	RiskLimits RiskLimits
+	// Schedule is when each stock trades and the end of day job runs, the
+	// zero value trades around the clock and leaves end of day to admins.
+	Schedule Schedule
+	// DropCopies are the subscribers copied on the reports of every trader.
+	DropCopies []DropCopy
+	// TraderGroups names sets of trader ids a drop copy can follow.
+	TraderGroups map[string][]uint32
+	// Shards is how many workers match in parallel, each stock belongs to
+	// one of them by its id. Risk limits spanning stocks of different shards
+	// see the other shards' orders with a delay. Zero means one.
+	Shards int
+	// Slab sizes the order nodes each shard preallocates.
+	Slab SlabConfig
+	// Replication streams the input to a standby, it needs a single shard.
+	Replication Replication
+	// Cluster commits the input on a quorum of replicas before matching,
+	// it needs a single shard and no standby.
+	Cluster Cluster
+}
+
+func DefaultConfig() Config {
+	return Config{
+		JournalDir: "data",
+		RateLimits: RateLimits{
+			OrdersPerSecond:  100,
+			CancelsPerSecond: 100,
+		},
+		RiskLimits: RiskLimits{
+			MaxOrderQuantity: 1000000,
+			MaxOpenOrders:    1000,
+		},
+	}
+}
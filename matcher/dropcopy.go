@@ -0,0 +1,118 @@
+package matcher
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	"main/matcher/journal"
+	pb "main/proto"
+	"path/filepath"
+)
+
+// RoleDropCopy is the Logon role of a drop copy session.
+const RoleDropCopy = "dropcopy"
+
+// DropCopy is a compliance or back office subscriber, it receives a copy of
+// every execution report of the traders in Group, of everyone when empty,
+// and may not trade itself.
+type DropCopy struct {
+	Id    uint32
+	Token string
+	Group string
+}
+
+// dropCopyStream is the report stream of one subscriber. Reports are
+// numbered and kept whether it is connected or not, it catches up by resend.
+type dropCopyStream struct {
+	DropCopy
+	traders map[uint32]bool
+	store   *outboundStore
+}
+
+func (s *dropCopyStream) copies(traderId uint32) bool {
+	return s.Group == "" || s.traders[traderId]
+}
+
+// dropCopies holds every configured subscriber, the set is fixed at startup.
+type dropCopies struct {
+	streams map[uint32]*dropCopyStream
+}
+
+func newDropCopies(cfg Config) (*dropCopies, error) {
+	d := &dropCopies{streams: make(map[uint32]*dropCopyStream)}
+	for _, dc := range cfg.DropCopies {
+		if dc.Id == 0 || dc.Token == "" {
+			return nil, fmt.Errorf("drop copy %d needs a non zero id and a token", dc.Id)
+		}
+		s := &dropCopyStream{DropCopy: dc, traders: make(map[uint32]bool)}
+		if dc.Group != "" {
+			members, found := cfg.TraderGroups[dc.Group]
+			if !found {
+				return nil, fmt.Errorf("drop copy %d: unknown trader group %q", dc.Id, dc.Group)
+			}
+			for _, traderId := range members {
+				s.traders[traderId] = true
+			}
+		}
+
+		var j *journal.Journal
+		if cfg.JournalDir != "" {
+			var err error
+			if j, err = journal.Open(filepath.Join(cfg.JournalDir, fmt.Sprintf("dropcopy-%d.jnl", dc.Id))); err != nil {
+				return nil, err
+			}
+		}
+		store, err := openOutboundStore(j, func(*pb.Order) uint32 { return dc.Id })
+		if err != nil {
+			return nil, err
+		}
+		s.store = store
+		d.streams[dc.Id] = s
+	}
+	return d, nil
+}
+
+func (d *dropCopies) stream(id uint32) *dropCopyStream {
+	return d.streams[id]
+}
+
+// copy numbers a copy of a trader's report in the stream of every subscriber
+// following that trader and delivers it to those connected, the caller must hold m.r.
+func (m *TradeMatcher) copy(order *pb.Order, tag string) {
+	for id, s := range m.dropCopies.streams {
+		if !s.copies(order.GetUuid()) {
+			continue
+		}
+		c := proto.Clone(order).(*pb.Order)
+		if err := s.store.Append(id, c, tag); err != nil {
+			log.Println(err)
+		}
+		if subscriber, found := m.sessions[id]; found && !m.recovering {
+			subscriber.Send(c, tag)
+		}
+	}
+}
+
+// dropCopyLogon returns the stream a drop copy logon binds to, nil when
+// the logon names no drop copy or has the wrong token.
+func (m *TradeMatcher) dropCopyLogon(l *pb.Logon) *outboundStore {
+	s := m.dropCopies.stream(l.GetTraderId())
+	if s == nil || l.GetToken() != s.Token {
+		return nil
+	}
+	return s.store
+}
+
+// refuse turns away orders sent by a drop copy.
+func (m *TradeMatcher) refuse(id uint32, packet *pb.Packet) bool {
+	if m.dropCopies.stream(id) == nil {
+		return false
+	}
+	switch string(packet.GetTag()) {
+	case pb.Buy, pb.Sell, pb.Cancel, pb.Amend:
+	default:
+		return false
+	}
+	m.turnAway(id, packet, pb.REJECT_NOT_PERMITTED, "drop copy sessions cannot trade")
+	return true
+}
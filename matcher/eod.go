@@ -0,0 +1,109 @@
+package matcher
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	pb "main/proto"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DailySummary is what the end of day job writes for a trading day.
+type DailySummary struct {
+	Date        string           `json:"date"`
+	Instruments []InstrumentView `json:"instruments"`
+	Traders     []FeeSummary     `json:"traders"`
+}
+
+// closeStock ends the session of a stock, its day orders and the good till
+// date orders of today expire and the reference price rolls to the close.
+func (w *worker) closeStock(stockId uint64, now time.Time) {
+	w.expire(stockId, dateOf(now, w.m.schedule.Calendar))
+	w.m.instruments.setStatus(stockId, InstrumentClosed)
+}
+
+// endOfDay expires what is left to expire in every book, rolls the
+// reference prices and writes the summary and audit trail of the day to
+// the journal dir.
+func (m *TradeMatcher) endOfDay(now time.Time) {
+	date := dateOf(now, m.schedule.Calendar)
+	m.eachShard(func(w *worker) {
+		for _, stockId := range w.stockIds() {
+			w.expire(stockId, date)
+		}
+	})
+
+	summary := &DailySummary{
+		Date:        now.In(m.schedule.Calendar.location()).Format("2006-01-02"),
+		Instruments: m.instruments.rollDay(),
+		Traders:     m.fees.summaries(),
+	}
+	if m.summaryDir == "" {
+		log.Printf("end of day %s: %d instruments, %d traders", summary.Date, len(summary.Instruments), len(summary.Traders))
+		return
+	}
+	if err := writeSummary(filepath.Join(m.summaryDir, "summary-"+summary.Date+".json"), summary); err != nil {
+		log.Println(err)
+	}
+	if err := m.exportAudit(filepath.Join(m.summaryDir, "audit-"+summary.Date), summary.Date); err != nil {
+		log.Println(err)
+	}
+}
+
+// exportAudit writes the audit trail of date next to path as .csv and .jsonl.
+func (m *TradeMatcher) exportAudit(path string, date string) error {
+	for ext, write := range map[string]func(io.Writer, string) error{
+		".csv":   m.WriteAuditCSV,
+		".jsonl": m.WriteAuditJSONL,
+	} {
+		f, err := os.Create(path + ext)
+		if err != nil {
+			return err
+		}
+		err = write(f, date)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire takes the day orders and the good till date orders expiring on or
+// before date out of the book of stockId, reporting each as expired.
+func (w *worker) expire(stockId uint64, date uint32) {
+	q, found := w.matchQueues[stockId]
+	if !found {
+		return
+	}
+	expired := 0
+	for it := q.ByOrderId(); it.Next(); {
+		o := it.Order()
+		if o.TimeInForce() == pb.TIF_DAY || (o.TimeInForce() == pb.TIF_GTD && o.ExpireDate() <= date) {
+			o.Remove()
+			w.m.completeExpired(o)
+			w.slab.Free(o)
+			expired++
+		}
+	}
+	if expired == 0 {
+		return
+	}
+	w.m.instruments.expired(stockId, expired)
+	w.m.publishDepth(stockId, q)
+}
+
+func writeSummary(path string, summary *DailySummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
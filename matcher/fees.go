@@ -0,0 +1,200 @@
+package matcher
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FeeTier is the rate a trader pays once its traded notional this month
+// reached MinVolume. Rates are in basis points of the fill notional, a
+// negative rate pays a rebate. MinFee is the least a positive fee comes to.
+type FeeTier struct {
+	MinVolume uint64
+	MakerBps  int64
+	TakerBps  int64
+	MinFee    int64
+}
+
+// FeeSchedule prices every fill, Instruments overrides Tiers for a stock.
+// Tiers are sorted by MinVolume, the first one starting at zero.
+type FeeSchedule struct {
+	Tiers       []FeeTier
+	Instruments map[uint64][]FeeTier
+}
+
+func (s FeeSchedule) tiers(stockId uint64) []FeeTier {
+	if tiers, found := s.Instruments[stockId]; found {
+		return tiers
+	}
+	return s.Tiers
+}
+
+// FeeSummary is what a trader traded and paid on one day.
+type FeeSummary struct {
+	TraderId uint32 `json:"traderId"`
+	Date     string `json:"date"`
+	Fills    uint64 `json:"fills"`
+	Quantity uint64 `json:"quantity"`
+	Notional uint64 `json:"notional"`
+	Fees     int64  `json:"fees"`
+	Rebates  int64  `json:"rebates"`
+}
+
+// feeKeeper prices fills and keeps the volumes deciding the tier of a
+// trader, they start over every month. Only the matching workers charge.
+type feeKeeper struct {
+	mu       sync.RWMutex
+	schedule FeeSchedule
+	tiers    map[uint32]int
+	month    string
+	volume   map[uint32]uint64
+	day      string
+	summary  map[uint32]*FeeSummary
+	scales   scales
+}
+
+func newFeeKeeper(schedule FeeSchedule, s scales) *feeKeeper {
+	for _, tiers := range append([][]FeeTier{schedule.Tiers}, instrumentTiers(schedule)...) {
+		sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinVolume < tiers[j].MinVolume })
+	}
+	return &feeKeeper{
+		schedule: schedule,
+		tiers:    make(map[uint32]int),
+		volume:   make(map[uint32]uint64),
+		summary:  make(map[uint32]*FeeSummary),
+		scales:   s,
+	}
+}
+
+func instrumentTiers(schedule FeeSchedule) [][]FeeTier {
+	result := make([][]FeeTier, 0, len(schedule.Instruments))
+	for _, tiers := range schedule.Instruments {
+		result = append(result, tiers)
+	}
+	return result
+}
+
+// charge returns the fee of one side of a fill and books it.
+func (f *feeKeeper) charge(traderId uint32, stockId uint64, price uint64, quantity uint64, maker bool, now time.Time) int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if month := now.Format("2006-01"); month != f.month {
+		f.month = month
+		f.volume = make(map[uint32]uint64)
+	}
+	if day := now.Format("2006-01-02"); day != f.day {
+		f.day = day
+		f.summary = make(map[uint32]*FeeSummary)
+	}
+
+	value := f.scales.of(stockId).notional(quantity, price)
+	fee := f.fee(traderId, stockId, value, maker)
+	f.volume[traderId] = addNotional(f.volume[traderId], value)
+
+	s, found := f.summary[traderId]
+	if !found {
+		s = &FeeSummary{TraderId: traderId, Date: f.day}
+		f.summary[traderId] = s
+	}
+	s.Fills++
+	s.Quantity += quantity
+	s.Notional = addNotional(s.Notional, value)
+	if fee >= 0 {
+		s.Fees = addFees(s.Fees, fee)
+	} else {
+		s.Rebates = addFees(s.Rebates, -fee)
+	}
+	return fee
+}
+
+// fee prices value at the tier the trader reached or was given, whichever is higher.
+func (f *feeKeeper) fee(traderId uint32, stockId uint64, value uint64, maker bool) int64 {
+	tiers := f.schedule.tiers(stockId)
+	if len(tiers) == 0 {
+		return 0
+	}
+	tier := f.tiers[traderId]
+	for i := range tiers {
+		if f.volume[traderId] >= tiers[i].MinVolume && i > tier {
+			tier = i
+		}
+	}
+	if tier >= len(tiers) {
+		tier = len(tiers) - 1
+	}
+
+	rate := tiers[tier].TakerBps
+	if maker {
+		rate = tiers[tier].MakerBps
+	}
+	fee := feeOf(value, rate)
+	if rate > 0 && fee < tiers[tier].MinFee {
+		fee = tiers[tier].MinFee
+	}
+	return fee
+}
+
+// feeOf is rate basis points of value rounded toward zero, saturating at
+// the largest fee or rebate an int64 holds.
+func feeOf(value uint64, rate int64) int64 {
+	bps := uint64(rate)
+	if rate < 0 {
+		bps = -bps
+	}
+	fee := uint64(math.MaxInt64)
+	if hi, lo := bits.Mul64(value, bps); hi < 10000 {
+		if q, _ := bits.Div64(hi, lo, 10000); q < fee {
+			fee = q
+		}
+	}
+	if rate < 0 {
+		return -int64(fee)
+	}
+	return int64(fee)
+}
+
+// addFees sums fees or rebates, which are never negative, saturating.
+func addFees(a int64, b int64) int64 {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// SetFeeTier puts a trader on at least the tier at index tier of every
+// schedule, however little it traded this month.
+func (m *TradeMatcher) SetFeeTier(traderId uint32, tier int) {
+	m.fees.mu.Lock()
+	defer m.fees.mu.Unlock()
+	m.fees.tiers[traderId] = tier
+}
+
+// summaries returns what every trader traded and paid today.
+func (f *feeKeeper) summaries() []FeeSummary {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	result := make([]FeeSummary, 0, len(f.summary))
+	for _, s := range f.summary {
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TraderId < result[j].TraderId
+	})
+	return result
+}
+
+// FeeSummary returns what a trader traded and paid today.
+func (m *TradeMatcher) FeeSummary(traderId uint32) FeeSummary {
+	m.fees.mu.RLock()
+	defer m.fees.mu.RUnlock()
+
+	if s, found := m.fees.summary[traderId]; found {
+		return *s
+	}
+	return FeeSummary{TraderId: traderId, Date: m.fees.day}
+}
@@ -0,0 +1,76 @@
+package matcher
+
+import (
+	"github.com/golang/protobuf/proto"
+	pb "main/proto"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFeeOf(t *testing.T) {
+	for _, c := range []struct {
+		value uint64
+		rate  int64
+		want  int64
+	}{
+		{1000000, 5, 500},
+		{1000000, -2, -200},
+		{19999, 1, 1},
+		{19999, -1, -1},
+		{0, 30, 0},
+		{math.MaxUint64, 1, int64(math.MaxUint64 / 10000)},
+		{math.MaxUint64, 10000, math.MaxInt64},
+		{math.MaxUint64, -10000, -math.MaxInt64},
+		{math.MaxUint64, math.MaxInt64, math.MaxInt64},
+		{math.MaxUint64, math.MinInt64, -math.MaxInt64},
+	} {
+		if got := feeOf(c.value, c.rate); got != c.want {
+			t.Errorf("%d bps of %d is %d, want %d", c.rate, c.value, got, c.want)
+		}
+	}
+}
+
+// journalInput adds orders to the input of m as sequenced at at, for m to
+// replay once it starts.
+func journalInput(t *testing.T, m *TradeMatcher, at time.Time, orders ...*pb.Order) {
+	t.Helper()
+	m.input.mu.Lock()
+	defer m.input.mu.Unlock()
+	for _, order := range orders {
+		data, err := proto.Marshal(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tag := pb.Buy
+		if order.GetKind() == pb.SELL {
+			tag = pb.Sell
+		}
+		r := &pb.InputRecord{Seq: m.input.nextSeq(), Timestamp: at.UnixNano(), Tag: tag, Data: data}
+		if err := m.input.append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplayChargesBySequencedMonth(t *testing.T) {
+	cfg := Config{Fees: FeeSchedule{Tiers: []FeeTier{{TakerBps: 10}, {MinVolume: 10000, TakerBps: 5}}}}
+	m, err := NewMatcherWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Trader 1 reaches the second tier on the last day of January, it starts
+	// February in the first again
+	journalInput(t, m, time.Date(2026, 1, 31, 23, 59, 0, 0, time.Local),
+		&pb.Order{Uuid: 2, TradeId: 1, StockId: 1, Kind: pb.SELL, Price: 1000, Quantity: 10},
+		&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Kind: pb.BUY, Price: 1000, Quantity: 10})
+	journalInput(t, m, time.Date(2026, 2, 1, 0, 1, 0, 0, time.Local),
+		&pb.Order{Uuid: 2, TradeId: 2, StockId: 1, Kind: pb.SELL, Price: 1000, Quantity: 10},
+		&pb.Order{Uuid: 1, TradeId: 2, StockId: 1, Kind: pb.BUY, Price: 1000, Quantity: 10})
+	m.startWorkers()
+
+	summary := m.FeeSummary(1)
+	if summary.Date != "2026-02-01" || summary.Fills != 1 || summary.Fees != 10 {
+		t.Fatalf("trader 1 summary is %+v, want one fill on 2026-02-01 charged 10 at the first tier", summary)
+	}
+}
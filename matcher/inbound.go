@@ -0,0 +1,297 @@
+package matcher
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"log"
+	pb "main/proto"
+	"sync"
+	"time"
+)
+
+// inboxSize is how many packets of one trader may wait for the matcher,
+// a trader sending faster only slows down its own connection.
+const inboxSize = 1024
+
+// throttleSweep is how often the throttles of traders gone quiet are dropped.
+const throttleSweep = time.Minute
+
+// RateLimits throttles what each trader may send, a zero rate is unlimited.
+type RateLimits struct {
+	OrdersPerSecond  float64
+	CancelsPerSecond float64
+	// Burst is how many messages may arrive at once, zero allows one second worth
+	Burst int
+	// Disconnect stops the client of a trader over its limit instead of rejecting the message
+	Disconnect bool
+}
+
+// tokenBucket refills rate tokens a second up to burst, every message takes one.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if b <= 0 {
+		b = rate
+	}
+	if b < 1 {
+		b = 1
+	}
+	return &tokenBucket{rate: rate, burst: b, tokens: b, last: time.Now()}
+}
+
+// take reports whether a message may pass now, a nil bucket lets everything through.
+func (b *tokenBucket) take(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// full reports whether the bucket will have refilled by now.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b == nil || b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// throttle is the rate limit state of a trader. It outlives the trader's
+// sessions, so reconnecting does not refill it, and goes once its buckets
+// refilled.
+type throttle struct {
+	orders  *tokenBucket
+	cancels *tokenBucket
+}
+
+// inbox queues the packets of one trader, users counts producers about to
+// send so the dispatcher never drops an inbox a packet is headed for.
+type inbox struct {
+	packets chan *pb.Packet
+	users   int
+}
+
+// inbound sits between every client and the matching goroutine. Each
+// trader has its own inbox and the dispatcher takes one packet from each
+// in turn, so one busy trader cannot crowd the others out of recv.
+type inbound struct {
+	mu        sync.Mutex
+	limits    RateLimits
+	inboxes   map[uint32]*inbox
+	order     []uint32
+	throttles map[uint32]*throttle
+	swept     time.Time
+	notify    chan struct{}
+}
+
+func newInbound(limits RateLimits) *inbound {
+	return &inbound{
+		limits:    limits,
+		inboxes:   make(map[uint32]*inbox),
+		throttles: make(map[uint32]*throttle),
+		notify:    make(chan struct{}, 1),
+	}
+}
+
+// enqueue hands a packet of traderId to the matching goroutine once the
+// rate limits let it through, it blocks while the trader's inbox is full.
+// Only the tags a trader may send get through.
+func (m *TradeMatcher) enqueue(traderId uint32, packet *pb.Packet) {
+	if !clientTag(string(packet.GetTag())) {
+		// Only the engine itself queues rejects and admin commands
+		return
+	}
+	if m.refuse(traderId, packet) {
+		return
+	}
+	if reject := m.inbound.limit(traderId, packet); reject != nil {
+		m.overLimit(traderId, packet, reject)
+		return
+	}
+	m.queue(traderId, packet)
+}
+
+func clientTag(tag string) bool {
+	switch tag {
+	case pb.Buy, pb.Sell, pb.Cancel, pb.Amend, pb.List, pb.Positions:
+		return true
+	}
+	return false
+}
+
+// queue puts a packet in the inbox of traderId.
+func (m *TradeMatcher) queue(traderId uint32, packet *pb.Packet) {
+	in := m.inbound
+	in.mu.Lock()
+	box, found := in.inboxes[traderId]
+	if !found {
+		box = &inbox{packets: make(chan *pb.Packet, inboxSize)}
+		in.inboxes[traderId] = box
+		in.order = append(in.order, traderId)
+	}
+	box.users++
+	in.mu.Unlock()
+
+	box.packets <- packet
+
+	in.mu.Lock()
+	box.users--
+	in.mu.Unlock()
+	select {
+	case in.notify <- struct{}{}:
+	default:
+	}
+}
+
+// limit takes a token from the bucket the packet counts against.
+func (in *inbound) limit(traderId uint32, packet *pb.Packet) *Reject {
+	var bucket func(t *throttle) *tokenBucket
+	var what string
+	switch string(packet.GetTag()) {
+	case pb.Buy, pb.Sell, pb.Amend:
+		bucket, what = func(t *throttle) *tokenBucket { return t.orders }, "orders"
+	case pb.Cancel:
+		bucket, what = func(t *throttle) *tokenBucket { return t.cancels }, "cancels"
+	default:
+		return nil
+	}
+
+	now := time.Now()
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	in.sweep(now)
+	t, found := in.throttles[traderId]
+	if !found {
+		t = &throttle{
+			orders:  newTokenBucket(in.limits.OrdersPerSecond, in.limits.Burst),
+			cancels: newTokenBucket(in.limits.CancelsPerSecond, in.limits.Burst),
+		}
+		in.throttles[traderId] = t
+	}
+	if b := bucket(t); !b.take(now) {
+		return &Reject{pb.REJECT_RATE_LIMIT, fmt.Sprintf("more than %g %s a second", b.rate, what)}
+	}
+	return nil
+}
+
+// sweep drops the throttles that have refilled since their traders last
+// sent, every throttleSweep at most. They hold nothing a new one would not.
+// The caller must hold in.mu.
+func (in *inbound) sweep(now time.Time) {
+	if now.Sub(in.swept) < throttleSweep {
+		return
+	}
+	in.swept = now
+	for traderId, t := range in.throttles {
+		if t.orders.full(now) && t.cancels.full(now) {
+			delete(in.throttles, traderId)
+		}
+	}
+}
+
+// overLimit rejects the message or, when so configured, throws the client
+// out. The reject goes through the input like any order, so a standby
+// numbers it the same as the primary.
+func (m *TradeMatcher) overLimit(traderId uint32, packet *pb.Packet, reject *Reject) {
+	if m.inbound.limits.Disconnect {
+		m.r.RLock()
+		c, found := m.sessions[traderId]
+		m.r.RUnlock()
+		if found {
+			c.Send(&pb.LogoutReason{Text: reject.Text}, pb.Logout)
+			c.Stop()
+		}
+		return
+	}
+
+	order := &pb.Order{}
+	if err := proto.Unmarshal(packet.Data, order); err != nil {
+		log.Println(err)
+		return
+	}
+	order.Uuid = traderId
+	order.RejectCode = reject.Code
+	order.Reason = reject.Text
+	data, _ := proto.Marshal(order)
+	m.queue(traderId, pb.NewPacket(pb.Rejected, data))
+}
+
+// turnAway rejects a packet that never reaches the books, the reject is
+// not numbered since it is in no stream a replica could repeat.
+func (m *TradeMatcher) turnAway(traderId uint32, packet *pb.Packet, code int32, reason string) {
+	order := &pb.Order{}
+	proto.Unmarshal(packet.Data, order)
+	m.r.RLock()
+	defer m.r.RUnlock()
+	if c, found := m.sessions[traderId]; found {
+		c.Send(&pb.Order{
+			Uuid:          traderId,
+			TradeId:       order.GetTradeId(),
+			ClientOrderId: order.GetClientOrderId(),
+			StockId:       order.GetStockId(),
+			Kind:          pb.REJECTED,
+			RejectCode:    code,
+			Reason:        reason,
+		}, pb.Rejected)
+	}
+}
+
+// dispatch feeds recv round robin, one packet per trader with any waiting.
+func (m *TradeMatcher) dispatch() {
+	in := m.inbound
+	for {
+		in.mu.Lock()
+		traders := make([]uint32, len(in.order))
+		copy(traders, in.order)
+		in.mu.Unlock()
+
+		moved := false
+		for _, traderId := range traders {
+			in.mu.Lock()
+			box := in.inboxes[traderId]
+			in.mu.Unlock()
+
+			select {
+			case packet := <-box.packets:
+				m.recv <- packet
+				moved = true
+			default:
+				in.drop(traderId, box)
+			}
+		}
+		if !moved {
+			<-in.notify
+		}
+	}
+}
+
+// drop forgets an inbox that is empty with nobody about to fill it.
+func (in *inbound) drop(traderId uint32, box *inbox) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if box.users != 0 || len(box.packets) != 0 {
+		return
+	}
+	delete(in.inboxes, traderId)
+	for i, t := range in.order {
+		if t == traderId {
+			in.order = append(in.order[:i], in.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package matcher
+
+import (
+	pb "main/proto"
+	"testing"
+	"time"
+)
+
+func TestReconnectKeepsRateLimit(t *testing.T) {
+	m := startTestMatcher(t, Config{RateLimits: RateLimits{OrdersPerSecond: 0.01, Burst: 2}})
+	first := bindTestClient(m, 1)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Kind: pb.BUY, Price: 90, Quantity: 1}, pb.Buy)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 2, StockId: 1, Kind: pb.BUY, Price: 90, Quantity: 1}, pb.Buy)
+	m.Unbind(first)
+
+	again := bindTestClient(m, 1)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 3, StockId: 1, Kind: pb.BUY, Price: 90, Quantity: 1}, pb.Buy)
+	if reject := again.wait(t, 1)[0]; reject.GetRejectCode() != pb.REJECT_RATE_LIMIT || reject.GetTradeId() != 3 {
+		t.Fatalf("third order got %v, want it over the rate limit after the reconnect", reject)
+	}
+}
+
+func TestThrottlesSweptOnceRefilled(t *testing.T) {
+	in := newInbound(RateLimits{OrdersPerSecond: 0.01, Burst: 2})
+	order := pb.NewPacket(pb.Buy, nil)
+	in.limit(1, order)
+	in.limit(2, order)
+	in.limit(2, order)
+
+	// Trader 1 took one token and refills in 100 seconds, trader 2 took both
+	now := in.throttles[2].orders.last
+	in.sweep(now.Add(2 * time.Minute))
+	if _, found := in.throttles[1]; found {
+		t.Fatal("trader 1 refilled and was kept")
+	}
+	if _, found := in.throttles[2]; !found {
+		t.Fatal("trader 2 was swept before it refilled")
+	}
+	in.sweep(now.Add(4 * time.Minute))
+	if len(in.throttles) != 0 {
+		t.Fatal("trader 2 kept once refilled")
+	}
+}
@@ -0,0 +1,256 @@
+package matcher
+
+import (
+	"fmt"
+	pb "main/proto"
+	"math"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Instrument states.
+const (
+	InstrumentTrading = "trading"
+	InstrumentHalted  = "halted"
+	InstrumentClosed  = "closed"
+)
+
+// InstrumentView is the trading state of one stock, the prices and counts
+// are for the current trading day. RefPrice is the previous close. Prices
+// and quantities are in units of their last decimal place, PriceScale and
+// QuantityScale say how many places they have.
+type InstrumentView struct {
+	StockId       uint64 `json:"stockId"`
+	Status        string `json:"status"`
+	PriceScale    uint32 `json:"priceScale"`
+	QuantityScale uint32 `json:"quantityScale"`
+	RefPrice      uint64 `json:"refPrice"`
+	LastPrice     uint64 `json:"lastPrice"`
+	Open          uint64 `json:"open"`
+	High          uint64 `json:"high"`
+	Low           uint64 `json:"low"`
+	Volume        uint64 `json:"volume"`
+	Trades        uint64 `json:"trades"`
+	Expired       uint64 `json:"expired"`
+	BestBid       uint64 `json:"bestBid"`
+	BestAsk       uint64 `json:"bestAsk"`
+}
+
+// Scale is how many decimal places the prices and quantities of a stock
+// have, a price of 12345 at a price scale of 2 is 123.45.
+type Scale struct {
+	Price    uint32
+	Quantity uint32
+}
+
+// Notional is quantity times price in units of the price, the decimal
+// places of the quantity divided out and any fraction of a unit dropped.
+// It reports false when the notional does not fit in 64 bits.
+func (s Scale) Notional(quantity uint64, price uint64) (uint64, bool) {
+	hi, lo := bits.Mul64(quantity, price)
+	f := pb.Pow10(s.Quantity)
+	if hi >= f {
+		return 0, false
+	}
+	n, _ := bits.Div64(hi, lo, f)
+	return n, true
+}
+
+// notional saturates instead of failing, orders whose notional overflows
+// never reach a book so only sums of them get there.
+func (s Scale) notional(quantity uint64, price uint64) uint64 {
+	n, ok := s.Notional(quantity, price)
+	if !ok {
+		return math.MaxUint64
+	}
+	return n
+}
+
+// decimals reads the price and quantity an order sent as decimals into the
+// units of its stock.
+func (s Scale) decimals(order *pb.Order) *Reject {
+	if d := order.GetDecimalPrice(); d != nil {
+		price, err := d.Rescale(s.Price)
+		if err != nil {
+			return &Reject{pb.REJECT_INVALID_DECIMAL, fmt.Sprintf("price: %v", err)}
+		}
+		order.Price = price
+	}
+	if d := order.GetDecimalQuantity(); d != nil {
+		quantity, err := d.Rescale(s.Quantity)
+		if err != nil {
+			return &Reject{pb.REJECT_INVALID_DECIMAL, fmt.Sprintf("quantity: %v", err)}
+		}
+		order.Quantity = quantity
+	}
+	return nil
+}
+
+// admit reads the decimals of a buy, sell or amend into the units of its
+// stock and rejects it when its notional does not fit in 64 bits.
+func (s scales) admit(order *pb.Order) *Reject {
+	scale := s.of(order.GetStockId())
+	if reject := scale.decimals(order); reject != nil {
+		return reject
+	}
+	if _, ok := scale.Notional(order.GetQuantity(), order.GetPrice()); !ok {
+		return &Reject{pb.REJECT_NOTIONAL_OVERFLOW,
+			fmt.Sprintf("notional of %d at %d does not fit in 64 bits", order.GetQuantity(), order.GetPrice())}
+	}
+	return nil
+}
+
+// decorate adds the price and quantity of a report as decimals, unless it
+// echoes the ones the trader sent.
+func (s Scale) decorate(order *pb.Order) {
+	if order.DecimalPrice == nil {
+		order.DecimalPrice = pb.NewDecimal(order.GetPrice(), s.Price)
+	}
+	if order.DecimalQuantity == nil {
+		order.DecimalQuantity = pb.NewDecimal(order.GetQuantity(), s.Quantity)
+	}
+}
+
+// scales holds the stocks with decimals, the others trade in whole units.
+// It never changes once the matcher is made.
+type scales map[uint64]Scale
+
+func (s scales) of(stockId uint64) Scale {
+	return s[stockId]
+}
+
+func (s scales) check() error {
+	for stockId, scale := range s {
+		if scale.Price > pb.MaxScale || scale.Quantity > pb.MaxScale {
+			return fmt.Errorf("stock %d: at most %d decimal places", stockId, pb.MaxScale)
+		}
+	}
+	return nil
+}
+
+// instruments registers a stock the first time an order names it, the
+// stocks with decimals from the start so clients can look their scales up.
+type instruments struct {
+	mu     sync.RWMutex
+	views  map[uint64]*InstrumentView
+	scales scales
+}
+
+func newInstruments(s scales) *instruments {
+	i := &instruments{views: make(map[uint64]*InstrumentView), scales: s}
+	for stockId := range s {
+		i.get(stockId)
+	}
+	return i
+}
+
+// get returns the instrument, the caller must hold mu.
+func (i *instruments) get(stockId uint64) *InstrumentView {
+	v, found := i.views[stockId]
+	if !found {
+		scale := i.scales.of(stockId)
+		v = &InstrumentView{StockId: stockId, Status: InstrumentTrading, PriceScale: scale.Price, QuantityScale: scale.Quantity}
+		i.views[stockId] = v
+	}
+	return v
+}
+
+func (i *instruments) traded(stockId uint64, price uint64, quantity uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	v := i.get(stockId)
+	if v.Trades == 0 {
+		v.Open, v.High, v.Low = price, price, price
+	}
+	if price > v.High {
+		v.High = price
+	}
+	if price < v.Low {
+		v.Low = price
+	}
+	v.LastPrice = price
+	v.Volume += quantity
+	v.Trades++
+}
+
+func (i *instruments) quoted(stockId uint64, bid uint64, ask uint64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	v := i.get(stockId)
+	v.BestBid = bid
+	v.BestAsk = ask
+}
+
+func (i *instruments) expired(stockId uint64, n int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.get(stockId).Expired += uint64(n)
+}
+
+// setStatus opens or closes a stock, closing rolls the reference price to
+// the close when the stock traded today.
+func (i *instruments) setStatus(stockId uint64, status string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	v := i.get(stockId)
+	v.Status = status
+	if status == InstrumentClosed && v.Trades != 0 {
+		v.RefPrice = v.LastPrice
+	}
+}
+
+// rollDay rolls every reference price to the close and starts the daily
+// figures over, it returns the views of the day that ended.
+func (i *instruments) rollDay() []InstrumentView {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	day := make([]InstrumentView, 0, len(i.views))
+	for _, v := range i.views {
+		if v.Trades != 0 {
+			v.RefPrice = v.LastPrice
+		}
+		day = append(day, *v)
+		v.Open, v.High, v.Low = 0, 0, 0
+		v.Volume, v.Trades, v.Expired = 0, 0, 0
+	}
+	sort.Slice(day, func(a, b int) bool {
+		return day[a].StockId < day[b].StockId
+	})
+	return day
+}
+
+// Scale returns the decimal places of the prices and quantities of stockId.
+func (m *TradeMatcher) Scale(stockId uint64) Scale {
+	return m.instruments.scales.of(stockId)
+}
+
+func (m *TradeMatcher) Instrument(stockId uint64) (InstrumentView, bool) {
+	m.instruments.mu.RLock()
+	defer m.instruments.mu.RUnlock()
+
+	v, found := m.instruments.views[stockId]
+	if !found {
+		return InstrumentView{}, false
+	}
+	return *v, true
+}
+
+// Instruments returns every stock traded so far ordered by StockId.
+func (m *TradeMatcher) Instruments() []InstrumentView {
+	m.instruments.mu.RLock()
+	defer m.instruments.mu.RUnlock()
+
+	result := make([]InstrumentView, 0, len(m.instruments.views))
+	for _, v := range m.instruments.views {
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StockId < result[j].StockId
+	})
+	return result
+}
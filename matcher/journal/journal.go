@@ -0,0 +1,128 @@
+package journal
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	pb "main/proto"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Journal is an append-only file of packets, framed exactly like the wire protocol.
+type Journal struct {
+	file *os.File
+	mu   sync.Mutex
+}
+
+// Open creates the journal file if needed and positions writes at its end.
+// A torn record at the tail, left by a crash during Append, is cut off so
+// new records follow the last complete one.
+func Open(path string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := truncateTorn(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Journal{file: f}, nil
+}
+
+func truncateTorn(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	end, err := scan(io.NewSectionReader(f, 0, info.Size()), func(*pb.Packet) error { return nil })
+	if err != nil {
+		return err
+	}
+	if end == info.Size() {
+		return nil
+	}
+	if err := f.Truncate(end); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Append writes one record tagged like a packet, the record is on disk when it returns.
+func (j *Journal) Append(tag string, data []byte) error {
+	return j.AppendAll(tag, [][]byte{data})
+}
+
+// AppendAll writes a record tagged tag for each of records with one sync,
+// they are all on disk when it returns.
+func (j *Journal) AppendAll(tag string, records [][]byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w := bufio.NewWriter(j.file)
+	for _, data := range records {
+		if err := pb.NewPacket(tag, data).Pack(w); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+// Replay feeds every complete record to fn in write order. A torn record
+// at the tail, left by a crash during Append, ends the replay silently.
+func (j *Journal) Replay(fn func(*pb.Packet) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.Open(j.file.Name())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = scan(f, fn)
+	return err
+}
+
+// scan feeds the complete records of r to fn and returns the offset the
+// last of them ends at.
+func scan(r io.Reader, fn func(*pb.Packet) error) (int64, error) {
+	c := &counter{r: bufio.NewReader(r)}
+	var end int64
+	for {
+		p := new(pb.Packet)
+		err := p.Unpack(c)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return end, nil
+		}
+		if err != nil {
+			return end, err
+		}
+		end = c.n
+		if err := fn(p); err != nil {
+			return end, err
+		}
+	}
+}
+
+// counter counts the bytes read through it.
+type counter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (j *Journal) Close() error {
+	return j.file.Close()
+}
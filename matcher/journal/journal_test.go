@@ -0,0 +1,58 @@
+package journal
+
+import (
+	pb "main/proto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func records(t *testing.T, j *Journal) []string {
+	t.Helper()
+	var data []string
+	err := j.Replay(func(p *pb.Packet) error {
+		data = append(data, string(p.Data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestOpenCutsTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.jnl")
+	j, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append("t_test", []byte("one")); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Append("t_test", []byte("two")); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// A crash halfway through the second record
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-2); err != nil {
+		t.Fatal(err)
+	}
+
+	j, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if err := j.Append("t_test", []byte("three")); err != nil {
+		t.Fatal(err)
+	}
+	got := records(t, j)
+	if len(got) != 2 || got[0] != "one" || got[1] != "three" {
+		t.Fatalf("replayed %q, want [one three]", got)
+	}
+}
@@ -0,0 +1,362 @@
+package matcher
+
+import (
+	"bufio"
+	"fmt"
+	"main/matcher/pqueue"
+	pb "main/proto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ledger buckets. Money and shares move from external into available on a
+// deposit, from available into reserved when an order is accepted and from
+// reserved to the counterparty when it fills.
+const (
+	BucketExternal  = "external"
+	BucketAvailable = "available"
+	BucketReserved  = "reserved"
+)
+
+// AssetCash names the cash asset in legs, a stock is "stock:<stock id>".
+// Balances key cash as stock id 0, no stock is ever numbered 0.
+const AssetCash = "cash"
+
+// Ledger entry kinds.
+const (
+	EntryDeposit = "deposit"
+	EntryReserve = "reserve"
+	EntryRelease = "release"
+	EntrySettle  = "settle"
+)
+
+func assetName(stockId uint64) string {
+	if stockId == 0 {
+		return AssetCash
+	}
+	return "stock:" + strconv.FormatUint(stockId, 10)
+}
+
+// Leg moves Amount of Asset into one bucket of a trader, trader 0 is the
+// outside world. The legs of an Entry sum to zero for every asset.
+type Leg struct {
+	TraderId uint32 `json:"traderId"`
+	Bucket   string `json:"bucket"`
+	Asset    string `json:"asset"`
+	Amount   int64  `json:"amount"`
+	stockId  uint64
+}
+
+// Entry is one balanced posting of the ledger.
+type Entry struct {
+	Seq      uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Kind     string    `json:"kind"`
+	OrderId  uint64    `json:"orderId,omitempty"`
+	StockId  uint64    `json:"stockId,omitempty"`
+	Legs     []Leg     `json:"legs"`
+	traderId []uint32
+}
+
+type ledgerKey struct {
+	traderId uint32
+	bucket   string
+	stockId  uint64
+}
+
+// ledger keeps the cash and holdings of every trader double entry style,
+// it only holds traders to their balances once enabled by an accounts file.
+type ledger struct {
+	mu       sync.RWMutex
+	enabled  bool
+	balances map[ledgerKey]int64
+	history  []*Entry
+	scales   scales
+}
+
+func newLedger(s scales) *ledger {
+	return &ledger{balances: make(map[ledgerKey]int64), scales: s}
+}
+
+// LoadAccountsFile reads the opening balances into the ledger and turns it
+// on. Lines are "trader_id cash" or "trader_id stock_id quantity", blank
+// lines and # comments are skipped.
+func (m *TradeMatcher) LoadAccountsFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 && len(fields) != 3 {
+			return fmt.Errorf("%s:%d: want \"trader_id cash\" or \"trader_id stock_id quantity\"", path, line)
+		}
+		values := make([]uint64, len(fields))
+		for i, field := range fields {
+			if values[i], err = strconv.ParseUint(field, 10, 64); err != nil {
+				return fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+		}
+		if len(values) == 2 {
+			m.Deposit(uint32(values[0]), values[1])
+		} else {
+			m.DepositStock(uint32(values[0]), values[1], values[2])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	m.ledger.mu.Lock()
+	m.ledger.enabled = true
+	m.ledger.mu.Unlock()
+	return nil
+}
+
+// Deposit credits cash to a trader, it is safe at any time.
+func (m *TradeMatcher) Deposit(traderId uint32, cash uint64) {
+	m.ledger.post(EntryDeposit, 0, 0, m.ledger.transfer(nil, cash,
+		ledgerKey{0, BucketExternal, 0}, ledgerKey{traderId, BucketAvailable, 0}))
+}
+
+// DepositStock credits shares of a stock to a trader, it is safe at any time.
+func (m *TradeMatcher) DepositStock(traderId uint32, stockId uint64, quantity uint64) {
+	m.ledger.post(EntryDeposit, 0, stockId, m.ledger.transfer(nil, quantity,
+		ledgerKey{0, BucketExternal, stockId}, ledgerKey{traderId, BucketAvailable, stockId}))
+}
+
+// transfer appends the two legs moving amount from one bucket to another of the same asset.
+func (l *ledger) transfer(legs []Leg, amount uint64, from ledgerKey, to ledgerKey) []Leg {
+	if amount == 0 {
+		return legs
+	}
+	return append(legs,
+		Leg{TraderId: from.traderId, Bucket: from.bucket, Asset: assetName(from.stockId), Amount: -int64(amount), stockId: from.stockId},
+		Leg{TraderId: to.traderId, Bucket: to.bucket, Asset: assetName(to.stockId), Amount: int64(amount), stockId: to.stockId})
+}
+
+func (l *ledger) post(kind string, orderId uint64, stockId uint64, legs []Leg) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.apply(kind, orderId, stockId, legs)
+}
+
+// apply books an entry, the caller must hold mu.
+func (l *ledger) apply(kind string, orderId uint64, stockId uint64, legs []Leg) {
+	if len(legs) == 0 {
+		return
+	}
+	e := &Entry{
+		Seq:     uint64(len(l.history)) + 1,
+		Time:    time.Now(),
+		Kind:    kind,
+		OrderId: orderId,
+		StockId: stockId,
+		Legs:    legs,
+	}
+	for _, leg := range legs {
+		l.balances[ledgerKey{leg.TraderId, leg.Bucket, leg.stockId}] += leg.Amount
+		if leg.TraderId != 0 && !e.touches(leg.TraderId) {
+			e.traderId = append(e.traderId, leg.TraderId)
+		}
+	}
+	l.history = append(l.history, e)
+}
+
+func (e *Entry) touches(traderId uint32) bool {
+	for _, t := range e.traderId {
+		if t == traderId {
+			return true
+		}
+	}
+	return false
+}
+
+// requirement is the asset, 0 for cash, and amount an order of side has to set aside.
+func (l *ledger) requirement(side int32, stockId uint64, quantity uint64, price uint64) (uint64, uint64) {
+	if side == pb.BUY {
+		return 0, l.scales.of(stockId).notional(quantity, price)
+	}
+	return stockId, quantity
+}
+
+// reserve sets aside what a new buy or sell needs. An amend is checked by
+// rehold as it swaps the reservation of the order it replaces.
+func (m *TradeMatcher) reserve(order *pb.Order) *Reject {
+	l := m.ledger
+	if !l.on() || order.GetKind() == pb.AMEND {
+		return nil
+	}
+
+	traderId := order.GetUuid()
+	asset, needed := l.requirement(order.GetKind(), order.GetStockId(), order.GetQuantity(), order.GetPrice())
+	key := ledgerKey{traderId, BucketAvailable, asset}
+
+	// Other shards spend the same cash, check and hold under one lock
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if available := l.available(key); needed > available {
+		return insufficient(asset, available, needed)
+	}
+	l.apply(EntryReserve, order.GetOrderId(), order.GetStockId(), l.transfer(nil, needed,
+		key, ledgerKey{traderId, BucketReserved, asset}))
+	return nil
+}
+
+// rehold swaps the reservation of an amended order for what its new price
+// and quantity need, checking and swapping under one lock so no other shard
+// can spend the difference in between. It leaves the reservation alone and
+// rejects the amend when the old one given back is not enough.
+func (l *ledger) rehold(old *pqueue.OrderNode, quantity uint64, price uint64) *Reject {
+	if !l.on() {
+		return nil
+	}
+	traderId := old.Uuid()
+	asset, amount := l.requirement(old.Kind(), old.StockId(), old.Quantity(), old.Price())
+	_, needed := l.requirement(old.Kind(), old.StockId(), quantity, price)
+	key := ledgerKey{traderId, BucketAvailable, asset}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if available := addNotional(l.available(key), amount); needed > available {
+		return insufficient(asset, available, needed)
+	}
+	l.apply(EntryRelease, old.OrderId(), old.StockId(), l.transfer(nil, amount,
+		ledgerKey{traderId, BucketReserved, asset}, key))
+	l.apply(EntryReserve, old.OrderId(), old.StockId(), l.transfer(nil, needed,
+		key, ledgerKey{traderId, BucketReserved, asset}))
+	return nil
+}
+
+// available is the balance of key there is to spend, a negative balance
+// has nothing to spend. The caller must hold mu.
+func (l *ledger) available(key ledgerKey) uint64 {
+	if b := l.balances[key]; b > 0 {
+		return uint64(b)
+	}
+	return 0
+}
+
+func insufficient(asset uint64, available uint64, needed uint64) *Reject {
+	code := int32(pb.REJECT_INSUFFICIENT_CASH)
+	if asset != 0 {
+		code = pb.REJECT_INSUFFICIENT_HOLDINGS
+	}
+	return &Reject{code, fmt.Sprintf("%d %s available, the order needs %d", available, assetName(asset), needed)}
+}
+
+// release gives the reservation of what is left of o back to available.
+func (l *ledger) release(o *pqueue.OrderNode) {
+	if !l.on() {
+		return
+	}
+	asset, amount := l.requirement(o.Kind(), o.StockId(), o.Quantity(), o.Price())
+	l.post(EntryRelease, o.OrderId(), o.StockId(), l.transfer(nil, amount,
+		ledgerKey{o.Uuid(), BucketReserved, asset}, ledgerKey{o.Uuid(), BucketAvailable, asset}))
+}
+
+// settle pays the seller from the buyer's reservation and delivers the
+// shares the other way. The buyer reserved at its own limit, what it saves
+// by trading at a better price goes back to available. remaining is what
+// the buy had left before the fill, its reservation shrinks to what the
+// rest needs so the fractions of a unit notionals drop never pile up in it.
+func (l *ledger) settle(b *pqueue.OrderNode, s *pqueue.OrderNode, price uint64, quantity uint64, remaining uint64) {
+	if !l.on() {
+		return
+	}
+	stock := b.StockId()
+	buyer, seller := b.Uuid(), s.Uuid()
+
+	scale := l.scales.of(stock)
+	paid := scale.notional(quantity, price)
+	held := scale.notional(remaining, b.Price()) - scale.notional(remaining-quantity, b.Price())
+	legs := l.transfer(nil, paid,
+		ledgerKey{buyer, BucketReserved, 0}, ledgerKey{seller, BucketAvailable, 0})
+	legs = l.transfer(legs, held-paid,
+		ledgerKey{buyer, BucketReserved, 0}, ledgerKey{buyer, BucketAvailable, 0})
+	legs = l.transfer(legs, quantity,
+		ledgerKey{seller, BucketReserved, stock}, ledgerKey{buyer, BucketAvailable, stock})
+	l.post(EntrySettle, b.OrderId(), b.StockId(), legs)
+}
+
+func (l *ledger) on() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.enabled
+}
+
+// Account is the cash and holdings of a trader.
+type Account struct {
+	TraderId     uint32    `json:"traderId"`
+	Cash         uint64    `json:"cash"`
+	CashReserved uint64    `json:"cashReserved"`
+	Holdings     []Holding `json:"holdings"`
+}
+
+type Holding struct {
+	StockId   uint64 `json:"stockId"`
+	Available uint64 `json:"available"`
+	Reserved  uint64 `json:"reserved"`
+}
+
+func (m *TradeMatcher) Account(traderId uint32) Account {
+	l := m.ledger
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	a := Account{
+		TraderId:     traderId,
+		Cash:         uint64(l.balances[ledgerKey{traderId, BucketAvailable, 0}]),
+		CashReserved: uint64(l.balances[ledgerKey{traderId, BucketReserved, 0}]),
+		Holdings:     []Holding{},
+	}
+	stocks := make(map[uint64]*Holding)
+	for k, v := range l.balances {
+		if k.traderId != traderId || k.stockId == 0 {
+			continue
+		}
+		h, found := stocks[k.stockId]
+		if !found {
+			h = &Holding{StockId: k.stockId}
+			stocks[k.stockId] = h
+		}
+		if k.bucket == BucketAvailable {
+			h.Available = uint64(v)
+		} else {
+			h.Reserved = uint64(v)
+		}
+	}
+	for _, h := range stocks {
+		a.Holdings = append(a.Holdings, *h)
+	}
+	sort.Slice(a.Holdings, func(i, j int) bool {
+		return a.Holdings[i].StockId < a.Holdings[j].StockId
+	})
+	return a
+}
+
+// LedgerHistory returns every entry touching a trader, oldest first.
+func (m *TradeMatcher) LedgerHistory(traderId uint32) []Entry {
+	l := m.ledger
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := []Entry{}
+	for _, e := range l.history {
+		if e.touches(traderId) {
+			result = append(result, *e)
+		}
+	}
+	return result
+}
@@ -0,0 +1,184 @@
+package matcher
+
+import (
+	"main/matcher/pqueue"
+	pb "main/proto"
+	"sync"
+	"time"
+)
+
+// DepthLevels is how many price levels per side a Depth carries.
+const DepthLevels = 10
+
+// RecentTrades is how many trades per stock are kept for RecentTrades.
+const RecentTrades = 100
+
+// MarketDataListener receives the public trades and book changes. It is
+// called on the worker matching the stock, so stocks of different shards
+// call it concurrently, and must hand the data off without blocking.
+type MarketDataListener interface {
+	OnTrade(t *pb.Trade)
+	OnDepth(d *pb.Depth)
+}
+
+type marketData struct {
+	mu        sync.RWMutex
+	listeners map[MarketDataListener]struct{}
+	depth     map[uint64]*pb.Depth
+	trades    map[uint64][]*pb.Trade
+}
+
+func newMarketData() *marketData {
+	return &marketData{
+		listeners: make(map[MarketDataListener]struct{}),
+		depth:     make(map[uint64]*pb.Depth),
+		trades:    make(map[uint64][]*pb.Trade),
+	}
+}
+
+func (m *TradeMatcher) Subscribe(l MarketDataListener) {
+	m.md.mu.Lock()
+	defer m.md.mu.Unlock()
+	m.md.listeners[l] = struct{}{}
+}
+
+func (m *TradeMatcher) Unsubscribe(l MarketDataListener) {
+	m.md.mu.Lock()
+	defer m.md.mu.Unlock()
+	delete(m.md.listeners, l)
+}
+
+// Depth returns the last published book of stockId, it is safe to call
+// from any goroutine and never waits for the matching goroutine.
+func (m *TradeMatcher) Depth(stockId uint64) *pb.Depth {
+	m.md.mu.RLock()
+	defer m.md.mu.RUnlock()
+
+	if d, found := m.md.depth[stockId]; found {
+		return d
+	}
+	return &pb.Depth{StockId: stockId}
+}
+
+// BookOrder is an order resting in a book as Book lists it.
+type BookOrder struct {
+	OrderId  uint64 `json:"orderId"`
+	TraderId uint32 `json:"traderId"`
+	TradeId  uint32 `json:"tradeId"`
+	Price    uint64 `json:"price"`
+	Quantity uint64 `json:"quantity"`
+	Entered  int64  `json:"entered"`
+}
+
+// Book lists every order resting in the book of stockId, each side from
+// the best price to the worst and in time priority within a price. It
+// waits for the worker of the stock to match what it has queued, so it is
+// for tools and debugging, Depth serves the top of the book. It must not be
+// called before Start nor from a MarketDataListener, which runs on a worker.
+func (m *TradeMatcher) Book(stockId uint64) (bids []BookOrder, asks []BookOrder) {
+	done := make(chan struct{})
+	m.onShard(stockId, func(w *worker) {
+		defer close(done)
+		if q, found := w.matchQueues[stockId]; found {
+			bids = bookOrders(q.Buys())
+			asks = bookOrders(q.Sells())
+		}
+	})
+	<-done
+	return bids, asks
+}
+
+func bookOrders(it *pqueue.Iterator) []BookOrder {
+	var orders []BookOrder
+	for it.Next() {
+		o := it.Order()
+		orders = append(orders, BookOrder{
+			OrderId:  o.OrderId(),
+			TraderId: o.Uuid(),
+			TradeId:  o.TradeId(),
+			Price:    o.Price(),
+			Quantity: o.Quantity(),
+			Entered:  o.Entered(),
+		})
+	}
+	return orders
+}
+
+// RecentTrades returns up to n of the latest trades in stockId, newest first.
+func (m *TradeMatcher) RecentTrades(stockId uint64, n int) []*pb.Trade {
+	m.md.mu.RLock()
+	defer m.md.mu.RUnlock()
+
+	trades := m.md.trades[stockId]
+	if n <= 0 || n > len(trades) {
+		n = len(trades)
+	}
+	result := make([]*pb.Trade, n)
+	for i := range result {
+		result[i] = trades[len(trades)-1-i]
+	}
+	return result
+}
+
+func (m *TradeMatcher) publishTrade(stockId uint64, price uint64, quantity uint64) {
+	t := &pb.Trade{
+		StockId:   stockId,
+		Price:     price,
+		Quantity:  quantity,
+		Timestamp: time.Now().UnixNano(),
+	}
+
+	m.md.mu.Lock()
+	trades := append(m.md.trades[stockId], t)
+	if len(trades) > RecentTrades {
+		trades = append(trades[:0], trades[len(trades)-RecentTrades:]...)
+	}
+	m.md.trades[stockId] = trades
+	m.md.mu.Unlock()
+
+	m.md.mu.RLock()
+	defer m.md.mu.RUnlock()
+	for l := range m.md.listeners {
+		l.OnTrade(t)
+	}
+}
+
+// publishDepth snapshots the top of the book of stockId after it changed.
+func (m *TradeMatcher) publishDepth(stockId uint64, q *pqueue.MatchQueues) {
+	d := &pb.Depth{
+		StockId:   stockId,
+		Bids:      priceLevels(q.BuyLevels(DepthLevels)),
+		Asks:      priceLevels(q.SellLevels(DepthLevels)),
+		Timestamp: time.Now().UnixNano(),
+	}
+
+	m.md.mu.Lock()
+	m.md.depth[stockId] = d
+	m.md.mu.Unlock()
+	m.instruments.quoted(stockId, bestPrice(d.Bids), bestPrice(d.Asks))
+
+	m.md.mu.RLock()
+	defer m.md.mu.RUnlock()
+	for l := range m.md.listeners {
+		l.OnDepth(d)
+	}
+}
+
+func priceLevels(levels []pqueue.Level) []*pb.PriceLevel {
+	result := make([]*pb.PriceLevel, len(levels))
+	for i, l := range levels {
+		result[i] = &pb.PriceLevel{
+			Price:    l.Price,
+			Quantity: l.Quantity,
+			Orders:   uint32(l.Orders),
+		}
+	}
+	return result
+}
+
+func bestPrice(levels []*pb.PriceLevel) uint64 {
+	if len(levels) == 0 {
+		return 0
+	}
+	return levels[0].GetPrice()
+}
@@ -2,37 +2,203 @@ package matcher
 
 import (
 	"errors"
-	"fmt"
 	"github.com/golang/protobuf/proto"
 	"log"
+	"main/matcher/journal"
 	"main/matcher/pqueue"
+	"main/matcher/raft"
 	pb "main/proto"
 	"math/rand"
 	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// recvSize is kept small on purpose, packets wait in the per trader inboxes
+// where the dispatcher can still choose fairly between traders.
+const recvSize = 256
+
 type TradeMatcher struct {
-	sessions    map[uint32]*Session
+	sessions    map[uint32]Client
 	send        chan string
 	recv        chan *pb.Packet
-	matchQueues map[uint64]*pqueue.MatchQueues
-	slab        *pqueue.Slab
+	workers     []*worker
+	outbound    *outboundStore
+	md          *marketData
+	orders      *orderTracker
+	instruments *instruments
+	positions   *positionKeeper
+	ledger      *ledger
+	admin       *adminState
+	adminToken  string
+	inbound     *inbound
+	fees        *feeKeeper
+	allocations map[uint64]Allocation
+	dropCopies  *dropCopies
+	auditTrail  *auditTrail
+	input       *inputLog
+	// recovering is set while the input of before a restart is matched
+	// again, only the router changes it while the workers are idle
+	recovering  bool
+	replication Replication
+	cluster     *raft.Node
+	clusterAddr string
+	raftSecret  string
+	schedule    Schedule
+	summaryDir  string
+	auth        Authenticator
+	limits      *LimitsCheck
+	risk        []RiskCheck
+	// lastOrderId is the id of the last order numbered, only the router touches it
+	lastOrderId uint64
 
-	traderId uint32
-	r        sync.RWMutex
+	traderId   uint32
+	sessionIds map[uint32]uint64
+	sessionSeq uint64
+	r          sync.RWMutex
+	started    sync.Once
 }
 
+// NewMatcher returns a matcher which keeps its journals in memory only.
 func NewMatcher() *TradeMatcher {
+	p, _ := NewMatcherWithConfig(Config{})
+	return p
+}
+
+func NewMatcherWithConfig(cfg Config) (*TradeMatcher, error) {
+	scales := scales(cfg.Scales)
+	if err := scales.check(); err != nil {
+		return nil, err
+	}
+
+	var outJournal *journal.Journal
+	if cfg.JournalDir != "" {
+		j, err := journal.Open(filepath.Join(cfg.JournalDir, "outbound.jnl"))
+		if err != nil {
+			return nil, err
+		}
+		outJournal = j
+	}
+	outbound, err := newOutboundStore(outJournal)
+	if err != nil {
+		return nil, err
+	}
+
+	var adminJournal *journal.Journal
+	if cfg.JournalDir != "" {
+		j, err := journal.Open(filepath.Join(cfg.JournalDir, "admin.jnl"))
+		if err != nil {
+			return nil, err
+		}
+		adminJournal = j
+	}
+	admin, err := newAdminState(adminJournal)
+	if err != nil {
+		return nil, err
+	}
+
+	var auth Authenticator
+	if cfg.TokenFile != "" {
+		a, err := LoadTokenFile(cfg.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		auth = a
+	}
+
+	var auditJournal *journal.Journal
+	if cfg.JournalDir != "" {
+		j, err := journal.Open(filepath.Join(cfg.JournalDir, "audit.jnl"))
+		if err != nil {
+			return nil, err
+		}
+		auditJournal = j
+	}
+	auditTrail, err := newAuditTrail(auditJournal)
+	if err != nil {
+		return nil, err
+	}
+
+	dropCopies, err := newDropCopies(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	shards := cfg.Shards
+	if shards < 1 {
+		shards = 1
+	}
+	if cfg.Replication.Listen != "" && shards > 1 {
+		return nil, errReplicatedShards
+	}
+	if cfg.Replication.Listen != "" && cfg.Replication.Secret == "" {
+		return nil, errPeerSecret
+	}
+	var inputJournal *journal.Journal
+	if cfg.JournalDir != "" {
+		j, err := journal.Open(filepath.Join(cfg.JournalDir, "input.jnl"))
+		if err != nil {
+			return nil, err
+		}
+		inputJournal = j
+	}
+
+	input, err := newInputLog(inputJournal, cfg.Replication, cfg.Cluster.Id != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	limits := NewLimitsCheck(cfg.RiskLimits)
 	p := &TradeMatcher{
-		matchQueues: make(map[uint64]*pqueue.MatchQueues),
-		sessions:    make(map[uint32]*Session),
-		slab:        pqueue.NewSlab(20),
+		sessions:    make(map[uint32]Client),
+		outbound:    outbound,
+		md:          newMarketData(),
+		orders:      newOrderTracker(),
+		instruments: newInstruments(scales),
+		positions:   newPositionKeeper(),
+		ledger:      newLedger(scales),
+		admin:       admin,
+		adminToken:  cfg.AdminToken,
+		inbound:     newInbound(cfg.RateLimits),
+		fees:        newFeeKeeper(cfg.Fees, scales),
+		allocations: cfg.Allocations,
+		dropCopies:  dropCopies,
+		auditTrail:  auditTrail,
+		input:       input,
+		replication: cfg.Replication,
+		schedule:    cfg.Schedule,
+		summaryDir:  cfg.JournalDir,
+		auth:        auth,
+		limits:      limits,
+		risk:        []RiskCheck{limits},
 		send:        make(chan string, 65535),
-		recv:        make(chan *pb.Packet, 65535),
+		recv:        make(chan *pb.Packet, recvSize),
 		traderId:    rand.Uint32(),
+		sessionIds:  make(map[uint32]uint64),
 	}
-	return p
+	for i := 0; i < shards; i++ {
+		p.workers = append(p.workers, newWorker(p, i, cfg.Slab))
+	}
+	node, err := newClusterNode(cfg.Cluster, cfg.JournalDir, input.applied())
+	if err != nil {
+		return nil, err
+	}
+	if node != nil {
+		if err := p.JoinCluster(node); err != nil {
+			return nil, err
+		}
+		p.clusterAddr = cfg.Cluster.Peers[cfg.Cluster.Id]
+		p.raftSecret = cfg.Cluster.Secret
+	}
+	if cfg.AccountsFile != "" {
+		if err := p.LoadAccountsFile(cfg.AccountsFile); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
 }
 
 func (m *TradeMatcher) Start(potocalType string, ip string) error {
@@ -42,6 +208,22 @@ func (m *TradeMatcher) Start(potocalType string, ip string) error {
 	}
 
 	defer sock.Close()
+	if m.replication.Listen != "" {
+		standbys, err := net.Listen("tcp", m.replication.Listen)
+		if err != nil {
+			return err
+		}
+		defer standbys.Close()
+		go m.serveStandby(standbys)
+	}
+	if m.clusterAddr != "" {
+		peers, err := net.Listen("tcp", m.clusterAddr)
+		if err != nil {
+			return err
+		}
+		defer peers.Close()
+		go raft.Serve(peers, m.cluster, m.raftSecret)
+	}
 	log.Println("Wait for clients")
 
 	m.process()
@@ -58,77 +240,87 @@ func (m *TradeMatcher) Start(potocalType string, ip string) error {
 }
 
 func (m *TradeMatcher) process() {
+	m.startWorkers()
+	go m.runSchedule()
+	if m.cluster != nil {
+		m.cluster.Start()
+		go m.applyCommitted()
+	}
 	go func() {
 		for {
 			select {
 			case packet := <-m.recv:
-				order, err := m.UnPack(packet)
-				if err != nil {
-					log.Println(err)
+				if m.cluster != nil {
+					m.propose(packet, m.recv)
+					continue
 				}
-
-				fmt.Println(order)
-				on := m.slab.Malloc()
-				on.CopyFrom(order)
-				switch order.GetKind() {
-				case pb.BUY:
-					m.addBuy(on)
-				case pb.SELL:
-					m.addSell(on)
-				case pb.CANCEL:
-					m.cancel(on)
-				default:
-					panic(fmt.Sprintf("MsgKind %v not supported", order))
+				for _, r := range m.input.sequence(packet, m.recv) {
+					m.route(r)
 				}
 			}
 		}
 	}()
 }
 
-func (m *TradeMatcher) addBuy(order *pqueue.OrderNode) {
-	q := m.getMatchQueues(order.StockId())
-	if !m.fillableBuy(order, q) {
-		q.PushBuy(order)
+// startWorkers starts matching from where the input journal left off, a
+// standby starts it before it is promoted.
+func (m *TradeMatcher) startWorkers() {
+	m.started.Do(func() {
+		go m.dispatch()
+		for _, w := range m.workers {
+			go w.run()
+		}
+		m.recover()
+	})
+}
+
+// route hands one record of the input to whatever handles it. The primary
+// and its standby both route every record, so they end up in the same state.
+func (m *TradeMatcher) route(r *pb.InputRecord) {
+	packet := pb.NewPacket(r.GetTag(), r.GetData())
+	if r.GetTag() == pb.Admin {
+		m.runAdmin(packet)
+		return
+	}
+	order, err := m.UnPack(packet)
+	if err != nil {
+		log.Println(err)
+	}
+	if order.GetKind() == pb.BUY || order.GetKind() == pb.SELL {
+		order.OrderId = m.nextOrderId(r.GetTimestamp())
+	}
+
+	if r.GetTag() == pb.Rejected {
+		// Turned away at the door, the reject is reported in input order
+		m.onShard(order.GetStockId(), func(*worker) {
+			m.completeRejected(order, &Reject{order.GetRejectCode(), order.GetReason()})
+		})
+		return
+	}
+	if order.GetKind() == pb.LIST {
+		m.list(order)
+		return
 	}
+	if order.GetKind() == pb.POSITION {
+		m.positionList(order)
+		return
+	}
+	now := time.Unix(0, r.GetTimestamp())
+	w := m.shardOf(order.GetStockId())
+	w.jobs <- func() { w.match(order, now) }
 }
 
-func (m *TradeMatcher) fillableBuy(b *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
-	for {
-		s := q.PeekSell()
-		if s == nil {
-			return false
-		}
-		if b.Price() >= s.Price() {
-			if b.Quantity() > s.Quantity() {
-				quantity := s.Quantity()
-				price := price(b.Price(), s.Price())
-				s.Remove()
-				m.slab.Free(s)
-				b.ReduceQuantity(quantity)
-				m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, quantity)
-				continue // The sell has been used up
-			}
-			if s.Quantity() > b.Quantity() {
-				quantity := b.Quantity()
-				price := price(b.Price(), s.Price())
-				s.ReduceQuantity(quantity)
-				m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, quantity)
-				m.slab.Free(b)
-				return true // The buy has been used up
-			}
-			if s.Quantity() == b.Quantity() {
-				quantity := b.Quantity()
-				price := price(b.Price(), s.Price())
-				m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, quantity)
-				s.Remove()
-				m.slab.Free(s)
-				m.slab.Free(b)
-				return true // The buy and sell have been used up
-			}
-		} else {
-			return false
-		}
+// nextOrderId numbers a new order sequenced at timestamp. The ids follow
+// the sequencing clock in nanoseconds, one past the last when orders share
+// a timestamp, so they keep growing across restarts and every replica
+// matching the same input hands out the same ones.
+func (m *TradeMatcher) nextOrderId(timestamp int64) uint64 {
+	id := uint64(timestamp)
+	if id <= m.lastOrderId {
+		id = m.lastOrderId + 1
 	}
+	m.lastOrderId = id
+	return id
 }
 
 func price(price uint64, price2 uint64) uint64 {
@@ -136,38 +328,106 @@ func price(price uint64, price2 uint64) uint64 {
 	return price2 + (d / 2)
 }
 
-func (m *TradeMatcher) getMatchQueues(stockId uint64) *pqueue.MatchQueues {
-	q := m.matchQueues[stockId]
-	if q == nil {
-		q = &pqueue.MatchQueues{}
-		m.matchQueues[stockId] = q
-	}
-	return q
-}
-
 func (m *TradeMatcher) addToSessions(conn net.Conn) error {
 	if conn == nil {
 		return errors.New("conn is null")
 	}
 
+	sess := newSession(conn, m.NextTraderId(), m)
+	m.Bind(sess)
+	sess.Start()
+
+	sess.Send(&pb.TradeSession{
+		TraderId:          sess.TraderId(),
+		HeartbeatInterval: uint32(sess.HeartbeatInterval() / time.Second),
+	}, pb.TraderID)
+	return nil
+}
+
+// Logon moves a client onto the trader it had before reconnecting once its
+// token checks out, a client still bound to that trader is stopped. Reports
+// the client has not seen yet are replayed from the sequence number it
+// expects next. A client failing authentication is logged out and stopped,
+// without an Authenticator a client can only logon as the trader it is.
+func (m *TradeMatcher) Logon(c Rebindable, l *pb.Logon) bool {
+	traderId := l.GetTraderId()
+	if traderId == 0 {
+		traderId = c.TraderId()
+	}
+	store := m.outbound
+	switch {
+	case l.GetRole() == RoleDropCopy:
+		store = m.dropCopyLogon(l)
+	case !m.AuthRequired() && traderId != c.TraderId():
+		// Without tokens to check nobody proves they are the trader they name
+		store = nil
+	case !m.Authenticate(traderId, l.GetToken()):
+		store = nil
+	}
+	if store == nil {
+		c.Send(&pb.LogoutReason{Text: "authentication failed"}, pb.Logout)
+		c.Stop()
+		return false
+	}
+
 	m.r.Lock()
-	defer m.r.Unlock()
+	if old, found := m.sessions[traderId]; found && old != c {
+		defer old.Stop()
+	}
+	if m.sessions[c.TraderId()] == c {
+		delete(m.sessions, c.TraderId())
+		delete(m.sessionIds, c.TraderId())
+	}
+	c.SetTraderId(traderId)
+	m.sessions[traderId] = c
+	m.sessionSeq++
+	m.sessionIds[traderId] = m.sessionSeq
+	m.r.Unlock()
+
+	nextSeq := store.NextSeq(traderId)
+	c.Send(&pb.TradeSession{
+		TraderId:          traderId,
+		HeartbeatInterval: uint32(c.HeartbeatInterval() / time.Second),
+	}, pb.TraderID)
 
-	sess := &Session{
-		conn:        conn,
-		messageSend: m.recv,
-		messageRecv: make(chan string, 65535),
+	expected := l.GetNextSeqNum()
+	switch {
+	case expected > nextSeq:
+		// The client counted reports this engine never sent, it has to start over
+		c.Send(&pb.SequenceReset{NewSeqNum: nextSeq}, pb.SeqReset)
+	case expected != 0 && expected < nextSeq:
+		m.Resend(c, &pb.ResendRequest{BeginSeq: expected})
 	}
+	return true
+}
 
-	traderSession := &pb.TradeSession{
-		TraderId: m.traderId,
+// Resend replays stored execution reports flagged as possible duplicates,
+// a drop copy gets its own stream replayed.
+func (m *TradeMatcher) Resend(c Client, r *pb.ResendRequest) {
+	store := m.outbound
+	if s := m.dropCopies.stream(c.TraderId()); s != nil {
+		store = s.store
 	}
-	m.sessions[traderSession.GetTraderId()] = sess
-	sess.Start()
-	m.traderId++
+	for _, report := range store.Range(c.TraderId(), r.GetBeginSeq(), r.GetEndSeq()) {
+		order := proto.Clone(report.order).(*pb.Order)
+		order.PossDup = true
+		c.Send(order, report.tag)
+	}
+}
 
-	sess.Send(traderSession, pb.TraderID)
-	return nil
+// report numbers an execution report and delivers it if the trader is connected,
+// the caller must hold m.r. Reports of a stock with decimals carry them too.
+func (m *TradeMatcher) report(traderId uint32, order *pb.Order, tag string) {
+	if scale, found := m.instruments.scales[order.GetStockId()]; found {
+		scale.decorate(order)
+	}
+	if err := m.outbound.Append(traderId, order, tag); err != nil {
+		log.Println(err)
+	}
+	if trader, found := m.sessions[traderId]; found && !m.recovering {
+		trader.Send(order, tag)
+	}
+	m.copy(order, tag)
 }
 
 func (m *TradeMatcher) UnPack(packet *pb.Packet) (*pb.Order, error) {
@@ -176,114 +436,164 @@ func (m *TradeMatcher) UnPack(packet *pb.Packet) (*pb.Order, error) {
 	return o, nil
 }
 
-func (m *TradeMatcher) addSell(s *pqueue.OrderNode) {
-	q := m.getMatchQueues(s.StockId())
-	if !m.fillableSell(s, q) {
-		q.PushSell(s)
+// list answers a LIST query with the trader's resting orders, of one
+// stock when the query names it, read straight from the books.
+func (m *TradeMatcher) list(query *pb.Order) {
+	traderId := query.GetUuid()
+	result := &pb.OrderList{TraderId: traderId}
+	if query.GetStockId() != 0 {
+		m.onShard(query.GetStockId(), func(w *worker) {
+			result.Orders = w.openOrders(traderId, query.GetStockId())
+			m.sendList(result)
+		})
+		return
 	}
+
+	var mu sync.Mutex
+	m.eachShard(func(w *worker) {
+		orders := w.openOrders(traderId, 0)
+		mu.Lock()
+		result.Orders = append(result.Orders, orders...)
+		mu.Unlock()
+	})
+	sort.SliceStable(result.Orders, func(i, j int) bool {
+		return result.Orders[i].GetStockId() < result.Orders[j].GetStockId()
+	})
+	m.sendList(result)
 }
 
-func (m *TradeMatcher) fillableSell(s *pqueue.OrderNode, q *pqueue.MatchQueues) bool {
-	for {
-		b := q.PeekBuy()
-		if b == nil {
-			return false
-		}
-		if b.Price() >= s.Price() {
-			if b.Quantity() > s.Quantity() {
-				amount := s.Quantity()
-				price := price(b.Price(), s.Price())
-				b.ReduceQuantity(amount)
-				m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, amount)
-				s.Remove()
-				m.slab.Free(s)
-				return true // The sell has been used up
-			}
-			if s.Quantity() > b.Quantity() {
-				amount := b.Quantity()
-				price := price(b.Price(), s.Price())
-				s.ReduceQuantity(amount)
-				m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, amount)
-				b.Remove()
-				m.slab.Free(b) // The buy has been used up
-				continue
-			}
-			if s.Quantity() == b.Quantity() {
-				amount := b.Quantity()
-				price := price(b.Price(), s.Price())
-				m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, amount)
-				b.Remove()
-				m.slab.Free(b)
-				m.slab.Free(s)
-				return true // The sell and buy have been used up
-			}
-		} else {
-			return false
-		}
+func (m *TradeMatcher) sendList(result *pb.OrderList) {
+	m.r.RLock()
+	defer m.r.RUnlock()
+	if trader, found := m.sessions[result.GetTraderId()]; found {
+		trader.Send(result, pb.List)
 	}
 }
 
-func (m *TradeMatcher) cancel(o *pqueue.OrderNode) {
-	q := m.getMatchQueues(o.StockId())
-	ro := q.Cancel(o)
-	if ro != nil {
-		m.completeCancelled(ro)
-		m.slab.Free(ro)
-	} else {
-		m.completeNotCancelled(o)
+// completeTrade books a fill between b and s, taker is the side of the
+// order that came in and took the liquidity the other one left resting.
+// Fees go by now, the time the taker was sequenced at, so a replay books
+// the fill in the same month as the first run.
+func (m *TradeMatcher) completeTrade(partial int32, full int32, b *pqueue.OrderNode, s *pqueue.OrderNode, price uint64, quantity uint64, taker int32, now time.Time) {
+	m.publishTrade(b.StockId(), price, quantity)
+	m.instruments.traded(b.StockId(), price, quantity)
+	m.positions.traded(b.Uuid(), s.Uuid(), b.StockId(), m.instruments.scales.of(b.StockId()), price, quantity)
+	if m.ledger.on() {
+		bought, _ := m.OrderStatus(b.Uuid(), b.OrderId())
+		m.ledger.settle(b, s, price, quantity, bought.Remaining)
 	}
-	m.slab.Free(o)
-}
+	buyFee := m.fees.charge(b.Uuid(), b.StockId(), price, quantity, taker != pb.BUY, now)
+	sellFee := m.fees.charge(s.Uuid(), s.StockId(), price, quantity, taker != pb.SELL, now)
+	m.orders.filled(b, quantity)
+	m.orders.filled(s, quantity)
+	m.auditFill(b, price, quantity)
+	m.auditFill(s, price, quantity)
 
-func (m *TradeMatcher) completeTrade(partial int32, full int32, b *pqueue.OrderNode, s *pqueue.OrderNode, price uint64, quantity uint64) {
 	m.r.RLock()
 	defer m.r.RUnlock()
 
-	if buyer, found := m.sessions[b.Uuid()]; found {
-		order := &pb.Order{
-			Uuid:     b.Uuid(),
-			TradeId:  b.TradeId(),
-			StockId:  b.StockId(),
-			Kind:     partial,
-			Price:    price,
-			Quantity: quantity,
-		}
-		buyer.Send(order, pb.Buy)
-	}
+	m.report(b.Uuid(), &pb.Order{
+		Uuid:          b.Uuid(),
+		TradeId:       b.TradeId(),
+		OrderId:       b.OrderId(),
+		ClientOrderId: b.ClientOrderId(),
+		StockId:       b.StockId(),
+		Kind:          partial,
+		Price:         price,
+		Quantity:      quantity,
+		Fee:           buyFee,
+		Maker:         taker != pb.BUY,
+	}, pb.Buy)
 
-	if seller, found := m.sessions[s.Uuid()]; found {
-		order := &pb.Order{
-			Uuid:     s.Uuid(),
-			TradeId:  s.TradeId(),
-			StockId:  s.StockId(),
-			Kind:     full,
-			Price:    price,
-			Quantity: quantity,
-		}
-		seller.Send(order, pb.Buy)
-	}
+	m.report(s.Uuid(), &pb.Order{
+		Uuid:          s.Uuid(),
+		TradeId:       s.TradeId(),
+		OrderId:       s.OrderId(),
+		ClientOrderId: s.ClientOrderId(),
+		StockId:       s.StockId(),
+		Kind:          full,
+		Price:         price,
+		Quantity:      quantity,
+		Fee:           sellFee,
+		Maker:         taker != pb.SELL,
+	}, pb.Buy)
 }
 
-func (m *TradeMatcher) completeCancelled(o *pqueue.OrderNode) {
+func (m *TradeMatcher) completeCancelled(o *pqueue.OrderNode, reason string) {
 	cm := pb.Order{}
 	o.CopyTo(&cm)
 	cm.Kind = pb.CANCEL
+	m.orders.cancelled(o)
+	m.ledger.release(o)
+	m.auditNode(AuditCancelled, o, 0, reason)
 
 	m.r.RLock()
 	defer m.r.RUnlock()
-	if trader, found := m.sessions[o.Uuid()]; found {
-		trader.Send(&cm, pb.Cancel)
-	}
+	m.report(o.Uuid(), &cm, pb.Cancel)
 }
 
 func (m *TradeMatcher) completeNotCancelled(nc *pqueue.OrderNode) {
 	ncm := pb.Order{}
 	nc.CopyTo(&ncm)
 	ncm.Kind = pb.NOT_CANCELLED
+	m.auditNode(AuditRejected, nc, 0, "no open order to cancel")
+
+	m.r.RLock()
+	defer m.r.RUnlock()
+	m.report(nc.Uuid(), &ncm, pb.NotCancelled)
+}
+
+func (m *TradeMatcher) completeRejected(order *pb.Order, reject *Reject) {
+	if order.GetKind() == pb.BUY || order.GetKind() == pb.SELL {
+		m.orders.rejected(order, reject.Text)
+	}
+	m.auditOrder(AuditRejected, order, 0, reject.Text)
+	rm := pb.Order{
+		Uuid:          order.GetUuid(),
+		TradeId:       order.GetTradeId(),
+		OrderId:       order.GetOrderId(),
+		ClientOrderId: order.GetClientOrderId(),
+		StockId:       order.GetStockId(),
+		Kind:          pb.REJECTED,
+		Quantity:      order.GetQuantity(),
+		Price:         order.GetPrice(),
+		RejectCode:    reject.Code,
+		Reason:        reject.Text,
+		// A reject of decimals the stock cannot take shows them as sent
+		DecimalPrice:    order.GetDecimalPrice(),
+		DecimalQuantity: order.GetDecimalQuantity(),
+	}
 
 	m.r.RLock()
 	defer m.r.RUnlock()
-	if trader, found := m.sessions[nc.Uuid()]; found {
-		trader.Send(&ncm, pb.NotCancelled)
+	m.report(order.GetUuid(), &rm, pb.Rejected)
+}
+
+func (m *TradeMatcher) completeExpired(o *pqueue.OrderNode) {
+	em := pb.Order{}
+	o.CopyTo(&em)
+	em.Kind = pb.EXPIRED
+	m.orders.expired(o)
+	m.ledger.release(o)
+	reason := "day order"
+	if o.TimeInForce() == pb.TIF_GTD {
+		reason = "good till date " + strconv.FormatUint(uint64(o.ExpireDate()), 10)
 	}
+	m.auditNode(AuditExpired, o, 0, reason)
+
+	m.r.RLock()
+	defer m.r.RUnlock()
+	m.report(o.Uuid(), &em, pb.Expired)
+}
+
+func (m *TradeMatcher) completeAmended(o *pqueue.OrderNode) {
+	am := pb.Order{}
+	o.CopyTo(&am)
+	am.Kind = pb.AMEND
+	m.orders.amended(o)
+	m.auditNode(AuditAmended, o, o.Quantity(), "")
+
+	m.r.RLock()
+	defer m.r.RUnlock()
+	m.report(o.Uuid(), &am, pb.Amend)
 }
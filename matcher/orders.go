@@ -0,0 +1,220 @@
+package matcher
+
+import (
+	"fmt"
+	"main/matcher/pqueue"
+	pb "main/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Order states reported by OrderView.
+const (
+	OrderOpen      = "open"
+	OrderPartial   = "partial"
+	OrderFilled    = "filled"
+	OrderCancelled = "cancelled"
+	OrderRejected  = "rejected"
+	OrderExpired   = "expired"
+)
+
+// OrderView is the state of an order as the matching goroutine last saw it.
+type OrderView struct {
+	OrderId       uint64    `json:"orderId"`
+	TraderId      uint32    `json:"traderId"`
+	TradeId       uint32    `json:"tradeId"`
+	ClientOrderId string    `json:"clientOrderId,omitempty"`
+	StockId       uint64    `json:"stockId"`
+	Side          int32     `json:"side"`
+	Price         uint64    `json:"price"`
+	Quantity      uint64    `json:"quantity"`
+	Remaining     uint64    `json:"remaining"`
+	Filled        uint64    `json:"filled"`
+	Status        string    `json:"status"`
+	Reason        string    `json:"reason,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+func (o *OrderView) open() bool {
+	return o.Status == OrderOpen || o.Status == OrderPartial
+}
+
+// orderTracker mirrors the order lifecycle for readers outside the matching
+// workers. Only the workers write, readers get copies. Closed
+// orders are kept so their status stays queryable for the day.
+type orderTracker struct {
+	mu     sync.RWMutex
+	orders map[uint64]*OrderView
+	// clients holds the last order sent under each client key
+	clients map[pqueue.ClientKey]*OrderView
+	open    map[uint32]map[uint64]*OrderView
+}
+
+func newOrderTracker() *orderTracker {
+	return &orderTracker{
+		orders:  make(map[uint64]*OrderView),
+		clients: make(map[pqueue.ClientKey]*OrderView),
+		open:    make(map[uint32]map[uint64]*OrderView),
+	}
+}
+
+func (t *orderTracker) accepted(o *pb.Order) {
+	v := newOrderView(o, OrderOpen)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keep(o, v)
+	if t.open[v.TraderId] == nil {
+		t.open[v.TraderId] = make(map[uint64]*OrderView)
+	}
+	t.open[v.TraderId][v.OrderId] = v
+}
+
+func (t *orderTracker) rejected(o *pb.Order, reason string) {
+	v := newOrderView(o, OrderRejected)
+	v.Remaining = 0
+	v.Reason = reason
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.keep(o, v)
+}
+
+// keep files v under the ids of o, the caller must hold t.mu.
+func (t *orderTracker) keep(o *pb.Order, v *OrderView) {
+	t.orders[v.OrderId] = v
+	t.clients[pqueue.ClientKeyOf(o.GetUuid(), o.GetTradeId(), o.GetClientOrderId())] = v
+}
+
+// named returns the order ref names, by order id when it has one and by
+// client key otherwise. Only the trader of an order may name it.
+func (t *orderTracker) named(ref *pb.Order) (OrderView, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	v, found := t.clients[pqueue.ClientKeyOf(ref.GetUuid(), ref.GetTradeId(), ref.GetClientOrderId())]
+	if ref.GetOrderId() != 0 {
+		v, found = t.orders[ref.GetOrderId()]
+	}
+	if !found || v.TraderId != ref.GetUuid() {
+		return OrderView{}, false
+	}
+	return *v, true
+}
+
+func newOrderView(o *pb.Order, status string) *OrderView {
+	now := time.Now()
+	return &OrderView{
+		OrderId:       o.GetOrderId(),
+		TraderId:      o.GetUuid(),
+		TradeId:       o.GetTradeId(),
+		ClientOrderId: o.GetClientOrderId(),
+		StockId:       o.GetStockId(),
+		Side:          o.GetKind(),
+		Price:         o.GetPrice(),
+		Quantity:      o.GetQuantity(),
+		Remaining:     o.GetQuantity(),
+		Status:        status,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+}
+
+func (t *orderTracker) filled(o *pqueue.OrderNode, quantity uint64) {
+	t.update(o, func(v *OrderView) {
+		v.Filled += quantity
+		v.Remaining -= quantity
+		v.Status = OrderPartial
+		if v.Remaining == 0 {
+			v.Status = OrderFilled
+		}
+	})
+}
+
+// leaves sets the quantity of an amend sent with the new total quantity of
+// its order to what is left of that after the fills the order has had, it
+// rejects the amend when they add up to the total already. An amend of an
+// order that is not open goes on unchanged to be turned away.
+func (t *orderTracker) leaves(amend *pb.Order) *Reject {
+	total := amend.GetOrderQuantity()
+	amend.Quantity = total
+	amend.DecimalQuantity = nil
+	v, found := t.named(amend)
+	if !found || !v.open() {
+		return nil
+	}
+	if total <= v.Filled {
+		return &Reject{pb.REJECT_OVERFILLED, fmt.Sprintf("order quantity %d does not exceed the %d filled", total, v.Filled)}
+	}
+	amend.Quantity = total - v.Filled
+	return nil
+}
+
+func (t *orderTracker) cancelled(o *pqueue.OrderNode) {
+	t.update(o, func(v *OrderView) {
+		v.Remaining = 0
+		v.Status = OrderCancelled
+	})
+}
+
+func (t *orderTracker) expired(o *pqueue.OrderNode) {
+	t.update(o, func(v *OrderView) {
+		v.Remaining = 0
+		v.Status = OrderExpired
+	})
+}
+
+func (t *orderTracker) amended(o *pqueue.OrderNode) {
+	t.update(o, func(v *OrderView) {
+		v.Price = o.Price()
+		v.Quantity = v.Filled + o.Quantity()
+		v.Remaining = o.Quantity()
+		if v.Remaining == 0 {
+			v.Status = OrderCancelled
+		}
+	})
+}
+
+func (t *orderTracker) update(o *pqueue.OrderNode, fn func(v *OrderView)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, found := t.orders[o.OrderId()]
+	if !found {
+		return
+	}
+	fn(v)
+	v.UpdatedAt = time.Now()
+	if !v.open() {
+		delete(t.open[v.TraderId], o.OrderId())
+	}
+}
+
+// OpenOrders returns the resting orders of a trader, oldest first.
+func (m *TradeMatcher) OpenOrders(traderId uint32) []OrderView {
+	m.orders.mu.RLock()
+	defer m.orders.mu.RUnlock()
+
+	result := make([]OrderView, 0, len(m.orders.open[traderId]))
+	for _, v := range m.orders.open[traderId] {
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// OrderStatus returns the order of a trader the matcher gave orderId, open or not.
+func (m *TradeMatcher) OrderStatus(traderId uint32, orderId uint64) (OrderView, bool) {
+	return m.orders.named(&pb.Order{Uuid: traderId, OrderId: orderId})
+}
+
+// ClientOrderStatus returns the last order a trader sent under
+// clientOrderId, open or not. An order sent without a client order id goes
+// by its trade id in decimal.
+func (m *TradeMatcher) ClientOrderStatus(traderId uint32, clientOrderId string) (OrderView, bool) {
+	return m.orders.named(&pb.Order{Uuid: traderId, ClientOrderId: clientOrderId})
+}
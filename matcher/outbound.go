@@ -0,0 +1,115 @@
+package matcher
+
+import (
+	"github.com/golang/protobuf/proto"
+	"main/matcher/journal"
+	pb "main/proto"
+	"sync"
+)
+
+type outboundReport struct {
+	tag   string
+	order *pb.Order
+}
+
+// outboundStore numbers the execution reports of every trader and keeps
+// them for resend, the journal lets the numbering survive a restart.
+type outboundStore struct {
+	mu      sync.Mutex
+	journal *journal.Journal
+	traders map[uint32][]outboundReport
+	// replayed counts down the reports of the journal a recovery numbers
+	// again, they are neither kept nor written twice
+	replayed int
+}
+
+func newOutboundStore(j *journal.Journal) (*outboundStore, error) {
+	return openOutboundStore(j, (*pb.Order).GetUuid)
+}
+
+// openOutboundStore replays the journal filing each report under keyOf.
+func openOutboundStore(j *journal.Journal, keyOf func(order *pb.Order) uint32) (*outboundStore, error) {
+	o := &outboundStore{
+		journal: j,
+		traders: make(map[uint32][]outboundReport),
+	}
+	if j == nil {
+		return o, nil
+	}
+	err := j.Replay(func(packet *pb.Packet) error {
+		order := &pb.Order{}
+		if err := proto.Unmarshal(packet.Data, order); err != nil {
+			return err
+		}
+		key := keyOf(order)
+		o.traders[key] = append(o.traders[key], outboundReport{string(packet.GetTag()), order})
+		return nil
+	})
+	return o, err
+}
+
+// Append stamps the next sequence number of the trader on the report.
+func (o *outboundStore) Append(traderId uint32, order *pb.Order, tag string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.replayed > 0 {
+		o.replayed--
+		return nil
+	}
+	order.SeqNum = uint64(len(o.traders[traderId])) + 1
+	o.traders[traderId] = append(o.traders[traderId], outboundReport{tag, order})
+	if o.journal == nil {
+		return nil
+	}
+	data, err := proto.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return o.journal.Append(tag, data)
+}
+
+// replay lets the next appends, as many as the journal holds, pass as
+// the reports a recovery matches again.
+func (o *outboundStore) replay() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.replayed = 0
+	for _, reports := range o.traders {
+		o.replayed += len(reports)
+	}
+}
+
+// recovered ends a recovery, reports the journal missed are kept from now on.
+func (o *outboundStore) recovered() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.replayed = 0
+}
+
+// NextSeq returns the sequence number the next report of the trader will carry.
+func (o *outboundStore) NextSeq(traderId uint32) uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return uint64(len(o.traders[traderId])) + 1
+}
+
+// Range returns the reports numbered begin to end inclusive, end 0 means up to the latest.
+func (o *outboundStore) Range(traderId uint32, begin uint64, end uint64) []outboundReport {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	reports := o.traders[traderId]
+	if begin == 0 {
+		begin = 1
+	}
+	if end == 0 || end > uint64(len(reports)) {
+		end = uint64(len(reports))
+	}
+	if begin > end {
+		return nil
+	}
+	result := make([]outboundReport, end-begin+1)
+	copy(result, reports[begin-1:end])
+	return result
+}
@@ -0,0 +1,72 @@
+// Package peer lets the engines of a cluster or a primary and its standby
+// make sure of each other before trusting a connection: both ends prove
+// they know a shared secret without sending it. The traffic after the
+// handshake is not encrypted, keep it on a network the engines own.
+package peer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// nonceSize is the length of the challenge each end sends.
+const nonceSize = 32
+
+// timeout bounds the whole handshake, a peer that says nothing is dropped.
+const timeout = 5 * time.Second
+
+var ErrRefused = errors.New("peer does not know the secret")
+
+// Dial proves the dialing end of conn knows secret and checks the other
+// end does too.
+func Dial(conn net.Conn, secret string) error {
+	return handshake(conn, secret, "dial", "accept")
+}
+
+// Accept is Dial for the accepting end of conn.
+func Accept(conn net.Conn, secret string) error {
+	return handshake(conn, secret, "accept", "dial")
+}
+
+// handshake swaps nonces and answers each with a MAC naming the end that
+// made it, so a peer cannot pass off the answer of the other end as its own.
+func handshake(conn net.Conn, secret string, self string, other string) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	mine := make([]byte, nonceSize)
+	if _, err := rand.Read(mine); err != nil {
+		return err
+	}
+	if _, err := conn.Write(mine); err != nil {
+		return err
+	}
+	theirs := make([]byte, nonceSize)
+	if _, err := io.ReadFull(conn, theirs); err != nil {
+		return err
+	}
+	if _, err := conn.Write(mac(secret, self, mine, theirs)); err != nil {
+		return err
+	}
+	answer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(conn, answer); err != nil {
+		return err
+	}
+	if !hmac.Equal(answer, mac(secret, other, theirs, mine)) {
+		return ErrRefused
+	}
+	return nil
+}
+
+func mac(secret string, role string, first []byte, second []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(role))
+	h.Write(first)
+	h.Write(second)
+	return h.Sum(nil)
+}
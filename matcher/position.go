@@ -0,0 +1,232 @@
+package matcher
+
+import (
+	pb "main/proto"
+	"math"
+	"sort"
+	"sync"
+)
+
+// AvgCostDecimals is how many decimal places Position.AvgCost has beyond
+// the prices of its stock.
+const AvgCostDecimals = 4
+
+// Position is what a trader holds in one stock since the engine started,
+// Net is positive when long and negative when short. Cost is what the open
+// position was bought for, or sold for when short, a trade reducing it
+// realizes the difference to its share of Cost into RealizedPnL. Money is
+// in the units of the prices of the stock, as cash and fees are.
+type Position struct {
+	TraderId    uint32 `json:"traderId"`
+	StockId     uint64 `json:"stockId"`
+	Net         int64  `json:"net"`
+	Bought      uint64 `json:"bought"`
+	Sold        uint64 `json:"sold"`
+	Cost        uint64 `json:"cost"`
+	AvgCost     uint64 `json:"avgCost"`
+	RealizedPnL int64  `json:"realizedPnL"`
+}
+
+// apply books one execution, quantity is positive for a buy and negative for a sell.
+func (p *Position) apply(quantity int64, price uint64, scale Scale) {
+	if quantity > 0 {
+		p.Bought += uint64(quantity)
+	} else {
+		p.Sold += uint64(-quantity)
+	}
+
+	if p.Net == 0 || (p.Net > 0) == (quantity > 0) {
+		// Opening or adding to the position
+		p.Cost = addCost(p.Cost, scale.notional(uint64(abs(quantity)), price))
+		p.Net += quantity
+		p.AvgCost = avgCost(p.Cost, p.Net, scale)
+		return
+	}
+
+	closed := abs(quantity)
+	if closed > abs(p.Net) {
+		closed = abs(p.Net)
+	}
+	share := mulDiv(uint64(closed), p.Cost, uint64(abs(p.Net)))
+	value := scale.notional(uint64(closed), price)
+	if p.Net > 0 {
+		p.RealizedPnL = addPnL(p.RealizedPnL, difference(value, share))
+	} else {
+		p.RealizedPnL = addPnL(p.RealizedPnL, difference(share, value))
+	}
+	p.Cost -= share
+	p.Net += quantity
+	if p.Net != 0 && (p.Net > 0) == (quantity > 0) {
+		// Flipped over, what is left opened at this price
+		p.Cost = scale.notional(uint64(abs(p.Net)), price)
+	}
+	p.AvgCost = avgCost(p.Cost, p.Net, scale)
+}
+
+// avgCost is cost over the net quantity per whole unit of the stock, with
+// AvgCostDecimals more places than its prices.
+func avgCost(cost uint64, net int64, scale Scale) uint64 {
+	if net == 0 {
+		return 0
+	}
+	if scale.Quantity+AvgCostDecimals <= pb.MaxScale {
+		return mulDiv(cost, pb.Pow10(scale.Quantity+AvgCostDecimals), uint64(abs(net)))
+	}
+	return mulDiv(mulDiv(cost, pb.Pow10(scale.Quantity), uint64(abs(net))), pb.Pow10(AvgCostDecimals), 1)
+}
+
+func addCost(a uint64, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+// difference is a less b, saturating at the bounds of an int64.
+func difference(a uint64, b uint64) int64 {
+	if a >= b {
+		if a-b > math.MaxInt64 {
+			return math.MaxInt64
+		}
+		return int64(a - b)
+	}
+	if b-a > math.MaxInt64 {
+		return -math.MaxInt64
+	}
+	return -int64(b - a)
+}
+
+// addPnL sums profits and losses, saturating at the bounds of an int64.
+func addPnL(a int64, b int64) int64 {
+	switch {
+	case b > 0 && a > math.MaxInt64-b:
+		return math.MaxInt64
+	case b < 0 && a < -math.MaxInt64-b:
+		return -math.MaxInt64
+	}
+	return a + b
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// positionKeeper follows every execution, only the matching workers write.
+type positionKeeper struct {
+	mu      sync.RWMutex
+	traders map[uint32]map[uint64]*Position
+}
+
+func newPositionKeeper() *positionKeeper {
+	return &positionKeeper{traders: make(map[uint32]map[uint64]*Position)}
+}
+
+func (k *positionKeeper) traded(buyer uint32, seller uint32, stockId uint64, scale Scale, price uint64, quantity uint64) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.get(buyer, stockId).apply(int64(quantity), price, scale)
+	k.get(seller, stockId).apply(-int64(quantity), price, scale)
+}
+
+// get returns the position, the caller must hold mu.
+func (k *positionKeeper) get(traderId uint32, stockId uint64) *Position {
+	stocks, found := k.traders[traderId]
+	if !found {
+		stocks = make(map[uint64]*Position)
+		k.traders[traderId] = stocks
+	}
+	p, found := stocks[stockId]
+	if !found {
+		p = &Position{TraderId: traderId, StockId: stockId}
+		stocks[stockId] = p
+	}
+	return p
+}
+
+// Position returns the position of a trader in one stock, flat if it never traded it.
+func (m *TradeMatcher) Position(traderId uint32, stockId uint64) Position {
+	m.positions.mu.RLock()
+	defer m.positions.mu.RUnlock()
+
+	if p, found := m.positions.traders[traderId][stockId]; found {
+		return *p
+	}
+	return Position{TraderId: traderId, StockId: stockId}
+}
+
+// Positions returns every position of a trader ordered by StockId.
+func (m *TradeMatcher) Positions(traderId uint32) []Position {
+	m.positions.mu.RLock()
+	defer m.positions.mu.RUnlock()
+
+	result := make([]Position, 0, len(m.positions.traders[traderId]))
+	for _, p := range m.positions.traders[traderId] {
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StockId < result[j].StockId
+	})
+	return result
+}
+
+// positionList answers a POSITION query, of one stock when the query names
+// it, on the shards of the stocks once they matched what came before it.
+func (m *TradeMatcher) positionList(query *pb.Order) {
+	traderId := query.GetUuid()
+	result := &pb.PositionList{TraderId: traderId}
+	if query.GetStockId() != 0 {
+		m.onShard(query.GetStockId(), func(w *worker) {
+			p := m.Position(traderId, query.GetStockId())
+			result.Positions = []*pb.Position{p.proto()}
+			m.sendPositions(result)
+		})
+		return
+	}
+
+	var mu sync.Mutex
+	m.eachShard(func(w *worker) {
+		positions := w.positions(traderId)
+		mu.Lock()
+		result.Positions = append(result.Positions, positions...)
+		mu.Unlock()
+	})
+	sort.Slice(result.Positions, func(i, j int) bool {
+		return result.Positions[i].GetStockId() < result.Positions[j].GetStockId()
+	})
+	m.sendPositions(result)
+}
+
+// positions returns the positions of a trader in the stocks of this shard.
+func (w *worker) positions(traderId uint32) []*pb.Position {
+	var result []*pb.Position
+	for _, p := range w.m.Positions(traderId) {
+		if w.m.shardOf(p.StockId) == w {
+			result = append(result, p.proto())
+		}
+	}
+	return result
+}
+
+func (p Position) proto() *pb.Position {
+	return &pb.Position{
+		StockId:     p.StockId,
+		Net:         p.Net,
+		Bought:      p.Bought,
+		Sold:        p.Sold,
+		Cost:        p.Cost,
+		AvgCost:     p.AvgCost,
+		RealizedPnl: p.RealizedPnL,
+	}
+}
+
+func (m *TradeMatcher) sendPositions(result *pb.PositionList) {
+	m.r.RLock()
+	defer m.r.RUnlock()
+	if trader, found := m.sessions[result.GetTraderId()]; found {
+		trader.Send(result, pb.Positions)
+	}
+}
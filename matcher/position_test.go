@@ -0,0 +1,73 @@
+package matcher
+
+import (
+	pb "main/proto"
+	"testing"
+	"time"
+)
+
+func TestPositionKeepsCostInPriceUnits(t *testing.T) {
+	p := &Position{}
+	for _, c := range []struct {
+		quantity int64
+		price    uint64
+		net      int64
+		cost     uint64
+		avgCost  uint64
+		realized int64
+	}{
+		{10, 100, 10, 1000, 1000000, 0},
+		{10, 105, 20, 2050, 1025000, 0},
+		// Closing 15 of 20 takes 1537 of the cost, the rest stays with the 5 left
+		{-15, 110, 5, 513, 1026000, 113},
+		// Flipped short, the 5 left over opened at 100
+		{-10, 100, -5, 500, 1000000, 100},
+		{5, 90, 0, 0, 0, 150},
+	} {
+		p.apply(c.quantity, c.price, Scale{})
+		if p.Net != c.net || p.Cost != c.cost || p.AvgCost != c.avgCost || p.RealizedPnL != c.realized {
+			t.Fatalf("after %d at %d the position is %+v, want net %d cost %d avg %d realized %d",
+				c.quantity, c.price, *p, c.net, c.cost, c.avgCost, c.realized)
+		}
+	}
+	if p.Bought != 25 || p.Sold != 25 {
+		t.Fatalf("bought %d sold %d, want 25 each", p.Bought, p.Sold)
+	}
+}
+
+func TestPositionWithDecimals(t *testing.T) {
+	scale := Scale{Price: 2, Quantity: 3}
+	p := &Position{}
+	// 1.5 at 12.34 and 0.5 at 12.00
+	p.apply(1500, 1234, scale)
+	p.apply(500, 1200, scale)
+	if p.Cost != 2451 || p.AvgCost != 12255000 {
+		t.Fatalf("cost %d avg %d, want 24.51 and 12.255", p.Cost, p.AvgCost)
+	}
+	p.apply(-2000, 1300, scale)
+	if p.Net != 0 || p.Cost != 0 || p.RealizedPnL != 149 {
+		t.Fatalf("position %+v, want flat with 1.49 realized", *p)
+	}
+}
+
+func TestPositionQueryFollowsTrades(t *testing.T) {
+	m := startTestMatcher(t, Config{Shards: 2})
+	buyer := bindTestClient(m, 1)
+	m.Submit(&pb.Order{Uuid: 2, TradeId: 1, StockId: 1, Kind: pb.SELL, Price: 100, Quantity: 10}, pb.Sell)
+	m.Submit(&pb.Order{Uuid: 2, TradeId: 2, StockId: 2, Kind: pb.SELL, Price: 50, Quantity: 10}, pb.Sell)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 1, StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 4}, pb.Buy)
+	m.Submit(&pb.Order{Uuid: 1, TradeId: 2, StockId: 2, Kind: pb.BUY, Price: 50, Quantity: 6}, pb.Buy)
+	// Queued behind the buys, answered once they are matched
+	m.Submit(&pb.Order{Uuid: 1, Kind: pb.POSITION}, pb.Positions)
+
+	select {
+	case list := <-buyer.positions:
+		positions := list.GetPositions()
+		if len(positions) != 2 || positions[0].GetStockId() != 1 || positions[0].GetNet() != 4 || positions[0].GetCost() != 400 ||
+			positions[1].GetStockId() != 2 || positions[1].GetNet() != 6 || positions[1].GetAvgCost() != 500000 {
+			t.Fatalf("positions are %v, want 4 of stock 1 for 400 and 6 of stock 2 at 50", positions)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("no answer to the position query")
+	}
+}
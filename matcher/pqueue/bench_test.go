@@ -0,0 +1,232 @@
+package pqueue_test
+
+// Benchmarks of the order book under the load of a busy stock, run with
+// go test -bench . ./matcher/pqueue. Each runs against the price levels of
+// pqueue and against rbbook, the tree of orders pqueue kept before, so
+//
+//	go test -run - -bench . -count 10 ./matcher/pqueue > bench.txt
+//	benchstat -col /book bench.txt
+//
+// sets the two side by side.
+
+import (
+	"main/matcher/pqueue"
+	"main/matcher/pqueue/internal/rbbook"
+	pb "main/proto"
+	"math/rand"
+	"testing"
+)
+
+const (
+	resting = 10000 // Orders in the book of each benchmark
+	levels  = 100   // Prices they rest at
+	depth   = 10    // Levels of a depth snapshot
+)
+
+// orderBook is what the benchmarks do to a book, order i is the i-th one
+// rested.
+type orderBook interface {
+	rest(o *pb.Order)
+	// pushPop rests a buy and takes the best buy off again
+	pushPop(o *pb.Order)
+	// cancel takes order i out and rests it again, as the matcher does an
+	// amended order
+	cancel(i int)
+	// fill takes one off the best sell, a new sell at its price takes its
+	// place once it is used up
+	fill(next *pb.Order)
+	// best is the total quantity at the best bid
+	best() uint64
+	snapshot(n int)
+}
+
+type levelsBook struct {
+	q      pqueue.MatchQueues
+	orders []*pqueue.OrderNode
+}
+
+func (l *levelsBook) push(o *pqueue.OrderNode) {
+	if o.Kind() == pb.BUY {
+		l.q.PushBuy(o)
+	} else {
+		l.q.PushSell(o)
+	}
+}
+
+func (l *levelsBook) rest(order *pb.Order) {
+	o := &pqueue.OrderNode{}
+	o.CopyFrom(order)
+	l.push(o)
+	l.orders = append(l.orders, o)
+}
+
+func (l *levelsBook) pushPop(order *pb.Order) {
+	o := &pqueue.OrderNode{}
+	o.CopyFrom(order)
+	l.q.PushBuy(o)
+	l.q.PopBuy()
+}
+
+func (l *levelsBook) cancel(i int) {
+	var am pb.Order
+	if ro := l.q.Cancel(l.orders[i]); ro != nil {
+		ro.CopyTo(&am)
+		ro.CopyFrom(&am)
+		l.push(ro)
+	}
+}
+
+func (l *levelsBook) fill(next *pb.Order) {
+	s := l.q.PeekSell()
+	s.ReduceQuantity(1)
+	if s.Quantity() == 0 {
+		l.q.PopSell()
+		next.Price = s.Price()
+		s.CopyFrom(next)
+		l.q.PushSell(s)
+	}
+}
+
+func (l *levelsBook) best() uint64 {
+	return l.q.BuyLevels(1)[0].Quantity
+}
+
+func (l *levelsBook) snapshot(n int) {
+	l.q.BuyLevels(n)
+	l.q.SellLevels(n)
+}
+
+type treeBook struct {
+	q      rbbook.MatchQueues
+	orders []*rbbook.OrderNode
+}
+
+func (t *treeBook) push(o *rbbook.OrderNode) {
+	if o.Kind() == pb.BUY {
+		t.q.PushBuy(o)
+	} else {
+		t.q.PushSell(o)
+	}
+}
+
+func (t *treeBook) rest(order *pb.Order) {
+	o := &rbbook.OrderNode{}
+	o.CopyFrom(order)
+	t.push(o)
+	t.orders = append(t.orders, o)
+}
+
+func (t *treeBook) pushPop(order *pb.Order) {
+	o := &rbbook.OrderNode{}
+	o.CopyFrom(order)
+	t.q.PushBuy(o)
+	t.q.PopBuy()
+}
+
+func (t *treeBook) cancel(i int) {
+	var am pb.Order
+	if ro := t.q.Cancel(t.orders[i]); ro != nil {
+		ro.CopyTo(&am)
+		ro.CopyFrom(&am)
+		t.push(ro)
+	}
+}
+
+func (t *treeBook) fill(next *pb.Order) {
+	s := t.q.PeekSell()
+	s.ReduceQuantity(1)
+	if s.Quantity() == 0 {
+		t.q.PopSell()
+		next.Price = s.Price()
+		s.CopyFrom(next)
+		t.q.PushSell(s)
+	}
+}
+
+func (t *treeBook) best() uint64 {
+	return t.q.BuyLevels(1)[0].Quantity
+}
+
+func (t *treeBook) snapshot(n int) {
+	t.q.BuyLevels(n)
+	t.q.SellLevels(n)
+}
+
+// order is the i-th order of the benchmarks, the trade ids name it to
+// rbbook and the order ids to pqueue.
+func order(i int, kind int32, price uint64) *pb.Order {
+	return &pb.Order{OrderId: uint64(i) + 1, Uuid: 1 + uint32(i)%64, TradeId: uint32(i), StockId: 1, Kind: kind, Quantity: 100, Price: price}
+}
+
+// bench runs fn against both books, each holding resting buys spread over
+// levels prices below 10000 and as many sells above.
+func bench(b *testing.B, fn func(b *testing.B, book orderBook, r *rand.Rand)) {
+	for _, c := range []struct {
+		name string
+		book func() orderBook
+	}{
+		{"book=levels", func() orderBook { return &levelsBook{} }},
+		{"book=rbtree", func() orderBook { return &treeBook{} }},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			book := c.book()
+			for i := 0; i < resting; i++ {
+				if i%2 == 0 {
+					book.rest(order(i, pb.BUY, 10000-uint64(r.Intn(levels))))
+				} else {
+					book.rest(order(i, pb.SELL, 10001+uint64(r.Intn(levels))))
+				}
+			}
+			b.ResetTimer()
+			fn(b, book, r)
+		})
+	}
+}
+
+// BenchmarkPushPop rests an order and takes the best one off again.
+func BenchmarkPushPop(b *testing.B) {
+	bench(b, func(b *testing.B, book orderBook, r *rand.Rand) {
+		for i := 0; i < b.N; i++ {
+			book.pushPop(order(resting+i, pb.BUY, 10000-uint64(r.Intn(levels))))
+		}
+	})
+}
+
+// BenchmarkCancel takes a random order out of the book and rests it again.
+func BenchmarkCancel(b *testing.B) {
+	bench(b, func(b *testing.B, book orderBook, r *rand.Rand) {
+		for i := 0; i < b.N; i++ {
+			book.cancel(r.Intn(resting))
+		}
+	})
+}
+
+// BenchmarkFill partially fills the best sell the way an aggressor would.
+func BenchmarkFill(b *testing.B) {
+	bench(b, func(b *testing.B, book orderBook, r *rand.Rand) {
+		for i := 0; i < b.N; i++ {
+			book.fill(order(resting+i, pb.SELL, 0))
+		}
+	})
+}
+
+// BenchmarkBestLevel reads the total quantity at the best bid.
+func BenchmarkBestLevel(b *testing.B) {
+	bench(b, func(b *testing.B, book orderBook, r *rand.Rand) {
+		for i := 0; i < b.N; i++ {
+			if book.best() == 0 {
+				b.Fatal("empty best level")
+			}
+		}
+	})
+}
+
+// BenchmarkSnapshot aggregates the top levels of both sides.
+func BenchmarkSnapshot(b *testing.B) {
+	bench(b, func(b *testing.B, book orderBook, r *rand.Rand) {
+		for i := 0; i < b.N; i++ {
+			book.snapshot(depth)
+		}
+	})
+}
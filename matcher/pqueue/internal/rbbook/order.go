@@ -0,0 +1,102 @@
+package rbbook
+
+import (
+	"main/proto"
+	"time"
+)
+
+type OrderNode struct {
+	priceNode node
+	guidNode  node
+	quantity  uint64
+	stockId   uint64
+	kind      int32
+	entered   int64
+	tif       int32
+	expire    uint32
+	nextFree  *OrderNode
+}
+
+func (o *OrderNode) CopyFrom(from *proto.Order) {
+	o.quantity = from.GetQuantity()
+	o.stockId = from.StockId
+	o.kind = from.GetKind()
+	o.entered = time.Now().UnixNano()
+	o.tif = from.GetTimeInForce()
+	o.expire = from.GetExpireDate()
+	o.setup(from.Price, guidOf(from.GetUuid(), from.GetTradeId()))
+}
+
+func (o *OrderNode) CopyTo(to *proto.Order) {
+	to.Kind = o.Kind()
+	to.Price = o.Price()
+	to.Quantity = o.Quantity()
+	to.Uuid = o.Uuid()
+	to.TradeId = o.TradeId()
+	to.StockId = o.StockId()
+	to.TimeInForce = o.TimeInForce()
+	to.ExpireDate = o.ExpireDate()
+}
+
+func (o *OrderNode) setup(price, guid uint64) {
+	initNode(o, price, &o.priceNode, &o.guidNode)
+	initNode(o, guid, &o.guidNode, &o.priceNode)
+}
+
+func (o *OrderNode) Price() uint64 {
+	return o.priceNode.val
+}
+
+func (o *OrderNode) Guid() uint64 {
+	return o.guidNode.val
+}
+
+func (o *OrderNode) Uuid() uint32 {
+	return uint32(o.guidNode.val >> 32)
+}
+
+func (o *OrderNode) TradeId() uint32 {
+	return uint32(o.guidNode.val)
+}
+
+func (o *OrderNode) Quantity() uint64 {
+	return o.quantity
+}
+
+func (o *OrderNode) ReduceQuantity(s uint64) {
+	o.quantity -= s
+}
+
+func (o *OrderNode) StockId() uint64 {
+	return o.stockId
+}
+
+func (o *OrderNode) Kind() int32 {
+	return o.kind
+}
+
+// Entered is when the order reached the matcher in unix nanoseconds, an
+// amended order counts from its amendment.
+func (o *OrderNode) Entered() int64 {
+	return o.entered
+}
+
+func (o *OrderNode) TimeInForce() int32 {
+	return o.tif
+}
+
+// ExpireDate is the YYYYMMDD a good till date order expires at the close of.
+func (o *OrderNode) ExpireDate() uint32 {
+	return o.expire
+}
+
+func (o *OrderNode) Remove() {
+	o.priceNode.pop()
+	o.guidNode.pop()
+}
+
+// guidOf leads with the trader id, so the orders of a trader are one run of
+// the guid tree.
+func guidOf(traderId uint32, tradeId uint32) uint64 {
+	return uint64(traderId)<<32 | uint64(tradeId)
+}
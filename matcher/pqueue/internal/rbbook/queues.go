@@ -0,0 +1,115 @@
+// Package rbbook is the order book pqueue kept before its price levels:
+// each side a red-black tree of orders queued by price, with no totals
+// kept, and a second tree finding an order by guid. Only the pqueue
+// benchmarks use it, as the baseline the levels are measured against.
+package rbbook
+
+type MatchQueues struct {
+	buyTree  rbtree
+	sellTree rbtree
+	orders   rbtree
+	size     int
+}
+
+func (m *MatchQueues) Size() int {
+	return m.size
+}
+
+func (m *MatchQueues) PushBuy(b *OrderNode) {
+	m.size++
+	m.buyTree.push(&b.priceNode)
+	m.orders.push(&b.guidNode)
+}
+
+func (m *MatchQueues) PushSell(s *OrderNode) {
+	m.size++
+	m.sellTree.push(&s.priceNode)
+	m.orders.push(&s.guidNode)
+}
+
+func (m *MatchQueues) PeekBuy() *OrderNode {
+	return m.buyTree.peekMax().getOrderNode()
+}
+
+func (m *MatchQueues) PeekSell() *OrderNode {
+	return m.sellTree.peekMin().getOrderNode()
+}
+
+func (m *MatchQueues) PopBuy() *OrderNode {
+	m.size--
+	return m.buyTree.popMax().getOrderNode()
+}
+
+func (m *MatchQueues) PopSell() *OrderNode {
+	m.size--
+	return m.sellTree.popMin().getOrderNode()
+}
+
+func (m *MatchQueues) Cancel(o *OrderNode) *OrderNode {
+	po := m.orders.cancel(o.Guid()).getOrderNode()
+	if po != nil {
+		m.size--
+	}
+	return po
+}
+
+// BestBuys returns the buy orders resting at the best price in time priority.
+func (m *MatchQueues) BestBuys() []*OrderNode {
+	if h := m.buyTree.peekMax(); h != nil {
+		return h.appendQueue(nil)
+	}
+	return nil
+}
+
+// BestSells returns the sell orders resting at the best price in time priority.
+func (m *MatchQueues) BestSells() []*OrderNode {
+	if h := m.sellTree.peekMin(); h != nil {
+		return h.appendQueue(nil)
+	}
+	return nil
+}
+
+// Level is the aggregate of the orders resting at one price.
+type Level struct {
+	Price    uint64
+	Quantity uint64
+	Orders   int
+}
+
+// BuyLevels returns up to n buy price levels, best first.
+func (m *MatchQueues) BuyLevels(n int) []Level {
+	var levels []Level
+	for h := m.buyTree.peekMax(); h != nil && len(levels) < n; h = h.predecessor() {
+		levels = append(levels, h.level())
+	}
+	return levels
+}
+
+// SellLevels returns up to n sell price levels, best first.
+func (m *MatchQueues) SellLevels(n int) []Level {
+	var levels []Level
+	for h := m.sellTree.peekMin(); h != nil && len(levels) < n; h = h.successor() {
+		levels = append(levels, h.level())
+	}
+	return levels
+}
+
+// Orders returns every resting order in guid order.
+func (m *MatchQueues) Orders() []*OrderNode {
+	orders := make([]*OrderNode, 0, m.size)
+	for h := m.orders.peekMin(); h != nil; h = h.successor() {
+		orders = h.appendQueue(orders)
+	}
+	return orders
+}
+
+// TraderOrders returns the resting orders of a trader in trade id order.
+// A guid leads with the trader id, so they are one run of the orders tree.
+func (m *MatchQueues) TraderOrders(traderId uint32) []*OrderNode {
+	var orders []*OrderNode
+	low := guidOf(traderId, 0)
+	for h := m.orders.ceiling(low); h != nil && h.order.Uuid() == traderId; h = h.successor() {
+		orders = h.appendQueue(orders)
+	}
+	return orders
+}
@@ -0,0 +1,71 @@
+package pqueue
+
+// Iterator walks the orders resting in a book without changing it:
+//
+//	for it := q.Buys(); it.Next(); {
+//		o := it.Order()
+//	}
+//
+// The current order may be taken out of the book during the walk, as a
+// cancel or a fill does, any other change to the book ends the walk
+// undefined.
+type Iterator struct {
+	order *OrderNode
+	next  *OrderNode
+	// Set when walking by order id, the orders left to walk
+	orders []*OrderNode
+	byId   bool
+}
+
+// Buys walks the buy orders from the best price to the worst, in time
+// priority within a price.
+func (m *MatchQueues) Buys() *Iterator {
+	return m.buys.iterator()
+}
+
+// Sells walks the sell orders from the best price to the worst, in time
+// priority within a price.
+func (m *MatchQueues) Sells() *Iterator {
+	return m.sells.iterator()
+}
+
+// ByOrderId walks every resting order in order id order, the order the
+// matcher took them in. It walks a sorted copy of the book made up front,
+// as Orders does.
+func (m *MatchQueues) ByOrderId() *Iterator {
+	return &Iterator{orders: m.Orders(), byId: true}
+}
+
+func (s *side) iterator() *Iterator {
+	return &Iterator{next: s.peek()}
+}
+
+// Next moves to the next order, it reports false when there are no more.
+func (it *Iterator) Next() bool {
+	if it.byId {
+		if len(it.orders) == 0 {
+			it.order = nil
+			return false
+		}
+		it.order = it.orders[0]
+		it.orders = it.orders[1:]
+		return true
+	}
+
+	it.order = it.next
+	if it.order == nil {
+		return false
+	}
+	it.next = it.order.next
+	if it.next == nil {
+		if l := it.order.level.worse(); l != nil {
+			it.next = l.head
+		}
+	}
+	return true
+}
+
+// Order returns the order Next moved to.
+func (it *Iterator) Order() *OrderNode {
+	return it.order
+}
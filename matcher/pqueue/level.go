@@ -0,0 +1,183 @@
+package pqueue
+
+// priceLevel holds the orders resting at one price in time priority, the
+// oldest at head. It keeps their total quantity and count as orders come
+// and go, so aggregating a level never walks it.
+type priceLevel struct {
+	node     node
+	head     *OrderNode
+	tail     *OrderNode
+	quantity uint64
+	orders   int
+	side     *side
+	nextFree *priceLevel
+}
+
+func (l *priceLevel) price() uint64 {
+	return l.node.val
+}
+
+func (l *priceLevel) append(o *OrderNode) {
+	o.level = l
+	o.prev = l.tail
+	o.next = nil
+	if l.tail == nil {
+		l.head = o
+	} else {
+		l.tail.next = o
+	}
+	l.tail = o
+	l.quantity += o.quantity
+	l.orders++
+}
+
+// remove unlinks o from the level, dropping the level from its side once
+// it is empty.
+func (l *priceLevel) remove(o *OrderNode) {
+	if o.prev == nil {
+		l.head = o.next
+	} else {
+		o.prev.next = o.next
+	}
+	if o.next == nil {
+		l.tail = o.prev
+	} else {
+		o.next.prev = o.prev
+	}
+	o.level = nil
+	o.prev = nil
+	o.next = nil
+	l.quantity -= o.quantity
+	l.orders--
+	l.side.removed(o)
+	if l.orders == 0 {
+		l.side.drop(l)
+	}
+}
+
+func (l *priceLevel) aggregate() Level {
+	return Level{Price: l.price(), Quantity: l.quantity, Orders: l.orders}
+}
+
+// worse returns the level next in priority after l on its side.
+func (l *priceLevel) worse() *priceLevel {
+	var n *node
+	if l.side.buy {
+		n = l.node.predecessor()
+	} else {
+		n = l.node.successor()
+	}
+	if n == nil {
+		return nil
+	}
+	return n.level
+}
+
+// side is one side of a book, a tree of its price levels with the best
+// of them at hand.
+type side struct {
+	buy    bool
+	levels rbtree
+	best   *priceLevel
+	queues *MatchQueues
+	free   *priceLevel
+}
+
+// push rests o at the back of the level of its price.
+func (s *side) push(o *OrderNode) {
+	o.use()
+	l := s.level(o.price)
+	l.append(o)
+	s.queues.index[o.orderId] = o
+	s.queues.clients[o.client] = o
+	s.queues.traderOrders(o.traderId).append(o)
+	s.queues.size++
+}
+
+// level returns the level at price, adding an empty one when there is none.
+func (s *side) level(price uint64) *priceLevel {
+	if n := s.levels.get(price); n != nil {
+		return n.level
+	}
+	l := s.free
+	if l == nil {
+		l = &priceLevel{}
+	} else {
+		s.free = l.nextFree
+	}
+	*l = priceLevel{side: s}
+	l.node.val = price
+	l.node.level = l
+	s.levels.push(&l.node)
+	if s.best == nil || s.better(price, s.best.price()) {
+		s.best = l
+	}
+	return l
+}
+
+func (s *side) better(price uint64, than uint64) bool {
+	if s.buy {
+		return price > than
+	}
+	return price < than
+}
+
+func (s *side) removed(o *OrderNode) {
+	if s.queues.index[o.orderId] == o {
+		delete(s.queues.index, o.orderId)
+	}
+	if s.queues.clients[o.client] == o {
+		delete(s.queues.clients, o.client)
+	}
+	if l := s.queues.traders[o.traderId]; l.remove(o) {
+		delete(s.queues.traders, o.traderId)
+	}
+	s.queues.size--
+}
+
+// drop takes the empty level l out of the tree, keeping it for reuse.
+func (s *side) drop(l *priceLevel) {
+	if s.best == l {
+		s.best = l.worse()
+	}
+	l.node.detach()
+	l.side = nil
+	l.nextFree = s.free
+	s.free = l
+}
+
+func (s *side) peek() *OrderNode {
+	if s.best == nil {
+		return nil
+	}
+	return s.best.head
+}
+
+func (s *side) pop() *OrderNode {
+	o := s.peek()
+	if o != nil {
+		o.Remove()
+	}
+	return o
+}
+
+// bestOrders returns the orders at the best level in time priority.
+func (s *side) bestOrders() []*OrderNode {
+	if s.best == nil {
+		return nil
+	}
+	orders := make([]*OrderNode, 0, s.best.orders)
+	for o := s.best.head; o != nil; o = o.next {
+		orders = append(orders, o)
+	}
+	return orders
+}
+
+// aggregate returns up to n levels, best first.
+func (s *side) aggregate(n int) []Level {
+	var levels []Level
+	for l := s.best; l != nil && len(levels) < n; l = l.worse() {
+		levels = append(levels, l.aggregate())
+	}
+	return levels
+}
@@ -1,73 +1,177 @@
 package pqueue
 
 import (
-	"github.com/fmstephe/flib/fmath"
 	"main/proto"
+	"strconv"
+	"time"
 )
 
+// ClientKey names an order the way its trader does, by the client order id
+// it was sent with or, lacking one, by its trade id.
+type ClientKey struct {
+	TraderId uint32
+	Id       string
+}
+
+// ClientKeyOf returns the key of the order a trader sent as clientOrderId,
+// or as tradeId when clientOrderId is empty.
+func ClientKeyOf(traderId uint32, tradeId uint32, clientOrderId string) ClientKey {
+	if clientOrderId == "" {
+		clientOrderId = strconv.FormatUint(uint64(tradeId), 10)
+	}
+	return ClientKey{traderId, clientOrderId}
+}
+
 type OrderNode struct {
-	priceNode node
-	guidNode  node
-	quantity  uint64
-	stockId   uint64
-	kind      int32
-	nextFree  *OrderNode
+	price    uint64
+	orderId  uint64
+	traderId uint32
+	tradeId  uint32
+	clientId string
+	client   ClientKey
+	quantity uint64
+	stockId  uint64
+	kind     int32
+	entered  int64
+	tif      int32
+	expire   uint32
+	// The level the order rests at and its neighbours there, the older
+	// one first
+	level    *priceLevel
+	prev     *OrderNode
+	next     *OrderNode
+	nextFree *OrderNode
+	freed    bool
+
+	// Its neighbours among the resting orders of its trader
+	older *OrderNode
+	newer *OrderNode
 }
 
 func (o *OrderNode) CopyFrom(from *proto.Order) {
+	o.use()
 	o.quantity = from.GetQuantity()
 	o.stockId = from.StockId
 	o.kind = from.GetKind()
-	o.setup(from.Price, uint64(fmath.CombineInt32(int32(from.GetUuid()), int32(from.GetTradeId()))))
+	o.entered = time.Now().UnixNano()
+	o.tif = from.GetTimeInForce()
+	o.expire = from.GetExpireDate()
+	o.traderId = from.GetUuid()
+	o.tradeId = from.GetTradeId()
+	o.clientId = from.GetClientOrderId()
+	o.client = ClientKeyOf(from.GetUuid(), from.GetTradeId(), from.GetClientOrderId())
+	o.setup(from.Price, from.GetOrderId())
 }
 
 func (o *OrderNode) CopyTo(to *proto.Order) {
+	o.use()
 	to.Kind = o.Kind()
 	to.Price = o.Price()
 	to.Quantity = o.Quantity()
 	to.Uuid = o.Uuid()
 	to.TradeId = o.TradeId()
+	to.OrderId = o.OrderId()
+	to.ClientOrderId = o.ClientOrderId()
 	to.StockId = o.StockId()
+	to.TimeInForce = o.TimeInForce()
+	to.ExpireDate = o.ExpireDate()
 }
 
-func (o *OrderNode) setup(price, guid uint64) {
-	initNode(o, price, &o.priceNode, &o.guidNode)
-	initNode(o, guid, &o.guidNode, &o.priceNode)
+func (o *OrderNode) setup(price, orderId uint64) {
+	o.price = price
+	o.orderId = orderId
+	o.level = nil
+	o.prev = nil
+	o.next = nil
+	o.older = nil
+	o.newer = nil
 }
 
 func (o *OrderNode) Price() uint64 {
-	return o.priceNode.val
+	o.use()
+	return o.price
 }
 
-func (o *OrderNode) Guid() uint64 {
-	return o.guidNode.val
+// OrderId is the id the matcher gave the order, unique engine wide.
+func (o *OrderNode) OrderId() uint64 {
+	o.use()
+	return o.orderId
 }
 
 func (o *OrderNode) Uuid() uint32 {
-	return uint32(fmath.HighInt32(int64(o.guidNode.val)))
+	o.use()
+	return o.traderId
 }
 
 func (o *OrderNode) TradeId() uint32 {
-	return uint32(fmath.LowInt32(int64(o.guidNode.val)))
+	o.use()
+	return o.tradeId
+}
+
+// ClientOrderId is the id the trader gave the order, empty when it only
+// numbered it by trade id. Reports echo it.
+func (o *OrderNode) ClientOrderId() string {
+	o.use()
+	return o.clientId
+}
+
+func (o *OrderNode) ClientKey() ClientKey {
+	o.use()
+	return o.client
 }
 
 func (o *OrderNode) Quantity() uint64 {
+	o.use()
 	return o.quantity
 }
 
 func (o *OrderNode) ReduceQuantity(s uint64) {
+	o.use()
 	o.quantity -= s
+	if o.level != nil {
+		o.level.quantity -= s
+	}
 }
 
 func (o *OrderNode) StockId() uint64 {
+	o.use()
 	return o.stockId
 }
 
 func (o *OrderNode) Kind() int32 {
+	o.use()
 	return o.kind
 }
 
+// Entered is when the order reached the matcher in unix nanoseconds, an
+// amended order counts from its amendment.
+func (o *OrderNode) Entered() int64 {
+	o.use()
+	return o.entered
+}
+
+// Enter sets when the order reached the matcher, replicas matching the
+// same input give it the time it was sequenced at rather than their clock.
+func (o *OrderNode) Enter(entered int64) {
+	o.use()
+	o.entered = entered
+}
+
+func (o *OrderNode) TimeInForce() int32 {
+	o.use()
+	return o.tif
+}
+
+// ExpireDate is the YYYYMMDD a good till date order expires at the close of.
+func (o *OrderNode) ExpireDate() uint32 {
+	o.use()
+	return o.expire
+}
+
+// Remove takes the order out of the book it rests in, if any.
 func (o *OrderNode) Remove() {
-	o.priceNode.pop()
-	o.guidNode.pop()
+	o.use()
+	if o.level != nil {
+		o.level.remove(o)
+	}
 }
@@ -1,10 +1,31 @@
 package pqueue
 
+import "sort"
+
+// MatchQueues is the book of one stock. Each side is a tree of price
+// levels holding their orders in time priority, and every resting order is
+// indexed by its order id and by its client key, so peeking the best order
+// or cancelling one costs the same whatever the size of the book. The
+// orders of each trader are listed too, finding them does not walk the
+// book. Orders pushed must have order ids of their own. The zero value is
+// an empty book.
 type MatchQueues struct {
-	buyTree  rbtree
-	sellTree rbtree
-	orders   rbtree
-	size     int
+	buys    side
+	sells   side
+	index   map[uint64]*OrderNode
+	clients map[ClientKey]*OrderNode
+	traders map[uint32]*orderList
+	size    int
+}
+
+func (m *MatchQueues) init() {
+	if m.index == nil {
+		m.index = make(map[uint64]*OrderNode)
+		m.clients = make(map[ClientKey]*OrderNode)
+		m.traders = make(map[uint32]*orderList)
+		m.buys = side{buy: true, queues: m}
+		m.sells = side{queues: m}
+	}
 }
 
 func (m *MatchQueues) Size() int {
@@ -12,39 +33,156 @@ func (m *MatchQueues) Size() int {
 }
 
 func (m *MatchQueues) PushBuy(b *OrderNode) {
-	m.size++
-	m.buyTree.push(&b.priceNode)
-	m.orders.push(&b.guidNode)
+	m.init()
+	m.buys.push(b)
 }
 
 func (m *MatchQueues) PushSell(s *OrderNode) {
-	m.size++
-	m.sellTree.push(&s.priceNode)
-	m.orders.push(&s.guidNode)
+	m.init()
+	m.sells.push(s)
 }
 
 func (m *MatchQueues) PeekBuy() *OrderNode {
-	return m.buyTree.peekMax().getOrderNode()
+	return m.buys.peek()
 }
 
 func (m *MatchQueues) PeekSell() *OrderNode {
-	return m.sellTree.peekMin().getOrderNode()
+	return m.sells.peek()
 }
 
 func (m *MatchQueues) PopBuy() *OrderNode {
-	m.size--
-	return m.buyTree.popMax().getOrderNode()
+	return m.buys.pop()
 }
 
 func (m *MatchQueues) PopSell() *OrderNode {
-	m.size--
-	return m.sellTree.popMin().getOrderNode()
+	return m.sells.pop()
+}
+
+// Find returns the resting order o names, nil when there is none. o names
+// it by order id when it has one, by its client key otherwise, and only the
+// trader of an order may name it.
+func (m *MatchQueues) Find(o *OrderNode) *OrderNode {
+	ro := m.clients[o.ClientKey()]
+	if o.OrderId() != 0 {
+		ro = m.index[o.OrderId()]
+	}
+	if ro == nil || ro.Uuid() != o.Uuid() {
+		return nil
+	}
+	return ro
+}
+
+// Resting returns the order resting under key, nil when there is none.
+func (m *MatchQueues) Resting(key ClientKey) *OrderNode {
+	return m.clients[key]
 }
 
+// Cancel takes the resting order o names out of the book and returns it,
+// nil when there is none.
 func (m *MatchQueues) Cancel(o *OrderNode) *OrderNode {
-	po := m.orders.cancel(o.Guid()).getOrderNode()
-	if po != nil {
-		m.size--
+	ro := m.Find(o)
+	if ro != nil {
+		ro.Remove()
+	}
+	return ro
+}
+
+// BestBuys returns the buy orders resting at the best price in time priority.
+func (m *MatchQueues) BestBuys() []*OrderNode {
+	return m.buys.bestOrders()
+}
+
+// BestSells returns the sell orders resting at the best price in time priority.
+func (m *MatchQueues) BestSells() []*OrderNode {
+	return m.sells.bestOrders()
+}
+
+// Level is the aggregate of the orders resting at one price.
+type Level struct {
+	Price    uint64
+	Quantity uint64
+	Orders   int
+}
+
+// BuyLevels returns up to n buy price levels, best first.
+func (m *MatchQueues) BuyLevels(n int) []Level {
+	return m.buys.aggregate(n)
+}
+
+// SellLevels returns up to n sell price levels, best first.
+func (m *MatchQueues) SellLevels(n int) []Level {
+	return m.sells.aggregate(n)
+}
+
+// Orders returns every resting order in order id order. It copies and
+// sorts the whole book, it is meant for the odd query or sweep rather
+// than for every order.
+func (m *MatchQueues) Orders() []*OrderNode {
+	orders := make([]*OrderNode, 0, m.size)
+	for _, o := range m.index {
+		orders = append(orders, o)
+	}
+	sortByOrderId(orders)
+	return orders
+}
+
+// TraderOrders returns the resting orders of a trader in order id order,
+// sorting only those.
+func (m *MatchQueues) TraderOrders(traderId uint32) []*OrderNode {
+	var orders []*OrderNode
+	if l := m.traders[traderId]; l != nil {
+		for o := l.oldest; o != nil; o = o.newer {
+			orders = append(orders, o)
+		}
+	}
+	sortByOrderId(orders)
+	return orders
+}
+
+func sortByOrderId(orders []*OrderNode) {
+	sort.Slice(orders, func(i, j int) bool { return orders[i].orderId < orders[j].orderId })
+}
+
+// traderOrders returns the list of the orders of traderId, adding an
+// empty one when there is none.
+func (m *MatchQueues) traderOrders(traderId uint32) *orderList {
+	l := m.traders[traderId]
+	if l == nil {
+		l = &orderList{}
+		m.traders[traderId] = l
+	}
+	return l
+}
+
+// orderList links the resting orders of a trader in the order they were
+// pushed, an amended order comes last.
+type orderList struct {
+	oldest *OrderNode
+	newest *OrderNode
+}
+
+func (l *orderList) append(o *OrderNode) {
+	o.older = l.newest
+	if l.newest == nil {
+		l.oldest = o
+	} else {
+		l.newest.newer = o
+	}
+	l.newest = o
+}
+
+// remove unlinks o, it reports whether the list is left empty.
+func (l *orderList) remove(o *OrderNode) bool {
+	if o.older == nil {
+		l.oldest = o.newer
+	} else {
+		o.older.newer = o.newer
+	}
+	if o.newer == nil {
+		l.newest = o.older
+	} else {
+		o.newer.older = o.older
 	}
-	return po
+	o.older, o.newer = nil, nil
+	return l.oldest == nil
 }
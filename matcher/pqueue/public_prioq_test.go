@@ -0,0 +1,184 @@
+package pqueue
+
+import (
+	pb "main/proto"
+	"reflect"
+	"testing"
+)
+
+func testOrder(traderId uint32, orderId uint64, kind int32, price uint64) *OrderNode {
+	o := &OrderNode{}
+	o.CopyFrom(&pb.Order{OrderId: orderId, Uuid: traderId, TradeId: uint32(orderId), StockId: 1, Kind: kind, Quantity: 10, Price: price})
+	return o
+}
+
+func orderIds(orders []*OrderNode) []uint64 {
+	ids := []uint64{}
+	for _, o := range orders {
+		ids = append(ids, o.OrderId())
+	}
+	return ids
+}
+
+func walkByOrderId(q *MatchQueues) []uint64 {
+	ids := []uint64{}
+	for it := q.ByOrderId(); it.Next(); {
+		ids = append(ids, it.Order().OrderId())
+	}
+	return ids
+}
+
+// walk returns the ids of the orders it walks to, at most n of them when
+// n is not negative.
+func walk(it *Iterator, n int) []uint64 {
+	ids := []uint64{}
+	for n != 0 && it.Next() {
+		ids = append(ids, it.Order().OrderId())
+		n--
+	}
+	return ids
+}
+
+// testBook rests buys 1-4 and sells 5-8, several to a price.
+func testBook() (*MatchQueues, map[uint64]*OrderNode) {
+	q := &MatchQueues{}
+	orders := map[uint64]*OrderNode{}
+	for _, o := range []*OrderNode{
+		testOrder(1, 1, pb.BUY, 100),
+		testOrder(2, 2, pb.BUY, 101),
+		testOrder(1, 3, pb.BUY, 100),
+		testOrder(2, 4, pb.BUY, 99),
+		testOrder(1, 5, pb.SELL, 110),
+		testOrder(2, 6, pb.SELL, 109),
+		testOrder(1, 7, pb.SELL, 111),
+		testOrder(2, 8, pb.SELL, 109),
+	} {
+		orders[o.OrderId()] = o
+		if o.Kind() == pb.BUY {
+			q.PushBuy(o)
+		} else {
+			q.PushSell(o)
+		}
+	}
+	return q, orders
+}
+
+func TestWalkInPriceTimeOrder(t *testing.T) {
+	q, orders := testBook()
+	if got, want := walk(q.Buys(), -1), []uint64{2, 1, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walked buys %v, want %v", got, want)
+	}
+	if got, want := walk(q.Sells(), -1), []uint64{6, 8, 5, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walked sells %v, want %v", got, want)
+	}
+
+	// The order walked to may be taken out, as a fill does
+	for it := q.Buys(); it.Next(); {
+		if it.Order().Price() == 100 {
+			it.Order().Remove()
+		}
+	}
+	orders[6].Remove()
+	if got, want := walk(q.Buys(), -1), []uint64{2, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walked buys %v, want %v", got, want)
+	}
+	if got, want := walk(q.Sells(), -1), []uint64{8, 5, 7}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walked sells %v, want %v", got, want)
+	}
+}
+
+func TestWalkStopsEarly(t *testing.T) {
+	q, _ := testBook()
+	for _, tc := range []struct {
+		it   *Iterator
+		n    int
+		want []uint64
+	}{
+		{q.Buys(), 1, []uint64{2}},
+		{q.Buys(), 2, []uint64{2, 1}},
+		{q.Sells(), 3, []uint64{6, 8, 5}},
+	} {
+		if got := walk(tc.it, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("walked %v, want %v", got, tc.want)
+		}
+	}
+	// Walks left off do not change the book
+	if got, want := walk(q.Buys(), -1), []uint64{2, 1, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("walked buys %v, want %v", got, want)
+	}
+	if n := len(q.Orders()); n != 8 {
+		t.Fatalf("%d orders left, want 8", n)
+	}
+}
+
+func TestWalkEmptySide(t *testing.T) {
+	q := &MatchQueues{}
+	if len(walk(q.Buys(), -1)) != 0 || len(walk(q.Sells(), -1)) != 0 {
+		t.Fatal("walked orders of an empty book")
+	}
+
+	q.PushBuy(testOrder(1, 1, pb.BUY, 100))
+	if got := walk(q.Sells(), -1); len(got) != 0 {
+		t.Fatalf("walked sells %v of a book holding only a buy", got)
+	}
+	it := q.Buys()
+	if !it.Next() || it.Next() || it.Order() != nil {
+		t.Fatal("walk of one buy did not end after it")
+	}
+
+	q.PopBuy()
+	if got := walk(q.Buys(), -1); len(got) != 0 {
+		t.Fatalf("walked buys %v of a side emptied", got)
+	}
+}
+
+func TestOrdersInOrderIdOrder(t *testing.T) {
+	q := &MatchQueues{}
+	// Pushed out of order as amended orders come back
+	orders := map[uint64]*OrderNode{}
+	for _, o := range []*OrderNode{
+		testOrder(1, 3, pb.BUY, 100),
+		testOrder(2, 4, pb.SELL, 110),
+		testOrder(1, 1, pb.SELL, 120),
+		testOrder(2, 5, pb.BUY, 90),
+		testOrder(1, 2, pb.BUY, 100),
+	} {
+		orders[o.OrderId()] = o
+		if o.Kind() == pb.BUY {
+			q.PushBuy(o)
+		} else {
+			q.PushSell(o)
+		}
+	}
+	check := func(all []uint64, trader1 []uint64, trader2 []uint64) {
+		t.Helper()
+		if got := orderIds(q.Orders()); !reflect.DeepEqual(got, all) {
+			t.Fatalf("orders %v, want %v", got, all)
+		}
+		if got := walkByOrderId(q); !reflect.DeepEqual(got, all) {
+			t.Fatalf("walked %v, want %v", got, all)
+		}
+		if got := orderIds(q.TraderOrders(1)); !reflect.DeepEqual(got, trader1) {
+			t.Fatalf("orders of trader 1 %v, want %v", got, trader1)
+		}
+		if got := orderIds(q.TraderOrders(2)); !reflect.DeepEqual(got, trader2) {
+			t.Fatalf("orders of trader 2 %v, want %v", got, trader2)
+		}
+	}
+	check([]uint64{1, 2, 3, 4, 5}, []uint64{1, 2, 3}, []uint64{4, 5})
+
+	orders[2].Remove()
+	q.PopSell()
+	check([]uint64{1, 3, 5}, []uint64{1, 3}, []uint64{5})
+
+	// The order walked to may be taken out
+	for it := q.ByOrderId(); it.Next(); {
+		if it.Order().Uuid() == 2 {
+			it.Order().Remove()
+		}
+	}
+	check([]uint64{1, 3}, []uint64{1, 3}, []uint64{})
+	if _, found := q.traders[2]; found {
+		t.Fatal("trader 2 without orders is still listed")
+	}
+}
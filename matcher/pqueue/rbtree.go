@@ -35,16 +35,6 @@ func (b *rbtree) peekMin() *node {
 	return n
 }
 
-func (b *rbtree) popMin() *node {
-	if b.root != nil {
-		n := b.peekMin()
-		n.pop()
-		n.other.pop() // Clear complementary rbtree
-		return n
-	}
-	return nil
-}
-
 func (b *rbtree) peekMax() *node {
 	n := b.root
 	if n == nil {
@@ -56,28 +46,34 @@ func (b *rbtree) peekMax() *node {
 	return n
 }
 
-func (b *rbtree) popMax() *node {
-	if b.root != nil {
-		n := b.peekMax()
-		n.pop()
-		n.other.pop() // Clear complementary rbtree
+// successor returns the tree node holding the next larger value.
+func (n *node) successor() *node {
+	if n.right != nil {
+		n = n.right
+		for n.left != nil {
+			n = n.left
+		}
 		return n
 	}
-	return nil
-}
-
-func (b *rbtree) cancel(val uint64) *node {
-	n := b.get(val)
-	if n == nil {
-		return nil
+	for n.parent != nil && n.parent.right == n {
+		n = n.parent
 	}
-	n.pop()
-	n.other.pop()
-	return n
+	return n.parent
 }
 
-func (b *rbtree) Has(val uint64) bool {
-	return b.get(val) != nil
+// predecessor returns the tree node holding the next smaller value.
+func (n *node) predecessor() *node {
+	if n.left != nil {
+		n = n.left
+		for n.right != nil {
+			n = n.right
+		}
+		return n
+	}
+	for n.parent != nil && n.parent.left == n {
+		n = n.parent
+	}
+	return n.parent
 }
 
 func (b *rbtree) get(val uint64) *node {
@@ -95,7 +91,6 @@ func (b *rbtree) get(val uint64) *node {
 			n = n.right
 		}
 	}
-	panic("Unreachable")
 }
 
 type node struct {
@@ -106,13 +101,8 @@ type node struct {
 	right  *node
 	parent *node
 	pp     **node
-	// Limit queue fields
-	next *node
-	prev *node
-	// OrderNode
-	order *OrderNode
-	// This is the other node tying order to another rbtree
-	other *node
+	// The price level keyed by val
+	level *priceLevel
 }
 
 func (n *node) String() string {
@@ -138,20 +128,6 @@ func (n *node) String() string {
 	return b.String()
 }
 
-func initNode(o *OrderNode, val uint64, n, other *node) {
-	*n = node{val: val, order: o, other: other}
-	n.next = n
-	n.prev = n
-	n.black = false
-}
-
-func (n *node) getOrderNode() *OrderNode {
-	if n != nil {
-		return n.order
-	}
-	return nil
-}
-
 func (n *node) isRed() bool {
 	if n != nil {
 		return !n.black
@@ -159,26 +135,6 @@ func (n *node) isRed() bool {
 	return false
 }
 
-func (n *node) isFree() bool {
-	switch {
-	case n.left != nil:
-		return false
-	case n.right != nil:
-		return false
-	case n.pp != nil:
-		return false
-	case n.next != n:
-		return false
-	case n.prev != n:
-		return false
-	}
-	return true
-}
-
-func (n *node) isHead() bool {
-	return n.pp != nil
-}
-
 func (n *node) getSibling() *node {
 	p := n.parent
 	if p == nil {
@@ -190,14 +146,6 @@ func (n *node) getSibling() *node {
 	return p.left
 }
 
-func (n *node) addLast(in *node) {
-	last := n.next
-	last.prev = in
-	in.next = last
-	in.prev = n
-	n.next = in
-}
-
 func (n *node) giveParent(nn *node) {
 	nn.parent = n.parent
 	nn.pp = n.pp
@@ -232,8 +180,7 @@ func (n *node) push(in *node) {
 	for {
 		switch {
 		case in.val == n.val:
-			n.addLast(in)
-			return
+			panic(fmt.Sprintf("pqueue: a second node for %d", in.val))
 		case in.val < n.val:
 			if n.left == nil {
 				in.toLeftOf(n)
@@ -370,29 +317,6 @@ func repairInsert(n *node) {
 	}
 }
 
-func (n *node) pop() {
-	switch {
-	case !n.isHead():
-		n.prev.next = n.next
-		n.next.prev = n.prev
-		n.parent = nil
-		n.pp = nil
-		n.left = nil
-		n.right = nil
-	case n.next != n:
-		n.prev.next = n.next
-		n.next.prev = n.prev
-		nn := n.prev
-		n.givePosition(nn)
-	default:
-		n.detach()
-	}
-	n.next = n
-	n.prev = n
-	// Guarantee: Each of n.parent/pp/left/right are now nil
-	// Guarantee: Both n.left/right point to n
-}
-
 func (n *node) detachMax() *node {
 	m := n
 	for {
@@ -1,36 +1,104 @@
 package pqueue
 
+import "fmt"
+
+// SlabStats counts the order nodes of a slab.
+type SlabStats struct {
+	// Allocated is every node the slab took from the heap, live or free.
+	// The slab never gives nodes back, so it is its own high-water mark.
+	Allocated int
+	Live      int
+	Free      int
+	// HighWater is the most nodes live at once.
+	HighWater int
+	// Chunks is how many times the slab allocated, the first time included.
+	Chunks int
+}
+
+// Slab hands out order nodes from chunks allocated up front, allocating
+// another chunk whenever every node is in use. Freed nodes are reused
+// before the slab grows.
+//
+// Built with the slabdebug tag it panics on freeing a node twice, freeing
+// a node still resting in a book and touching a freed node.
 type Slab struct {
-	free   *OrderNode
-	orders []OrderNode
+	free  *OrderNode
+	chunk int
+	stats SlabStats
 }
 
-func NewSlab(size int) *Slab {
-	s := &Slab{orders: make([]OrderNode, size)}
-	s.free = &s.orders[0]
-	prev := s.free
-	for i := 1; i < len(s.orders); i++ {
-		curr := &s.orders[i]
-		prev.nextFree = curr
-		prev = curr
+// NewSlab returns a slab of size nodes growing chunk nodes at a time, a
+// chunk below one grows by size.
+func NewSlab(size int, chunk int) *Slab {
+	if size < 1 {
+		size = 1
+	}
+	if chunk < 1 {
+		chunk = size
 	}
+	s := &Slab{chunk: chunk}
+	s.grow(size)
 	return s
 }
 
+func (s *Slab) grow(n int) {
+	orders := make([]OrderNode, n)
+	for i := range orders {
+		orders[i].freed = true
+		if i+1 < n {
+			orders[i].nextFree = &orders[i+1]
+		}
+	}
+	orders[n-1].nextFree = s.free
+	s.free = &orders[0]
+	s.stats.Allocated += n
+	s.stats.Free += n
+	s.stats.Chunks++
+}
+
 func (s *Slab) Malloc() *OrderNode {
-	o := s.free
-	if o == nil {
-		o = &OrderNode{}
+	if s.free == nil {
+		s.grow(s.chunk)
 	}
+	o := s.free
 	s.free = o.nextFree
 	o.nextFree = o // Slab allocated order marker
+	o.freed = false
+	s.stats.Free--
+	s.stats.Live++
+	if s.stats.Live > s.stats.HighWater {
+		s.stats.HighWater = s.stats.Live
+	}
 	return o
 }
 
 func (s *Slab) Free(o *OrderNode) {
+	if slabDebug {
+		switch {
+		case o.freed:
+			panic(fmt.Sprintf("pqueue: order node %d freed twice", o.orderId))
+		case o.level != nil:
+			panic(fmt.Sprintf("pqueue: order node %d freed while resting in a book", o.orderId))
+		}
+	}
 	if o.nextFree == o {
 		o.nextFree = s.free
+		o.freed = true
 		s.free = o
+		s.stats.Free++
+		s.stats.Live--
 	}
 	// OrderNodes that were not slab allocated are left to the garbage collector
 }
+
+// Stats returns the counts of the slab.
+func (s *Slab) Stats() SlabStats {
+	return s.stats
+}
+
+// use panics in a debug build when o has been freed.
+func (o *OrderNode) use() {
+	if slabDebug && o.freed {
+		panic(fmt.Sprintf("pqueue: order node %d used after it was freed", o.orderId))
+	}
+}
@@ -0,0 +1,6 @@
+//go:build slabdebug
+
+package pqueue
+
+// slabDebug checks the use of slab nodes, build with -tags slabdebug.
+const slabDebug = true
@@ -0,0 +1,661 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxEntries is how many entries one AppendEntries carries at most.
+const maxEntries = 512
+
+// tick is how often a node checks its election and heartbeat timers.
+const tick = 10 * time.Millisecond
+
+const (
+	follower = iota
+	candidate
+	leader
+)
+
+// Entry is one command of the log, Index counts from 1.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+type VoteArgs struct {
+	Term         uint64
+	CandidateId  uint64
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+type VoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendArgs struct {
+	Term         uint64
+	LeaderId     uint64
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendReply tells a leader whether the entries fit, when they do not
+// ConflictIndex is where the leader should try again from.
+type AppendReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// Transport carries the messages of a node to the other members.
+type Transport interface {
+	RequestVote(to uint64, args *VoteArgs) (*VoteReply, error)
+	AppendEntries(to uint64, args *AppendArgs) (*AppendReply, error)
+}
+
+// Config describes a node and the cluster it belongs to.
+type Config struct {
+	// Id names the node, it must not be zero.
+	Id uint64
+	// Peers are the ids of every member, Id included.
+	Peers []uint64
+	// ElectionTimeout is how long a follower waits for a leader before it
+	// campaigns, randomized up to twice as long. Zero means 300ms.
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often a leader reaches out when it has
+	// nothing to send. Zero means 50ms.
+	HeartbeatInterval time.Duration
+	// Storage keeps the term, vote and log across restarts, nil keeps them
+	// in memory only. A node must not restart without them, it could vote
+	// twice in a term and forget entries it helped commit.
+	Storage Storage
+	// Applied is the last index the commands were applied up to before a
+	// restart, Committed hands out the ones after it. NewNode fails when
+	// the log Storage keeps ends short of it.
+	Applied uint64
+}
+
+// Storage keeps what a node must not forget when it restarts, every call
+// returns once the change is durable.
+type Storage interface {
+	// Load returns what was saved, nothing at first.
+	Load() (term uint64, votedFor uint64, entries []Entry, err error)
+	SaveState(term uint64, votedFor uint64) error
+	// SaveEntries stores entries following on each other, dropping any
+	// stored at their indexes and past them.
+	SaveEntries(entries []Entry) error
+}
+
+// Node is one member of a raft cluster replicating a log of commands.
+// Commands are only handed out by Committed once a majority holds them,
+// every member hands out the same commands in the same order. The term,
+// vote and log are saved to Config.Storage before the node acts on them or
+// answers, a member that restarts with them catches up from the leader.
+type Node struct {
+	mu        sync.Mutex
+	cfg       Config
+	transport Transport
+	storage   Storage
+
+	state    int
+	term     uint64
+	votedFor uint64
+	leader   uint64
+	// log[0] is a sentinel so log[i].Index == i
+	log         []Entry
+	commitIndex uint64
+	lastApplied uint64
+	nextIndex   map[uint64]uint64
+	matchIndex  map[uint64]uint64
+	wake        map[uint64]chan struct{}
+
+	heard   time.Time
+	timeout time.Duration
+
+	// proposed holds the commands of this node not applied yet by index,
+	// dropped the ones a later leader overwrote
+	proposed map[uint64]Entry
+	dropped  []Entry
+
+	committed chan Entry
+	lost      chan Entry
+	notify    chan struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewNode(cfg Config, transport Transport) (*Node, error) {
+	if cfg.Id == 0 {
+		return nil, errors.New("raft node id must not be zero")
+	}
+	member := false
+	for _, id := range cfg.Peers {
+		if id == 0 {
+			return nil, errors.New("raft peer id must not be zero")
+		}
+		member = member || id == cfg.Id
+	}
+	if !member {
+		return nil, errors.New("raft peers must include the node itself")
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = 300 * time.Millisecond
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = 50 * time.Millisecond
+	}
+	n := &Node{
+		cfg:       cfg,
+		transport: transport,
+		storage:   cfg.Storage,
+		log:       []Entry{{}},
+		proposed:  make(map[uint64]Entry),
+		committed: make(chan Entry, maxEntries),
+		lost:      make(chan Entry, maxEntries),
+		notify:    make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+	}
+	if n.storage != nil {
+		term, votedFor, entries, err := n.storage.Load()
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range entries {
+			if e.Index != uint64(i)+1 {
+				return nil, fmt.Errorf("raft storage holds entry %d where %d belongs", e.Index, i+1)
+			}
+		}
+		n.term, n.votedFor = term, votedFor
+		n.log = append(n.log, entries...)
+	}
+	// What was applied was committed, a log short of it lost entries the
+	// node cannot take back from its peers without applying them twice
+	if cfg.Applied > n.lastIndex() {
+		return nil, fmt.Errorf("raft log ends at %d, short of the %d applied", n.lastIndex(), cfg.Applied)
+	}
+	n.commitIndex, n.lastApplied = cfg.Applied, cfg.Applied
+	return n, nil
+}
+
+// Start runs the timers of the node, it starts out as a follower.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetTimer()
+	n.mu.Unlock()
+	go n.run()
+	go n.apply()
+}
+
+// Stop halts the node, it no longer campaigns, replicates or applies.
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() { close(n.stop) })
+}
+
+func (n *Node) stopped() bool {
+	select {
+	case <-n.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Id returns the id of the node.
+func (n *Node) Id() uint64 {
+	return n.cfg.Id
+}
+
+// Committed delivers the commands a majority holds, in log order.
+func (n *Node) Committed() <-chan Entry {
+	return n.committed
+}
+
+// Lost delivers the commands this node proposed that a later leader
+// overwrote, they will never be committed. It must be read like Committed.
+func (n *Node) Lost() <-chan Entry {
+	return n.lost
+}
+
+// Leader returns the member the node last heard leading, zero when unknown.
+func (n *Node) Leader() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// IsLeader reports whether the node leads the cluster at the moment.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == leader
+}
+
+// Propose appends a command to the log of the leader, it reports false
+// on any other node. A command is not committed until Committed delivers
+// it, one proposed by a leader that loses its post may never be and
+// comes out of Lost instead.
+func (n *Node) Propose(data []byte) (uint64, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != leader {
+		return 0, false
+	}
+	index := n.lastIndex() + 1
+	e := Entry{Index: index, Term: n.term, Data: data}
+	if !n.saveEntries([]Entry{e}) {
+		return 0, false
+	}
+	n.log = append(n.log, e)
+	n.proposed[index] = e
+	n.matchIndex[n.cfg.Id] = index
+	n.advanceCommit()
+	for _, wake := range n.wake {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	return index, true
+}
+
+// saveState makes the term and vote durable, the caller must hold n.mu.
+// A node that cannot save them stops, it would not know them after a restart.
+func (n *Node) saveState() bool {
+	if n.storage == nil {
+		return true
+	}
+	if err := n.storage.SaveState(n.term, n.votedFor); err != nil {
+		n.fail(err)
+		return false
+	}
+	return true
+}
+
+// saveEntries makes entries durable, the caller must hold n.mu.
+func (n *Node) saveEntries(entries []Entry) bool {
+	if n.storage == nil || len(entries) == 0 {
+		return true
+	}
+	if err := n.storage.SaveEntries(entries); err != nil {
+		n.fail(err)
+		return false
+	}
+	return true
+}
+
+func (n *Node) fail(err error) {
+	log.Println("raft: stopping node", n.cfg.Id, "that cannot save its state:", err)
+	n.state = follower
+	n.Stop()
+}
+
+func (n *Node) lastIndex() uint64 {
+	return uint64(len(n.log)) - 1
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// resetTimer restarts the election timeout, the caller must hold n.mu.
+func (n *Node) resetTimer() {
+	n.heard = time.Now()
+	n.timeout = n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+}
+
+func (n *Node) run() {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			n.mu.Lock()
+			if n.state != leader && time.Since(n.heard) >= n.timeout {
+				n.campaign()
+			}
+			n.mu.Unlock()
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// becomeFollower steps down, moving to term if it is newer. The caller must hold n.mu.
+func (n *Node) becomeFollower(term uint64) {
+	n.state = follower
+	if term > n.term {
+		n.term = term
+		n.votedFor = 0
+		n.leader = 0
+		n.saveState()
+	}
+}
+
+// campaign asks every member for its vote in a new term, the caller must hold n.mu.
+func (n *Node) campaign() {
+	n.state = candidate
+	n.term++
+	n.votedFor = n.cfg.Id
+	n.leader = 0
+	n.resetTimer()
+	if !n.saveState() {
+		return
+	}
+
+	term := n.term
+	args := &VoteArgs{
+		Term:         term,
+		CandidateId:  n.cfg.Id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	votes := 1
+	if votes > len(n.cfg.Peers)/2 {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.Id {
+			continue
+		}
+		go func(peer uint64) {
+			reply, err := n.transport.RequestVote(peer, args)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.becomeFollower(reply.Term)
+				n.resetTimer()
+				return
+			}
+			if n.state != candidate || n.term != term || !reply.Granted {
+				return
+			}
+			votes++
+			if votes > len(n.cfg.Peers)/2 {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader takes the post won in the current term and starts
+// replicating to every member. An empty entry of the new term commits
+// whatever earlier leaders left behind. The caller must hold n.mu.
+func (n *Node) becomeLeader() {
+	n.state = leader
+	n.leader = n.cfg.Id
+	n.nextIndex = make(map[uint64]uint64)
+	n.matchIndex = make(map[uint64]uint64)
+	n.wake = make(map[uint64]chan struct{})
+	e := Entry{Index: n.lastIndex() + 1, Term: n.term}
+	if !n.saveEntries([]Entry{e}) {
+		return
+	}
+	n.log = append(n.log, e)
+	n.matchIndex[n.cfg.Id] = n.lastIndex()
+	for _, peer := range n.cfg.Peers {
+		if peer == n.cfg.Id {
+			continue
+		}
+		n.nextIndex[peer] = n.lastIndex()
+		n.wake[peer] = make(chan struct{}, 1)
+		go n.replicate(peer, n.term, n.wake[peer])
+	}
+	n.advanceCommit()
+}
+
+// replicate keeps peer in step with the log for as long as the node leads in term.
+func (n *Node) replicate(peer uint64, term uint64, wake chan struct{}) {
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		n.mu.Lock()
+		if n.state != leader || n.term != term {
+			n.mu.Unlock()
+			return
+		}
+		next := n.nextIndex[peer]
+		end := n.lastIndex() + 1
+		if end > next+maxEntries {
+			end = next + maxEntries
+		}
+		args := &AppendArgs{
+			Term:         term,
+			LeaderId:     n.cfg.Id,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  n.log[next-1].Term,
+			Entries:      append([]Entry(nil), n.log[next:end]...),
+			LeaderCommit: n.commitIndex,
+		}
+		n.mu.Unlock()
+
+		more := false
+		if reply, err := n.transport.AppendEntries(peer, args); err == nil {
+			n.mu.Lock()
+			more = n.appended(peer, args, reply)
+			n.mu.Unlock()
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-wake:
+		case <-ticker.C:
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// appended takes in the answer of peer to args, it reports whether there
+// is more to send right away. The caller must hold n.mu.
+func (n *Node) appended(peer uint64, args *AppendArgs, reply *AppendReply) bool {
+	if reply.Term > n.term {
+		n.becomeFollower(reply.Term)
+		n.resetTimer()
+		return false
+	}
+	if n.state != leader || n.term != args.Term {
+		return false
+	}
+	if !reply.Success {
+		next := reply.ConflictIndex
+		if next >= n.nextIndex[peer] {
+			next = n.nextIndex[peer] - 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[peer] = next
+		return true
+	}
+	if match := args.PrevLogIndex + uint64(len(args.Entries)); match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.advanceCommit()
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// advanceCommit commits the newest entry of the current term a majority
+// holds, and with it everything before. The caller must hold n.mu.
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.log[index].Term != n.term {
+			return
+		}
+		count := 0
+		for _, peer := range n.cfg.Peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count > len(n.cfg.Peers)/2 {
+			n.setCommit(index)
+			return
+		}
+	}
+}
+
+// setCommit moves the commit index forward, the caller must hold n.mu.
+func (n *Node) setCommit(index uint64) {
+	if index <= n.commitIndex {
+		return
+	}
+	n.commitIndex = index
+	select {
+	case n.notify <- struct{}{}:
+	default:
+	}
+}
+
+// truncate drops the log from index on, the commands of this node in it
+// are lost. The caller must hold n.mu.
+func (n *Node) truncate(index uint64) {
+	n.log = n.log[:index]
+	first := len(n.dropped)
+	for i, e := range n.proposed {
+		if i >= index {
+			n.dropped = append(n.dropped, e)
+			delete(n.proposed, i)
+		}
+	}
+	lost := n.dropped[first:]
+	sort.Slice(lost, func(i, j int) bool { return lost[i].Index < lost[j].Index })
+	if len(lost) > 0 {
+		select {
+		case n.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// apply hands out committed entries, the empty ones leaders start with
+// excepted, and the lost commands of the node.
+func (n *Node) apply() {
+	for {
+		select {
+		case <-n.notify:
+		case <-n.stop:
+			return
+		}
+		for {
+			n.mu.Lock()
+			if len(n.dropped) > 0 {
+				e := n.dropped[0]
+				n.dropped = n.dropped[1:]
+				n.mu.Unlock()
+				select {
+				case n.lost <- e:
+				case <-n.stop:
+					return
+				}
+				continue
+			}
+			if n.lastApplied >= n.commitIndex {
+				n.mu.Unlock()
+				break
+			}
+			n.lastApplied++
+			e := n.log[n.lastApplied]
+			delete(n.proposed, n.lastApplied)
+			n.mu.Unlock()
+			if len(e.Data) == 0 {
+				continue
+			}
+			select {
+			case n.committed <- e:
+			case <-n.stop:
+				return
+			}
+		}
+	}
+}
+
+// HandleRequestVote answers a candidate, a vote goes to the first
+// candidate of a term whose log is at least as complete.
+func (n *Node) HandleRequestVote(args *VoteArgs) *VoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.stopped() {
+		return &VoteReply{Term: n.term}
+	}
+	if args.Term > n.term {
+		n.becomeFollower(args.Term)
+	}
+	reply := &VoteReply{Term: n.term}
+	if args.Term < n.term {
+		return reply
+	}
+	upToDate := args.LastLogTerm > n.lastTerm() ||
+		(args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == 0 || n.votedFor == args.CandidateId) && upToDate {
+		n.votedFor = args.CandidateId
+		n.resetTimer()
+		reply.Granted = n.saveState()
+	}
+	return reply
+}
+
+// HandleAppendEntries takes entries from a leader, dropping whatever of
+// its own log disagrees with them.
+func (n *Node) HandleAppendEntries(args *AppendArgs) *AppendReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if args.Term < n.term || n.stopped() {
+		return &AppendReply{Term: n.term}
+	}
+	n.becomeFollower(args.Term)
+	n.leader = args.LeaderId
+	n.resetTimer()
+
+	reply := &AppendReply{Term: n.term}
+	if args.PrevLogIndex > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return reply
+	}
+	if term := n.log[args.PrevLogIndex].Term; term != args.PrevLogTerm {
+		// Skip the whole conflicting term at once
+		index := args.PrevLogIndex
+		for index > 1 && n.log[index-1].Term == term {
+			index--
+		}
+		reply.ConflictIndex = index
+		return reply
+	}
+
+	for i, e := range args.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.log[e.Index].Term == e.Term {
+				continue
+			}
+			n.truncate(e.Index)
+		}
+		if !n.saveEntries(args.Entries[i:]) {
+			return reply
+		}
+		n.log = append(n.log, args.Entries[i:]...)
+		break
+	}
+	last := args.PrevLogIndex + uint64(len(args.Entries))
+	if args.LeaderCommit < last {
+		last = args.LeaderCommit
+	}
+	n.setCommit(last)
+	reply.Success = true
+	return reply
+}
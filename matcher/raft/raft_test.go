@@ -0,0 +1,292 @@
+package raft
+
+import (
+	"fmt"
+	"main/matcher/journal"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds how long a test waits for the cluster to settle.
+const testTimeout = 5 * time.Second
+
+type testCluster struct {
+	t       *testing.T
+	network *LocalNetwork
+	peers   []uint64
+	nodes   map[uint64]*Node
+	dir     string
+}
+
+// newTestCluster starts size nodes on a local network, keeping their
+// state in journals when dir is not empty.
+func newTestCluster(t *testing.T, size int, dir string) *testCluster {
+	c := &testCluster{t: t, network: NewLocalNetwork(), nodes: make(map[uint64]*Node), dir: dir}
+	for id := uint64(1); id <= uint64(size); id++ {
+		c.peers = append(c.peers, id)
+	}
+	for _, id := range c.peers {
+		c.start(id, 0)
+	}
+	t.Cleanup(func() {
+		for _, n := range c.nodes {
+			n.Stop()
+		}
+	})
+	return c
+}
+
+// start runs node id, anew or over the state it saved before.
+func (c *testCluster) start(id uint64, applied uint64) *Node {
+	cfg := Config{
+		Id:                id,
+		Peers:             c.peers,
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+		Applied:           applied,
+	}
+	if c.dir != "" {
+		j, err := journal.Open(filepath.Join(c.dir, fmt.Sprintf("raft-%d.jnl", id)))
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.t.Cleanup(func() { j.Close() })
+		cfg.Storage = NewJournalStorage(j)
+	}
+	n, err := NewNode(cfg, c.network)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.nodes[id] = n
+	c.network.Add(n)
+	n.Start()
+	return n
+}
+
+// leader waits for a single leader among the nodes not cut off.
+func (c *testCluster) leader() *Node {
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		var leaders []*Node
+		c.network.mu.RLock()
+		for id, n := range c.nodes {
+			if n.IsLeader() && !c.network.down[id] {
+				leaders = append(leaders, n)
+			}
+		}
+		c.network.mu.RUnlock()
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatal("no leader elected")
+	return nil
+}
+
+// propose keeps proposing data until a leader takes it.
+func (c *testCluster) propose(data string) {
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		if _, ok := c.leader().Propose([]byte(data)); ok {
+			return
+		}
+	}
+	c.t.Fatalf("no leader took %q", data)
+}
+
+// commit reads what n commits until it commits data, proposing data again
+// while it does not come, and returns all n committed.
+func (c *testCluster) commit(n *Node, data string) []string {
+	var got []string
+	deadline := time.Now().Add(testTimeout)
+	c.propose(data)
+	for time.Now().Before(deadline) {
+		select {
+		case e := <-n.Committed():
+			got = append(got, string(e.Data))
+			if string(e.Data) == data {
+				return got
+			}
+		case <-time.After(100 * time.Millisecond):
+			// Lost with the leader that took it
+			c.propose(data)
+		}
+	}
+	c.t.Fatalf("node %d did not commit %q", n.Id(), data)
+	return nil
+}
+
+// expect reads want off what n commits, in order, and returns the index
+// of the last.
+func expect(t *testing.T, n *Node, want ...string) (index uint64) {
+	t.Helper()
+	for _, w := range want {
+		select {
+		case e := <-n.Committed():
+			if string(e.Data) != w {
+				t.Fatalf("node %d committed %q, want %q", n.Id(), e.Data, w)
+			}
+			index = e.Index
+		case <-time.After(testTimeout):
+			t.Fatalf("node %d did not commit %q", n.Id(), w)
+		}
+	}
+	return index
+}
+
+func TestRestartKeepsTermVoteAndLog(t *testing.T) {
+	c := newTestCluster(t, 3, t.TempDir())
+	c.propose("a")
+	c.propose("b")
+	follower := c.peers[0]
+	if c.nodes[follower].IsLeader() {
+		follower = c.peers[1]
+	}
+	var applied uint64
+	for id, n := range c.nodes {
+		if id == follower {
+			applied = expect(t, n, "a")
+			expect(t, n, "b")
+		} else {
+			expect(t, n, "a", "b")
+		}
+	}
+	old := c.nodes[follower]
+	old.mu.Lock()
+	term, votedFor := old.term, old.votedFor
+	old.mu.Unlock()
+	old.Stop()
+	c.network.Disconnect(follower)
+
+	// Restarted cut off from the others it knows what it had on its own
+	n := c.start(follower, applied)
+	n.mu.Lock()
+	if n.term < term || (n.term == term && n.votedFor != votedFor) {
+		t.Errorf("restarted in term %d voting %d, was term %d voting %d", n.term, n.votedFor, term, votedFor)
+	}
+	if n.lastIndex() <= applied {
+		t.Errorf("restarted with %d entries, want the committed ones", n.lastIndex())
+	}
+	n.mu.Unlock()
+
+	c.network.Reconnect(follower)
+	c.propose("c")
+	// Applied up to "a", the rest comes again
+	expect(t, n, "b", "c")
+}
+
+func termOf(n *Node) uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.term
+}
+
+func TestRestartRefusesLogShortOfApplied(t *testing.T) {
+	dir := t.TempDir()
+	c := newTestCluster(t, 3, dir)
+	c.propose("a")
+	c.propose("b")
+	id := c.peers[0]
+	applied := expect(t, c.nodes[id], "a", "b")
+	for _, n := range c.nodes {
+		n.Stop()
+	}
+
+	for _, tc := range []struct {
+		applied uint64
+		ok      bool
+	}{
+		{applied, true},
+		{applied + 1, false},
+	} {
+		j, err := journal.Open(filepath.Join(dir, fmt.Sprintf("raft-%d.jnl", id)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg := Config{Id: id, Peers: c.peers, Storage: NewJournalStorage(j), Applied: tc.applied}
+		_, err = NewNode(cfg, NewLocalNetwork())
+		if tc.ok && err != nil {
+			t.Errorf("applied %d: %v", tc.applied, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("applied %d past the log of %d: started", tc.applied, applied)
+		}
+		j.Close()
+	}
+	// Without storage nothing can have been applied
+	if _, err := NewNode(Config{Id: id, Peers: c.peers, Applied: 1}, NewLocalNetwork()); err == nil {
+		t.Error("applied 1 with no log: started")
+	}
+}
+
+func TestElectsNewLeaderWhenCutOff(t *testing.T) {
+	c := newTestCluster(t, 3, "")
+	old := c.leader()
+	term := termOf(old)
+	c.network.Disconnect(old.Id())
+
+	n := c.leader()
+	if n == old || termOf(n) <= term {
+		t.Fatalf("node %d leads in term %d after node %d of term %d was cut off", n.Id(), termOf(n), old.Id(), term)
+	}
+
+	// Back again the old leader hears of the newer term and follows
+	c.network.Reconnect(old.Id())
+	deadline := time.Now().Add(testTimeout)
+	for old.IsLeader() || old.Leader() != c.leader().Id() {
+		if time.Now().After(deadline) {
+			t.Fatalf("node %d follows %d, node %d leads", old.Id(), old.Leader(), c.leader().Id())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPartitionedLeaderLosesUncommitted(t *testing.T) {
+	c := newTestCluster(t, 3, "")
+	want := c.commit(c.nodes[c.peers[0]], "a")
+	for _, id := range c.peers[1:] {
+		expect(t, c.nodes[id], want...)
+	}
+	old := c.leader()
+	var rest []*Node
+	for id, n := range c.nodes {
+		if id != old.Id() {
+			rest = append(rest, n)
+		}
+	}
+	c.network.Disconnect(old.Id())
+	if _, ok := old.Propose([]byte("x")); !ok {
+		t.Fatal("the cut off leader did not take a proposal")
+	}
+
+	want = c.commit(rest[0], "b")
+	expect(t, rest[1], want...)
+	c.network.Reconnect(old.Id())
+	for lost := ""; lost != "x"; {
+		select {
+		case e := <-old.Lost():
+			lost = string(e.Data)
+		case <-time.After(testTimeout):
+			t.Fatalf("node %d did not give up %q", old.Id(), "x")
+		}
+	}
+	expect(t, old, want...)
+}
+
+func TestFollowerCatchesUp(t *testing.T) {
+	c := newTestCluster(t, 3, "")
+	follower, other := c.peers[0], c.peers[1]
+	if c.leader().Id() == follower {
+		follower, other = c.peers[1], c.peers[0]
+	}
+	c.network.Disconnect(follower)
+
+	// More than one AppendEntries carries
+	var want []string
+	for i := 0; i < 2*maxEntries+10; i++ {
+		want = append(want, c.commit(c.nodes[other], fmt.Sprint(i))...)
+	}
+	c.network.Reconnect(follower)
+	expect(t, c.nodes[follower], want...)
+}
@@ -0,0 +1,75 @@
+package raft
+
+import (
+	"encoding/binary"
+	"errors"
+	"main/matcher/journal"
+	pb "main/proto"
+)
+
+// Tags of the records of a JournalStorage.
+const (
+	stateTag = "r_stat"
+	entryTag = "r_entr"
+)
+
+var errShortRecord = errors.New("raft journal record too short")
+
+// JournalStorage keeps the state of a node in an append-only journal. An
+// entry stored again at an index drops the ones from there on when loaded.
+type JournalStorage struct {
+	journal *journal.Journal
+}
+
+func NewJournalStorage(j *journal.Journal) *JournalStorage {
+	return &JournalStorage{journal: j}
+}
+
+func (s *JournalStorage) Load() (uint64, uint64, []Entry, error) {
+	var term, votedFor uint64
+	var entries []Entry
+	err := s.journal.Replay(func(p *pb.Packet) error {
+		switch string(p.GetTag()) {
+		case stateTag:
+			if len(p.Data) < 16 {
+				return errShortRecord
+			}
+			term = binary.BigEndian.Uint64(p.Data)
+			votedFor = binary.BigEndian.Uint64(p.Data[8:])
+		case entryTag:
+			if len(p.Data) < 16 {
+				return errShortRecord
+			}
+			e := Entry{
+				Index: binary.BigEndian.Uint64(p.Data),
+				Term:  binary.BigEndian.Uint64(p.Data[8:]),
+				Data:  p.Data[16:],
+			}
+			if e.Index == 0 || e.Index > uint64(len(entries))+1 {
+				return errors.New("raft journal skips an entry")
+			}
+			entries = append(entries[:e.Index-1], e)
+		}
+		return nil
+	})
+	return term, votedFor, entries, err
+}
+
+func (s *JournalStorage) SaveState(term uint64, votedFor uint64) error {
+	data := make([]byte, 16)
+	binary.BigEndian.PutUint64(data, term)
+	binary.BigEndian.PutUint64(data[8:], votedFor)
+	return s.journal.Append(stateTag, data)
+}
+
+func (s *JournalStorage) SaveEntries(entries []Entry) error {
+	records := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		data := make([]byte, 16+len(e.Data))
+		binary.BigEndian.PutUint64(data, e.Index)
+		binary.BigEndian.PutUint64(data[8:], e.Term)
+		copy(data[16:], e.Data)
+		records = append(records, data)
+	}
+	return s.journal.AppendAll(entryTag, records)
+}
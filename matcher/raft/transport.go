@@ -0,0 +1,200 @@
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"main/matcher/peer"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+var ErrUnreachable = errors.New("raft peer unreachable")
+
+// LocalNetwork connects nodes of one process, members can be cut off and
+// brought back to try out elections and catch up.
+type LocalNetwork struct {
+	mu    sync.RWMutex
+	nodes map[uint64]*Node
+	down  map[uint64]bool
+}
+
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{
+		nodes: make(map[uint64]*Node),
+		down:  make(map[uint64]bool),
+	}
+}
+
+// Add makes n reachable by its id.
+func (l *LocalNetwork) Add(n *Node) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nodes[n.Id()] = n
+}
+
+// Disconnect cuts id off from every other member, both ways.
+func (l *LocalNetwork) Disconnect(id uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.down[id] = true
+}
+
+// Reconnect undoes Disconnect.
+func (l *LocalNetwork) Reconnect(id uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.down, id)
+}
+
+func (l *LocalNetwork) node(from uint64, to uint64) (*Node, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	n, found := l.nodes[to]
+	if !found || l.down[from] || l.down[to] {
+		return nil, ErrUnreachable
+	}
+	return n, nil
+}
+
+func (l *LocalNetwork) RequestVote(to uint64, args *VoteArgs) (*VoteReply, error) {
+	n, err := l.node(args.CandidateId, to)
+	if err != nil {
+		return nil, err
+	}
+	return n.HandleRequestVote(args), nil
+}
+
+func (l *LocalNetwork) AppendEntries(to uint64, args *AppendArgs) (*AppendReply, error) {
+	n, err := l.node(args.LeaderId, to)
+	if err != nil {
+		return nil, err
+	}
+	// Hand over a copy, a real network would not share the entries either
+	copied := *args
+	copied.Entries = append([]Entry(nil), args.Entries...)
+	return n.HandleAppendEntries(&copied), nil
+}
+
+// rpcTimeout bounds one call to a peer, an unresponsive peer must not
+// hold up a heartbeat for long.
+const rpcTimeout = 500 * time.Millisecond
+
+// RPCTransport reaches the members over TCP with net/rpc, each serving its
+// node with Serve. Both ends of a connection prove they know the secret
+// of the cluster first.
+type RPCTransport struct {
+	mu      sync.Mutex
+	addrs   map[uint64]string
+	secret  string
+	clients map[uint64]*rpc.Client
+}
+
+// NewRPCTransport returns a transport to the members listening on addrs.
+func NewRPCTransport(addrs map[uint64]string, secret string) *RPCTransport {
+	return &RPCTransport{
+		addrs:   addrs,
+		secret:  secret,
+		clients: make(map[uint64]*rpc.Client),
+	}
+}
+
+func (t *RPCTransport) RequestVote(to uint64, args *VoteArgs) (*VoteReply, error) {
+	reply := &VoteReply{}
+	return reply, t.call(to, "Raft.RequestVote", args, reply)
+}
+
+func (t *RPCTransport) AppendEntries(to uint64, args *AppendArgs) (*AppendReply, error) {
+	reply := &AppendReply{}
+	return reply, t.call(to, "Raft.AppendEntries", args, reply)
+}
+
+// call makes one call to a peer, a connection that fails is dropped and
+// dialed again on the next call.
+func (t *RPCTransport) call(to uint64, method string, args interface{}, reply interface{}) error {
+	c, err := t.client(to)
+	if err != nil {
+		return err
+	}
+	call := c.Go(method, args, reply, make(chan *rpc.Call, 1))
+	timer := time.NewTimer(rpcTimeout)
+	defer timer.Stop()
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-timer.C:
+		err = fmt.Errorf("raft peer %d: %s timed out", to, method)
+	}
+	if err != nil {
+		t.mu.Lock()
+		if t.clients[to] == c {
+			delete(t.clients, to)
+			c.Close()
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+func (t *RPCTransport) client(to uint64) (*rpc.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, found := t.clients[to]; found {
+		return c, nil
+	}
+	addr, found := t.addrs[to]
+	if !found {
+		return nil, ErrUnreachable
+	}
+	conn, err := net.DialTimeout("tcp", addr, rpcTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := peer.Dial(conn, t.secret); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("raft peer %d: %w", to, err)
+	}
+	c := rpc.NewClient(conn)
+	t.clients[to] = c
+	return c, nil
+}
+
+// rpcNode exposes the handlers of a node in the shape net/rpc expects.
+type rpcNode struct {
+	n *Node
+}
+
+func (r *rpcNode) RequestVote(args *VoteArgs, reply *VoteReply) error {
+	*reply = *r.n.HandleRequestVote(args)
+	return nil
+}
+
+func (r *rpcNode) AppendEntries(args *AppendArgs, reply *AppendReply) error {
+	*reply = *r.n.HandleAppendEntries(args)
+	return nil
+}
+
+// Serve answers the members calling n through an RPCTransport until l is
+// closed, a caller not knowing secret is hung up on.
+func Serve(l net.Listener, n *Node, secret string) error {
+	s := rpc.NewServer()
+	if err := s.RegisterName("Raft", &rpcNode{n}); err != nil {
+		return err
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := peer.Accept(conn, secret); err != nil {
+				log.Println("raft peer", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			s.ServeConn(conn)
+		}()
+	}
+}
@@ -0,0 +1,482 @@
+package matcher
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"io"
+	"log"
+	"main/matcher/journal"
+	"main/matcher/peer"
+	pb "main/proto"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBatch is how many waiting packets the primary sequences and has the
+// standby confirm in one round trip.
+const maxBatch = 64
+
+// followRetry is how often a standby tries to reach its primary.
+const followRetry = 100 * time.Millisecond
+
+// inputTag tags the records of the input journal.
+const inputTag = "input"
+
+var errReplicatedShards = errors.New("replication needs a single shard, the reports of several shards interleave differently on each replica")
+
+// Replication is how a primary hands its input to a standby.
+type Replication struct {
+	// Listen is where the primary accepts its standby, empty replicates to nobody.
+	Listen string
+	// Timeout is how long the primary waits for the standby to confirm
+	// before dropping it and carrying on alone, zero means one second.
+	Timeout time.Duration
+	// Secret is shared by the primary and its standby, each hangs up on
+	// the other end when it does not know it.
+	Secret string
+}
+
+// inputLog is the sequenced input of the engine, every packet the router
+// takes numbered and stamped with the clock it is matched at. The primary
+// journals each batch and has its standby confirm it before matching, so
+// no report leaves for an order the standby has not got. A standby keeps
+// what the primary sent the same way and carries the numbering on once promoted.
+type inputLog struct {
+	mu      sync.Mutex
+	journal *journal.Journal
+	timeout time.Duration
+	// epoch names the log, a standby can only follow the log it started on
+	epoch   int64
+	records []*pb.InputRecord
+	standby *replica
+}
+
+// newInputLog replays the journal, a restarted engine carries on the
+// numbering and the epoch of the log it wrote before. A cluster replica
+// drops the records of a raft entry it did not journal whole, the entry
+// is committed to it again.
+func newInputLog(j *journal.Journal, cfg Replication, clustered bool) (*inputLog, error) {
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	l := &inputLog{journal: j, timeout: timeout, epoch: time.Now().UnixNano()}
+	if j == nil {
+		return l, nil
+	}
+	err := j.Replay(func(packet *pb.Packet) error {
+		r := &pb.InputRecord{}
+		if err := proto.Unmarshal(packet.Data, r); err != nil {
+			return err
+		}
+		if r.GetSeq() == 0 || r.GetSeq() > l.nextSeq() {
+			return fmt.Errorf("input journal skips from %d to %d", l.nextSeq()-1, r.GetSeq())
+		}
+		// Numbered again after a torn entry, the new records replace the old
+		l.records = append(l.records[:r.GetSeq()-1], r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if clustered {
+		whole := len(l.records)
+		for whole > 0 && l.records[whole-1].GetIndex() == 0 {
+			whole--
+		}
+		l.records = l.records[:whole]
+	}
+	if len(l.records) > 0 {
+		l.epoch = l.records[len(l.records)-1].GetEpoch()
+	}
+	return l, nil
+}
+
+// applied is the last raft entry the log holds whole.
+func (l *inputLog) applied() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.records) == 0 {
+		return 0
+	}
+	return l.records[len(l.records)-1].GetIndex()
+}
+
+// nextSeq is the number of the next record, the caller must hold l.mu.
+func (l *inputLog) nextSeq() uint64 {
+	return uint64(len(l.records)) + 1
+}
+
+// append journals records with one sync and keeps them, the caller must hold l.mu.
+func (l *inputLog) append(records ...*pb.InputRecord) error {
+	if l.journal != nil {
+		data := make([][]byte, len(records))
+		for i, r := range records {
+			var err error
+			if data[i], err = proto.Marshal(r); err != nil {
+				return err
+			}
+		}
+		if err := l.journal.AppendAll(inputTag, data); err != nil {
+			return err
+		}
+	}
+	l.records = append(l.records, records...)
+	return nil
+}
+
+// sequence numbers packet and whatever else already waits on more, only
+// the router may read more. It returns once the standby, when there is
+// one, confirmed the whole batch.
+func (l *inputLog) sequence(packet *pb.Packet, more chan *pb.Packet) []*pb.InputRecord {
+	packets := batchOf(packet, more)
+	now := time.Now().UnixNano()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	batch := make([]*pb.InputRecord, 0, len(packets))
+	for i, p := range packets {
+		batch = append(batch, &pb.InputRecord{
+			Seq:       l.nextSeq() + uint64(i),
+			Epoch:     l.epoch,
+			Timestamp: now,
+			Tag:       string(p.GetTag()),
+			Data:      p.Data,
+		})
+	}
+	if err := l.append(batch...); err != nil {
+		log.Println(err)
+	}
+	if l.standby != nil {
+		if err := l.standby.send(batch, l.timeout); err != nil {
+			log.Println("standby dropped, carrying on alone:", err)
+			l.standby.conn.Close()
+			l.standby = nil
+		}
+	}
+	return batch
+}
+
+// batchOf takes what waits on more behind packet, up to maxBatch in all.
+func batchOf(packet *pb.Packet, more chan *pb.Packet) []*pb.Packet {
+	packets := []*pb.Packet{packet}
+	for len(packets) < maxBatch && len(more) > 0 {
+		packets = append(packets, <-more)
+	}
+	return packets
+}
+
+// committed keeps the records of raft entry index, numbered in commit order.
+func (l *inputLog) committed(records []*pb.InputRecord, index uint64) error {
+	if len(records) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i, r := range records {
+		r.Seq = l.nextSeq() + uint64(i)
+		r.Epoch = l.epoch
+	}
+	records[len(records)-1].Index = index
+	return l.append(records...)
+}
+
+// attach brings a standby up to date in confirmed batches, from then on it
+// confirms every batch the primary sequences. A new standby replaces the old one.
+func (l *inputLog) attach(conn net.Conn) error {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(l.timeout))
+	req := &pb.FollowRequest{}
+	if err := receive(reader, pb.Follow, req); err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	from := req.GetNextSeq()
+	if from == 0 {
+		from = 1
+	}
+	if req.GetEpoch() != 0 && req.GetEpoch() != l.epoch {
+		return fmt.Errorf("follows the input of another primary")
+	}
+	if from > l.nextSeq() {
+		return fmt.Errorf("expects %d, the input ends at %d", from, l.nextSeq()-1)
+	}
+	if err := transmit(conn, pb.Follow, &pb.FollowRequest{Epoch: l.epoch, NextSeq: from}); err != nil {
+		return err
+	}
+
+	r := newReplica(conn, reader)
+	backlog := l.records[from-1:]
+	for len(backlog) > 0 {
+		n := len(backlog)
+		if n > maxBatch {
+			n = maxBatch
+		}
+		if err := r.send(backlog[:n], l.timeout); err != nil {
+			return err
+		}
+		backlog = backlog[n:]
+	}
+	if l.standby != nil {
+		l.standby.conn.Close()
+	}
+	l.standby = r
+	log.Println("standby", conn.RemoteAddr(), "in sync at", l.nextSeq()-1)
+	return nil
+}
+
+// follow keeps a record the primary sent, it must be the next one.
+func (l *inputLog) follow(r *pb.InputRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r.GetSeq() != l.nextSeq() {
+		return fmt.Errorf("expected input %d, got %d", l.nextSeq(), r.GetSeq())
+	}
+	return l.append(r)
+}
+
+// replica is the primary's end of the connection to its standby.
+type replica struct {
+	conn   net.Conn
+	acked  uint64
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func newReplica(conn net.Conn, reader *bufio.Reader) *replica {
+	r := &replica{
+		conn:   conn,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	go r.confirmations(reader)
+	return r
+}
+
+// confirmations records how far the standby got until the connection ends.
+func (r *replica) confirmations(reader *bufio.Reader) {
+	defer close(r.done)
+	for {
+		ack := &pb.InputAck{}
+		if err := receive(reader, pb.Confirm, ack); err != nil {
+			return
+		}
+		atomic.StoreUint64(&r.acked, ack.GetSeq())
+		select {
+		case r.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// send writes records and waits until the standby confirmed the last of them.
+func (r *replica) send(records []*pb.InputRecord, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	r.conn.SetWriteDeadline(deadline)
+	w := bufio.NewWriter(r.conn)
+	for _, record := range records {
+		data, err := proto.Marshal(record)
+		if err != nil {
+			return err
+		}
+		if err := pb.NewPacket(pb.Replicate, data).Pack(w); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	last := records[len(records)-1].GetSeq()
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for atomic.LoadUint64(&r.acked) < last {
+		select {
+		case <-r.notify:
+		case <-r.done:
+			return errors.New("standby disconnected")
+		case <-timer.C:
+			return fmt.Errorf("standby did not confirm %d within %v", last, timeout)
+		}
+	}
+	return nil
+}
+
+// recover matches the input journaled before a restart again, before the
+// engine takes any session or standby, so books, accounts and order ids
+// are back where they were. The reports, audit events and admin commands
+// this repeats are in their journals already and are not written or sent
+// twice, traders catch up by resend as after any reconnect.
+func (m *TradeMatcher) recover() {
+	m.input.mu.Lock()
+	records := m.input.records
+	m.input.mu.Unlock()
+	if len(records) == 0 {
+		return
+	}
+
+	m.recovering = true
+	m.outbound.replay()
+	for _, s := range m.dropCopies.streams {
+		s.store.replay()
+	}
+	m.auditTrail.replay()
+	m.admin.replay()
+	for _, r := range records {
+		m.route(r)
+	}
+	m.eachShard(func(*worker) {})
+	m.outbound.recovered()
+	for _, s := range m.dropCopies.streams {
+		s.store.recovered()
+	}
+	m.auditTrail.recovered()
+	m.admin.recovered()
+	m.recovering = false
+	log.Println("recovered the input up to", len(records))
+}
+
+// serveStandby accepts standbys on the replication address.
+func (m *TradeMatcher) serveStandby(sock net.Listener) {
+	for {
+		conn, err := sock.Accept()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		go func() {
+			if err := peer.Accept(conn, m.replication.Secret); err != nil {
+				log.Println("standby", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			if err := m.input.attach(conn); err != nil {
+				log.Println("standby", conn.RemoteAddr(), err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// Follow runs the matcher as the standby of the primary replicating on addr.
+// It matches the primary's input exactly as the primary did and takes no
+// sessions. It keeps trying until the primary first answers. Once the
+// connection breaks it tries to pick up where it left off for the
+// replication timeout and returns nil when the primary stays lost, the
+// caller promotes the standby by calling Start. Reports of orders the
+// primary acknowledged are numbered the same here, traders reconnecting
+// to the promoted standby catch up by resend.
+func (m *TradeMatcher) Follow(addr string) error {
+	if len(m.workers) > 1 {
+		return errReplicatedShards
+	}
+	if m.replication.Secret == "" {
+		return errPeerSecret
+	}
+	m.startWorkers()
+	var lost time.Time
+	for {
+		if !lost.IsZero() && time.Since(lost) > m.input.timeout {
+			log.Println("primary lost")
+			return nil
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			time.Sleep(followRetry)
+			continue
+		}
+		if err := peer.Dial(conn, m.replication.Secret); err != nil {
+			conn.Close()
+			if lost.IsZero() {
+				return fmt.Errorf("primary at %s: %w", addr, err)
+			}
+			time.Sleep(followRetry)
+			continue
+		}
+		following, err := m.follow(conn)
+		switch {
+		case following:
+			log.Println("primary connection broken:", err)
+			lost = time.Now()
+		case lost.IsZero():
+			return err
+		default:
+			time.Sleep(followRetry)
+		}
+	}
+}
+
+// follow applies what the primary streams until the connection ends, it
+// reports whether the primary took the standby on at all.
+func (m *TradeMatcher) follow(conn net.Conn) (bool, error) {
+	defer conn.Close()
+	l := m.input
+	l.mu.Lock()
+	req := &pb.FollowRequest{NextSeq: l.nextSeq()}
+	if len(l.records) != 0 {
+		req.Epoch = l.epoch
+	}
+	l.mu.Unlock()
+
+	reader := bufio.NewReader(conn)
+	if err := transmit(conn, pb.Follow, req); err != nil {
+		return false, err
+	}
+	reply := &pb.FollowRequest{}
+	if err := receive(reader, pb.Follow, reply); err != nil {
+		return false, fmt.Errorf("the primary at %s refused the standby: %w", conn.RemoteAddr(), err)
+	}
+	l.mu.Lock()
+	l.epoch = reply.GetEpoch()
+	l.mu.Unlock()
+	log.Println("following", conn.RemoteAddr(), "from", req.GetNextSeq())
+
+	for {
+		r := &pb.InputRecord{}
+		if err := receive(reader, pb.Replicate, r); err != nil {
+			return true, err
+		}
+		if err := l.follow(r); err != nil {
+			return true, err
+		}
+		// Confirm once the batch is in, the primary waits for its last record
+		if reader.Buffered() == 0 {
+			if err := transmit(conn, pb.Confirm, &pb.InputAck{Seq: r.GetSeq()}); err != nil {
+				return true, err
+			}
+		}
+		m.route(r)
+	}
+}
+
+// transmit writes one message framed as a packet.
+func transmit(w io.Writer, tag string, msg proto.Message) error {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	if err := pb.NewPacket(tag, data).Pack(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// receive reads the next packet, which has to be tagged tag, into msg.
+func receive(r io.Reader, tag string, msg proto.Message) error {
+	p := new(pb.Packet)
+	if err := p.Unpack(r); err != nil {
+		return err
+	}
+	if string(p.GetTag()) != tag {
+		return fmt.Errorf("expected %s, got %s", tag, p.GetTag())
+	}
+	return proto.Unmarshal(p.Data, msg)
+}
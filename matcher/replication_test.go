@@ -0,0 +1,277 @@
+package matcher
+
+import (
+	"github.com/golang/protobuf/proto"
+	"io"
+	pb "main/proto"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTimeout bounds how long a test waits for the engine.
+const testTimeout = 5 * time.Second
+
+// testClient collects the execution reports of one trader and the
+// answers to its position queries.
+type testClient struct {
+	traderId  uint32
+	reports   chan *pb.Order
+	positions chan *pb.PositionList
+}
+
+func bindTestClient(m *TradeMatcher, traderId uint32) *testClient {
+	c := &testClient{traderId: traderId, reports: make(chan *pb.Order, 1024), positions: make(chan *pb.PositionList, 16)}
+	m.Bind(c)
+	return c
+}
+
+func (c *testClient) TraderId() uint32 {
+	return c.traderId
+}
+
+func (c *testClient) Send(msg proto.Message, tag string) {
+	switch msg := msg.(type) {
+	case *pb.Order:
+		c.reports <- msg
+	case *pb.PositionList:
+		c.positions <- msg
+	}
+}
+
+func (c *testClient) Stop() {}
+
+// wait returns the next n reports of the trader.
+func (c *testClient) wait(t *testing.T, n int) []*pb.Order {
+	t.Helper()
+	reports := make([]*pb.Order, 0, n)
+	for len(reports) < n {
+		select {
+		case order := <-c.reports:
+			reports = append(reports, order)
+		case <-time.After(testTimeout):
+			t.Fatalf("trader %d got %d reports, want %d", c.traderId, len(reports), n)
+		}
+	}
+	return reports
+}
+
+// startTestMatcher runs a matcher on a loopback port once it has recovered
+// what its journals hold.
+func startTestMatcher(t *testing.T, cfg Config) *TradeMatcher {
+	t.Helper()
+	m, err := NewMatcherWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.startWorkers()
+	go m.Start("tcp", "127.0.0.1:0")
+	return m
+}
+
+// trade has trader 2 sell to trader 1 n times on stock 1, each trader gets
+// a report of every fill, then leaves an order of each resting.
+func trade(m *TradeMatcher, n int) {
+	for i := 1; i <= n; i++ {
+		m.Submit(&pb.Order{Uuid: 2, TradeId: uint32(i), StockId: 1, Kind: pb.SELL, Price: 100, Quantity: 10}, pb.Sell)
+		m.Submit(&pb.Order{Uuid: 1, TradeId: uint32(i), StockId: 1, Kind: pb.BUY, Price: 100, Quantity: 10}, pb.Buy)
+	}
+	m.Submit(&pb.Order{Uuid: 1, TradeId: uint32(n + 1), StockId: 1, Kind: pb.BUY, Price: 90, Quantity: 5}, pb.Buy)
+	m.Submit(&pb.Order{Uuid: 2, TradeId: uint32(n + 1), StockId: 1, Kind: pb.SELL, Price: 110, Quantity: 5}, pb.Sell)
+}
+
+// settledBook waits for the book of stock 1 to hold the resting orders of trade.
+func settledBook(t *testing.T, m *TradeMatcher) ([]BookOrder, []BookOrder) {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		bids, asks := m.Book(1)
+		if len(bids) == 1 && len(asks) == 1 {
+			return bids, asks
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the resting orders never reached the book")
+	return nil, nil
+}
+
+func sameBook(t *testing.T, m *TradeMatcher, bids []BookOrder, asks []BookOrder) {
+	t.Helper()
+	gotBids, gotAsks := m.Book(1)
+	if !reflect.DeepEqual(gotBids, bids) || !reflect.DeepEqual(gotAsks, asks) {
+		t.Fatalf("book is %v / %v, want %v / %v", gotBids, gotAsks, bids, asks)
+	}
+}
+
+// sameReports checks m numbered the reports of trader as they were sent.
+func sameReports(t *testing.T, m *TradeMatcher, traderId uint32, sent []*pb.Order) {
+	t.Helper()
+	stored := m.outbound.Range(traderId, 1, 0)
+	if len(stored) != len(sent) {
+		t.Fatalf("trader %d has %d reports, %d were sent", traderId, len(stored), len(sent))
+	}
+	for i, report := range stored {
+		got, want := report.order, sent[i]
+		if got.GetSeqNum() != want.GetSeqNum() || got.GetKind() != want.GetKind() || got.GetOrderId() != want.GetOrderId() || got.GetQuantity() != want.GetQuantity() {
+			t.Fatalf("trader %d report %d is %v, %v was sent", traderId, i+1, got, want)
+		}
+	}
+}
+
+func auditLength(m *TradeMatcher) int {
+	m.auditTrail.mu.RLock()
+	defer m.auditTrail.mu.RUnlock()
+	return len(m.auditTrail.events)
+}
+
+func TestRestartRecoversFromInputJournal(t *testing.T) {
+	dir := t.TempDir()
+	first := startTestMatcher(t, Config{JournalDir: dir})
+	buyer, seller := bindTestClient(first, 1), bindTestClient(first, 2)
+	trade(first, 3)
+	bought, sold := buyer.wait(t, 3), seller.wait(t, 3)
+	bids, asks := settledBook(t, first)
+
+	// The first engine is left idle, as good as gone
+	second := startTestMatcher(t, Config{JournalDir: dir})
+	sameBook(t, second, bids, asks)
+	sameReports(t, second, 1, bought)
+	sameReports(t, second, 2, sold)
+	if auditLength(second) != auditLength(first) {
+		t.Fatalf("audit trail has %d events after the restart, %d before", auditLength(second), auditLength(first))
+	}
+	if second.input.epoch != first.input.epoch || second.input.nextSeq() != first.input.nextSeq() {
+		t.Fatalf("input carries on at %d of epoch %d, was at %d of %d", second.input.nextSeq(), second.input.epoch, first.input.nextSeq(), first.input.epoch)
+	}
+
+	buyer = bindTestClient(second, 1)
+	second.Submit(&pb.Order{Uuid: 2, TradeId: 9, StockId: 1, Kind: pb.SELL, Price: 90, Quantity: 5}, pb.Sell)
+	fill := buyer.wait(t, 1)[0]
+	if fill.GetSeqNum() != 4 || fill.GetOrderId() != bids[0].OrderId {
+		t.Fatalf("fill after the restart is %v, want seq 4 of order %d", fill, bids[0].OrderId)
+	}
+
+	// Nothing recovered was journaled twice
+	third := startTestMatcher(t, Config{JournalDir: dir})
+	if got, want := third.outbound.NextSeq(1), second.outbound.NextSeq(1); got != want {
+		t.Fatalf("next report of trader 1 is %d after a second restart, want %d", got, want)
+	}
+	if auditLength(third) != auditLength(second) {
+		t.Fatalf("audit trail has %d events after a second restart, want %d", auditLength(third), auditLength(second))
+	}
+	bids, asks = second.Book(1)
+	sameBook(t, third, bids, asks)
+}
+
+// proxy forwards connections to target until it is closed, which looks to
+// both ends like the other one died.
+type proxy struct {
+	listener net.Listener
+	mu       sync.Mutex
+	conns    []net.Conn
+}
+
+func newProxy(t *testing.T, target string) *proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{listener: l}
+	go func() {
+		for {
+			in, err := l.Accept()
+			if err != nil {
+				return
+			}
+			out, err := dialUntil(target)
+			if err != nil {
+				in.Close()
+				continue
+			}
+			p.mu.Lock()
+			p.conns = append(p.conns, in, out)
+			p.mu.Unlock()
+			go io.Copy(in, out)
+			go io.Copy(out, in)
+		}
+	}()
+	return p
+}
+
+// dialUntil keeps dialing target while it may not be listening yet.
+func dialUntil(target string) (net.Conn, error) {
+	deadline := time.Now().Add(testTimeout)
+	for {
+		conn, err := net.Dial("tcp", target)
+		if err == nil || time.Now().After(deadline) {
+			return conn, err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (p *proxy) close() {
+	p.listener.Close()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestFailoverKeepsAcknowledgedOrders(t *testing.T) {
+	replication := Replication{Listen: freeAddr(t), Timeout: 200 * time.Millisecond, Secret: "test"}
+	primary := startTestMatcher(t, Config{Replication: replication})
+	link := newProxy(t, replication.Listen)
+	standby, err := NewMatcherWithConfig(Config{Replication: Replication{Timeout: replication.Timeout, Secret: replication.Secret}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	followed := make(chan error, 1)
+	go func() { followed <- standby.Follow(link.listener.Addr().String()) }()
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		primary.input.mu.Lock()
+		attached := primary.input.standby != nil
+		primary.input.mu.Unlock()
+		if attached {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the standby never attached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	buyer, seller := bindTestClient(primary, 1), bindTestClient(primary, 2)
+	trade(primary, 3)
+	bought, sold := buyer.wait(t, 3), seller.wait(t, 3)
+	bids, asks := settledBook(t, primary)
+
+	link.close()
+	select {
+	case err := <-followed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("the standby did not give up on the primary")
+	}
+	go standby.Start("tcp", "127.0.0.1:0")
+
+	sameBook(t, standby, bids, asks)
+	sameReports(t, standby, 1, bought)
+	sameReports(t, standby, 2, sold)
+}
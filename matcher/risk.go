@@ -0,0 +1,226 @@
+package matcher
+
+import (
+	"fmt"
+	pb "main/proto"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// RiskLimits caps what a trader may send, a zero field means no limit.
+// Quantities and notionals are in the units of the stock, see Scale.
+type RiskLimits struct {
+	MaxOrderQuantity uint64
+	MaxOrderNotional uint64
+	MaxOpenOrders    int
+	// MaxOpenNotional caps the resting notional of a trader in one stock, the new order included
+	MaxOpenNotional uint64
+	// MaxPriceDeviation is how far in basis points a price may stray from the last trade
+	MaxPriceDeviation uint64
+	// MaxPosition caps the net position in one stock the order would leave if it filled
+	MaxPosition uint64
+}
+
+// Reject tells the trader why an order never reached the book.
+type Reject struct {
+	Code int32
+	Text string
+}
+
+func (r *Reject) Error() string {
+	return r.Text
+}
+
+// RiskCheck vets a buy, sell or amend on the worker matching its stock
+// before it reaches the book, a non nil Reject keeps the order out. With
+// several shards Check is called concurrently and must be safe for it.
+type RiskCheck interface {
+	Check(order *pb.Order, view RiskView) *Reject
+}
+
+// RiskView is the state of the matcher a RiskCheck decides on.
+type RiskView interface {
+	OpenOrderCount(traderId uint32) int
+	OpenNotional(traderId uint32, stockId uint64) uint64
+	// Order returns the order an amend or cancel names
+	Order(ref *pb.Order) (OrderView, bool)
+	LastPrice(stockId uint64) uint64
+	Position(traderId uint32, stockId uint64) Position
+	Scale(stockId uint64) Scale
+}
+
+type riskView struct {
+	m *TradeMatcher
+}
+
+func (v riskView) OpenOrderCount(traderId uint32) int {
+	v.m.orders.mu.RLock()
+	defer v.m.orders.mu.RUnlock()
+	return len(v.m.orders.open[traderId])
+}
+
+func (v riskView) OpenNotional(traderId uint32, stockId uint64) uint64 {
+	v.m.orders.mu.RLock()
+	defer v.m.orders.mu.RUnlock()
+
+	var sum uint64
+	scale := v.Scale(stockId)
+	for _, o := range v.m.orders.open[traderId] {
+		if o.StockId == stockId {
+			sum = addNotional(sum, scale.notional(o.Remaining, o.Price))
+		}
+	}
+	return sum
+}
+
+func (v riskView) Order(ref *pb.Order) (OrderView, bool) {
+	return v.m.orders.named(ref)
+}
+
+func (v riskView) Position(traderId uint32, stockId uint64) Position {
+	return v.m.Position(traderId, stockId)
+}
+
+func (v riskView) Scale(stockId uint64) Scale {
+	return v.m.instruments.scales.of(stockId)
+}
+
+// LastPrice falls back to the reference price before the first trade of the day.
+func (v riskView) LastPrice(stockId uint64) uint64 {
+	i, _ := v.m.Instrument(stockId)
+	if i.Trades == 0 && i.RefPrice != 0 {
+		return i.RefPrice
+	}
+	return i.LastPrice
+}
+
+// LimitsCheck is the RiskCheck enforcing RiskLimits, every trader gets the
+// default limits unless SetLimits gave it its own.
+type LimitsCheck struct {
+	mu       sync.RWMutex
+	defaults RiskLimits
+	traders  map[uint32]RiskLimits
+}
+
+func NewLimitsCheck(defaults RiskLimits) *LimitsCheck {
+	return &LimitsCheck{
+		defaults: defaults,
+		traders:  make(map[uint32]RiskLimits),
+	}
+}
+
+func (l *LimitsCheck) SetLimits(traderId uint32, limits RiskLimits) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.traders[traderId] = limits
+}
+
+func (l *LimitsCheck) Limits(traderId uint32) RiskLimits {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if limits, found := l.traders[traderId]; found {
+		return limits
+	}
+	return l.defaults
+}
+
+func (l *LimitsCheck) Check(order *pb.Order, view RiskView) *Reject {
+	limits := l.Limits(order.GetUuid())
+	scale := view.Scale(order.GetStockId())
+	orderNotional := scale.notional(order.GetQuantity(), order.GetPrice())
+
+	if limits.MaxOrderQuantity != 0 && order.GetQuantity() > limits.MaxOrderQuantity {
+		return &Reject{pb.REJECT_MAX_QUANTITY,
+			fmt.Sprintf("quantity %d exceeds the limit of %d", order.GetQuantity(), limits.MaxOrderQuantity)}
+	}
+	if limits.MaxOrderNotional != 0 && orderNotional > limits.MaxOrderNotional {
+		return &Reject{pb.REJECT_MAX_NOTIONAL,
+			fmt.Sprintf("notional %d exceeds the limit of %d", orderNotional, limits.MaxOrderNotional)}
+	}
+
+	// An amend replaces a resting order, it adds no order and only its own change in notional
+	var replaced uint64
+	side := order.GetKind()
+	if order.GetKind() == pb.AMEND {
+		if old, found := view.Order(order); found {
+			replaced = scale.notional(old.Remaining, old.Price)
+			side = old.Side
+		}
+	} else if limits.MaxOpenOrders != 0 && view.OpenOrderCount(order.GetUuid()) >= limits.MaxOpenOrders {
+		return &Reject{pb.REJECT_MAX_OPEN_ORDERS,
+			fmt.Sprintf("%d orders open already, the limit is %d", view.OpenOrderCount(order.GetUuid()), limits.MaxOpenOrders)}
+	}
+	if limits.MaxOpenNotional != 0 {
+		open := view.OpenNotional(order.GetUuid(), order.GetStockId()) - replaced
+		if total := addNotional(open, orderNotional); total > limits.MaxOpenNotional {
+			return &Reject{pb.REJECT_MAX_OPEN_NOTIONAL,
+				fmt.Sprintf("open notional %d in stock %d would exceed the limit of %d", total, order.GetStockId(), limits.MaxOpenNotional)}
+		}
+	}
+
+	if limits.MaxPosition != 0 {
+		net := view.Position(order.GetUuid(), order.GetStockId()).Net
+		if side == pb.BUY {
+			net += int64(order.GetQuantity())
+		} else if side == pb.SELL {
+			net -= int64(order.GetQuantity())
+		}
+		if uint64(abs(net)) > limits.MaxPosition {
+			return &Reject{pb.REJECT_MAX_POSITION,
+				fmt.Sprintf("net position %d in stock %d would exceed the limit of %d", net, order.GetStockId(), limits.MaxPosition)}
+		}
+	}
+
+	if last := view.LastPrice(order.GetStockId()); limits.MaxPriceDeviation != 0 && last != 0 {
+		diff := order.GetPrice() - last
+		if order.GetPrice() < last {
+			diff = last - order.GetPrice()
+		}
+		if deviation := basisPoints(diff, last); deviation > limits.MaxPriceDeviation {
+			return &Reject{pb.REJECT_PRICE_DEVIATION,
+				fmt.Sprintf("price %d is %d bps from the last trade at %d, the limit is %d bps", order.GetPrice(), deviation, last, limits.MaxPriceDeviation)}
+		}
+	}
+	return nil
+}
+
+// AddRiskCheck appends a check every buy, sell and amend must pass, it must
+// be called before Start.
+func (m *TradeMatcher) AddRiskCheck(c RiskCheck) {
+	m.risk = append(m.risk, c)
+}
+
+// SetRiskLimits replaces the limits of one trader, it is safe at any time.
+func (m *TradeMatcher) SetRiskLimits(traderId uint32, limits RiskLimits) {
+	m.limits.SetLimits(traderId, limits)
+}
+
+// preTrade runs the risk checks in order, the first reject wins.
+func (m *TradeMatcher) preTrade(order *pb.Order) *Reject {
+	view := riskView{m}
+	for _, c := range m.risk {
+		if reject := c.Check(order, view); reject != nil {
+			return reject
+		}
+	}
+	return nil
+}
+
+// basisPoints is diff as a share of of, saturating when it is too large to count.
+func basisPoints(diff uint64, of uint64) uint64 {
+	hi, lo := bits.Mul64(diff, 10000)
+	if hi >= of {
+		return math.MaxUint64
+	}
+	bps, _ := bits.Div64(hi, lo, of)
+	return bps
+}
+
+func addNotional(a uint64, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
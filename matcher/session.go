@@ -6,21 +6,58 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/golang/protobuf/proto"
-	"io"
 	"log"
 	pb "main/proto"
 	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	DefaultHeartbeatInterval = 30 * time.Second
+	MinHeartbeatInterval     = time.Second
+	MaxHeartbeatInterval     = 5 * time.Minute
+
+	heartbeatTick = time.Second
+)
+
 // Connect is net.Conn rename
 type Connect net.Conn
 
 // Session is save the client connect info
 type Session struct {
-	conn        Connect
-	messageSend chan *pb.Packet
-	messageRecv chan string
+	conn          Connect
+	traderId      uint32 // accessed atomically, a logon may rebind it
+	authenticated uint32 // accessed atomically, set by a successful logon
+	messageRecv   chan string
+
+	// interval, lastRecv and lastSend are accessed atomically, in nanoseconds
+	interval int64
+	lastRecv int64
+	lastSend int64
+	// tick is how often heartbeat looks at the connection
+	tick time.Duration
+
+	owner    *TradeMatcher
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newSession(conn Connect, traderId uint32, owner *TradeMatcher) *Session {
+	now := time.Now().UnixNano()
+	return &Session{
+		conn:        conn,
+		traderId:    traderId,
+		messageRecv: make(chan string, 65535),
+		interval:    int64(DefaultHeartbeatInterval),
+		lastRecv:    now,
+		lastSend:    now,
+		tick:        heartbeatTick,
+		owner:       owner,
+		done:        make(chan struct{}),
+	}
 }
 
 // Start if client connected
@@ -32,21 +69,24 @@ func (s *Session) Start() {
 			n, err := s.conn.Read(buffer)
 			result.Write(buffer[0:n])
 			if err != nil {
-				if err == io.EOF {
+				// io.EOF included, a closed peer will never send again
+				fmt.Println("read err:", err)
+				s.Stop()
+				return
+			}
+			atomic.StoreInt64(&s.lastRecv, time.Now().UnixNano())
+			scanner := bufio.NewScanner(result)
+			scanner.Split(s.packetSlit)
+			for scanner.Scan() {
+				scannedPack := new(pb.Packet)
+				scannedPack.Unpack(bytes.NewReader(scanner.Bytes()))
+				if s.handleSessionPacket(scannedPack) {
 					continue
-				} else {
-					fmt.Println("read err:", err)
-					break
 				}
-			} else {
-				scanner := bufio.NewScanner(result)
-				scanner.Split(s.packetSlit)
-				for scanner.Scan() {
-					scannedPack := new(pb.Packet)
-					scannedPack.Unpack(bytes.NewReader(scanner.Bytes()))
-					fmt.Println(len(string(scanner.Bytes())))
-					s.messageSend <- scannedPack
+				if !s.stamp(scannedPack) {
+					continue
 				}
+				s.owner.enqueue(s.TraderId(), scannedPack)
 			}
 			result.Reset()
 		}
@@ -60,16 +100,158 @@ func (s *Session) Start() {
 				if err != nil {
 					log.Println(err)
 				}
+				atomic.StoreInt64(&s.lastSend, time.Now().UnixNano())
+			case <-s.done:
+				// Flush what is queued, a Logout included, before hanging up
+				for {
+					select {
+					case msg := <-s.messageRecv:
+						s.conn.Write([]byte(msg))
+					default:
+						s.conn.Close()
+						return
+					}
+				}
 			}
 		}
 	}()
+
+	go s.heartbeat()
 }
 
 // Stop if client disconnected
 func (s *Session) Stop() {
-	close(s.messageRecv)
-	s.conn.Close()
-	fmt.Printf("%s is disconnected", s.conn.RemoteAddr().String())
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.owner.Unbind(s)
+		fmt.Printf("%s is disconnected\n", s.conn.RemoteAddr().String())
+	})
+}
+
+// TraderId returns the trader the session acts for.
+func (s *Session) TraderId() uint32 {
+	return atomic.LoadUint32(&s.traderId)
+}
+
+func (s *Session) SetTraderId(traderId uint32) {
+	atomic.StoreUint32(&s.traderId, traderId)
+}
+
+func (s *Session) setAuthenticated() {
+	atomic.StoreUint32(&s.authenticated, 1)
+}
+
+// stamp makes an order act for the session's own trader whatever uuid the
+// client filled in, it reports false when the packet must be dropped.
+func (s *Session) stamp(packet *pb.Packet) bool {
+	if s.owner.AuthRequired() && atomic.LoadUint32(&s.authenticated) == 0 {
+		s.Send(&pb.LogoutReason{Text: "logon required"}, pb.Logout)
+		s.Stop()
+		return false
+	}
+	order := &pb.Order{}
+	if err := proto.Unmarshal(packet.Data, order); err != nil {
+		log.Println(err)
+		return false
+	}
+	if order.GetUuid() != s.TraderId() {
+		order.Uuid = s.TraderId()
+		packet.Data, _ = proto.Marshal(order)
+		packet.DataLen = uint32(len(packet.Data))
+	}
+	return true
+}
+
+// HeartbeatInterval returns the currently negotiated heartbeat interval.
+func (s *Session) HeartbeatInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.interval))
+}
+
+// handleSessionPacket answers session level traffic in place, it reports
+// whether the packet was consumed and must not reach the matcher.
+func (s *Session) handleSessionPacket(packet *pb.Packet) bool {
+	switch string(packet.GetTag()) {
+	case pb.Heartbeat:
+		hb := &pb.KeepAlive{}
+		proto.Unmarshal(packet.Data, hb)
+		if hb.GetInterval() != 0 {
+			interval := s.negotiate(time.Duration(hb.GetInterval()) * time.Second)
+			s.Send(&pb.KeepAlive{Interval: uint32(interval / time.Second)}, pb.Heartbeat)
+		}
+		return true
+	case pb.TestRequest:
+		tr := &pb.KeepAlive{}
+		proto.Unmarshal(packet.Data, tr)
+		s.Send(&pb.KeepAlive{TestReqId: tr.GetTestReqId()}, pb.Heartbeat)
+		return true
+	case pb.Login:
+		l := &pb.Logon{}
+		proto.Unmarshal(packet.Data, l)
+		if s.owner.Logon(s, l) {
+			s.setAuthenticated()
+		}
+		return true
+	case pb.Resend:
+		r := &pb.ResendRequest{}
+		proto.Unmarshal(packet.Data, r)
+		s.owner.Resend(s, r)
+		return true
+	}
+	return false
+}
+
+// negotiate clamps the interval asked by the client into the accepted range.
+func (s *Session) negotiate(interval time.Duration) time.Duration {
+	if interval < MinHeartbeatInterval {
+		interval = MinHeartbeatInterval
+	}
+	if interval > MaxHeartbeatInterval {
+		interval = MaxHeartbeatInterval
+	}
+	atomic.StoreInt64(&s.interval, int64(interval))
+	return interval
+}
+
+// heartbeat keeps the outbound side alive and escalates a silent peer:
+// after one interval plus grace a TestRequest is sent, if the peer
+// still says nothing for another interval the session is stopped.
+func (s *Session) heartbeat() {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	var testReqId string
+	var testSentAt int64
+	for {
+		select {
+		case <-s.done:
+			return
+		case t := <-ticker.C:
+			now := t.UnixNano()
+			interval := atomic.LoadInt64(&s.interval)
+			lastRecv := atomic.LoadInt64(&s.lastRecv)
+
+			if now-atomic.LoadInt64(&s.lastSend) >= interval {
+				s.Send(&pb.KeepAlive{}, pb.Heartbeat)
+			}
+
+			if testReqId != "" {
+				if lastRecv > testSentAt {
+					testReqId = ""
+				} else if now-testSentAt >= interval {
+					log.Printf("trader %d missed test request %s, disconnecting", s.TraderId(), testReqId)
+					s.Stop()
+					return
+				}
+				continue
+			}
+
+			if now-lastRecv >= interval+interval/5 {
+				testReqId = strconv.FormatInt(now, 10)
+				testSentAt = now
+				s.Send(&pb.KeepAlive{TestReqId: testReqId}, pb.TestRequest)
+			}
+		}
+	}
 }
 
 func (s *Session) packetSlit(data []byte, atEOF bool) (advance int, token []byte, err error) {
@@ -93,20 +275,15 @@ func (s *Session) packetSlit(data []byte, atEOF bool) (advance int, token []byte
 
 func (s *Session) Send(msg proto.Message, tag string) {
 	data, _ := proto.Marshal(msg)
-	s.messageRecv <- s.Pack(data, tag)
+	select {
+	case s.messageRecv <- s.Pack(data, tag):
+	case <-s.done:
+	}
 }
 
 func (s *Session) Pack(data []byte, tag string) string {
 	writeBuf := bytes.NewBuffer(nil)
-	resPack := new(pb.Packet)
-	resPack.VersionLen = 2
-	resPack.Version = []byte("V1")
-	resPack.TagLen = 6
-	resPack.Tag = []byte(tag)
-	resPack.Timestamp = uint32(time.Now().Unix())
-	resPack.DataLen = uint32(len(data))
-	resPack.Data = data
-	resPack.Pack(writeBuf)
+	pb.NewPacket(tag, data).Pack(writeBuf)
 
 	return string(writeBuf.Bytes())
 }
@@ -0,0 +1,111 @@
+package matcher
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/golang/protobuf/proto"
+	pb "main/proto"
+	"net"
+	"testing"
+	"time"
+)
+
+// testInterval is the heartbeat interval of the test sessions.
+const testInterval = 50 * time.Millisecond
+
+// startTestSession binds a session on one end of a pipe and returns the
+// other end with the packets the engine sends over it, closed when the
+// engine hangs up.
+func startTestSession(t *testing.T, m *TradeMatcher) (*Session, net.Conn, chan *pb.Packet) {
+	server, peer := net.Pipe()
+	s := newSession(server, m.NextTraderId(), m)
+	s.interval, s.tick = int64(testInterval), testInterval/5
+	m.Bind(s)
+	s.Start()
+	t.Cleanup(func() {
+		peer.Close()
+		s.Stop()
+	})
+
+	packets := make(chan *pb.Packet, 1024)
+	go func() {
+		defer close(packets)
+		r := bufio.NewReader(peer)
+		for {
+			packet := new(pb.Packet)
+			if err := packet.Unpack(r); err != nil {
+				return
+			}
+			packets <- packet
+		}
+	}()
+	return s, peer, packets
+}
+
+func bound(m *TradeMatcher, s *Session) bool {
+	m.r.RLock()
+	defer m.r.RUnlock()
+	return m.sessions[s.TraderId()] == s
+}
+
+func TestSilentPeerDisconnected(t *testing.T) {
+	m := startTestMatcher(t, Config{})
+	s, _, packets := startTestSession(t, m)
+
+	seen := make(map[string]bool)
+	timeout := time.After(testTimeout)
+	for done := false; !done; {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				done = true
+				break
+			}
+			seen[string(packet.GetTag())] = true
+		case <-timeout:
+			t.Fatal("a silent peer was not disconnected")
+		}
+	}
+	if !seen[pb.Heartbeat] || !seen[pb.TestRequest] {
+		t.Errorf("got %v before the hang up, want heartbeats and a test request", seen)
+	}
+	if bound(m, s) {
+		t.Error("the disconnected session still takes reports")
+	}
+}
+
+func TestAnsweredTestRequestKeepsSession(t *testing.T) {
+	m := startTestMatcher(t, Config{})
+	s, peer, packets := startTestSession(t, m)
+
+	answered := 0
+	timeout := time.After(10 * testInterval)
+	for done := false; !done; {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				t.Fatal("disconnected though it answered")
+			}
+			if string(packet.GetTag()) != pb.TestRequest {
+				continue
+			}
+			tr := &pb.KeepAlive{}
+			proto.Unmarshal(packet.Data, tr)
+			data, _ := proto.Marshal(&pb.KeepAlive{TestReqId: tr.GetTestReqId()})
+			var buf bytes.Buffer
+			pb.NewPacket(pb.Heartbeat, data).Pack(&buf)
+			if _, err := peer.Write(buf.Bytes()); err != nil {
+				t.Fatal(err)
+			}
+			answered++
+		case <-timeout:
+			done = true
+		}
+	}
+	if answered == 0 {
+		t.Error("no test request while the peer was silent")
+	}
+	if !bound(m, s) {
+		t.Error("the session was unbound though it answered")
+	}
+}
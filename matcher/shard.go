@@ -0,0 +1,402 @@
+package matcher
+
+import (
+	"fmt"
+	"main/matcher/pqueue"
+	pb "main/proto"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultSlabSize is how many order nodes a shard preallocates unless configured.
+const defaultSlabSize = 1024
+
+// SlabConfig sizes the slab of order nodes of each shard.
+type SlabConfig struct {
+	// Size is how many nodes a shard preallocates, zero means 1024.
+	Size int
+	// Chunk is how many more a shard allocates at a time once all of them
+	// are in use, zero grows by the size of the shard.
+	Chunk int
+	// Shards overrides Size by shard index, for the shards of the busiest stocks.
+	Shards map[int]int
+}
+
+func (c SlabConfig) size(shard int) int {
+	if size := c.Shards[shard]; size > 0 {
+		return size
+	}
+	if c.Size > 0 {
+		return c.Size
+	}
+	return defaultSlabSize
+}
+
+// worker matches the stocks of one shard on its own goroutine with its own
+// books and slab. A stock always maps to the same worker, so its orders are
+// matched in the order they arrived while other shards match in parallel.
+type worker struct {
+	m           *TradeMatcher
+	id          int
+	jobs        chan func()
+	matchQueues map[uint64]*pqueue.MatchQueues
+	slab        *pqueue.Slab
+}
+
+func newWorker(m *TradeMatcher, id int, slab SlabConfig) *worker {
+	return &worker{
+		m:           m,
+		id:          id,
+		jobs:        make(chan func(), recvSize),
+		matchQueues: make(map[uint64]*pqueue.MatchQueues),
+		slab:        pqueue.NewSlab(slab.size(id), slab.Chunk),
+	}
+}
+
+func (w *worker) run() {
+	for job := range w.jobs {
+		job()
+	}
+}
+
+// shardOf returns the worker owning stockId.
+func (m *TradeMatcher) shardOf(stockId uint64) *worker {
+	return m.workers[stockId%uint64(len(m.workers))]
+}
+
+// onShard queues fn behind everything already sent to the worker of stockId.
+func (m *TradeMatcher) onShard(stockId uint64, fn func(w *worker)) {
+	w := m.shardOf(stockId)
+	w.jobs <- func() { fn(w) }
+}
+
+// eachShard runs fn on every worker once each has matched what was queued
+// before, and returns when all of them are done.
+func (m *TradeMatcher) eachShard(fn func(w *worker)) {
+	var wg sync.WaitGroup
+	wg.Add(len(m.workers))
+	for _, w := range m.workers {
+		w := w
+		w.jobs <- func() {
+			defer wg.Done()
+			fn(w)
+		}
+	}
+	wg.Wait()
+}
+
+// SlabStats returns the counts of the order node slab of every shard,
+// indexed by shard. It waits for the workers to match what they have
+// queued, so it must not be called before Start.
+func (m *TradeMatcher) SlabStats() []pqueue.SlabStats {
+	stats := make([]pqueue.SlabStats, len(m.workers))
+	m.eachShard(func(w *worker) {
+		stats[w.id] = w.slab.Stats()
+	})
+	return stats
+}
+
+// match runs the pre-trade checks on an order and matches it, now is the
+// time the order was sequenced at.
+func (w *worker) match(order *pb.Order, now time.Time) {
+	m := w.m
+	trading := order.GetKind() == pb.BUY || order.GetKind() == pb.SELL || order.GetKind() == pb.AMEND
+	var reject *Reject
+	if order.GetKind() == pb.AMEND && order.GetOrderQuantity() != 0 {
+		reject = m.orders.leaves(order)
+	}
+	if trading && reject == nil {
+		reject = m.instruments.scales.admit(order)
+	}
+	m.auditOrder(AuditReceived, order, order.GetQuantity(), "")
+	if trading {
+		if reject == nil {
+			reject = m.admin.blocked(order.GetUuid())
+		}
+		if reject == nil {
+			reject = m.tradable(order, now)
+		}
+		if reject == nil && order.GetKind() != pb.AMEND {
+			reject = w.duplicate(order)
+		}
+		if reject == nil {
+			reject = m.preTrade(order)
+		}
+		if reject == nil {
+			reject = m.reserve(order)
+		}
+		if reject != nil {
+			m.completeRejected(order, reject)
+			return
+		}
+	}
+	on := w.slab.Malloc()
+	on.CopyFrom(order)
+	on.Enter(now.UnixNano())
+	switch order.GetKind() {
+	case pb.BUY:
+		m.orders.accepted(order)
+		m.auditOrder(AuditAccepted, order, order.GetQuantity(), "")
+		m.acknowledge(order)
+		w.addBuy(on, now)
+	case pb.SELL:
+		m.orders.accepted(order)
+		m.auditOrder(AuditAccepted, order, order.GetQuantity(), "")
+		m.acknowledge(order)
+		w.addSell(on, now)
+	case pb.CANCEL:
+		w.cancel(on)
+	case pb.AMEND:
+		w.amend(on, now)
+	default:
+		panic(fmt.Sprintf("MsgKind %v not supported", order))
+	}
+	m.publishDepth(order.GetStockId(), w.getMatchQueues(order.GetStockId()))
+}
+
+// duplicate rejects a new order sent under the client key of one of the
+// trader's orders resting in the book, a key names a single order.
+func (w *worker) duplicate(order *pb.Order) *Reject {
+	q, found := w.matchQueues[order.GetStockId()]
+	if !found || (order.GetTradeId() == 0 && order.GetClientOrderId() == "") {
+		// Orders without a name of the trader's are only known by order id
+		return nil
+	}
+	key := pqueue.ClientKeyOf(order.GetUuid(), order.GetTradeId(), order.GetClientOrderId())
+	if q.Resting(key) == nil {
+		return nil
+	}
+	return &Reject{pb.REJECT_DUPLICATE_ORDER, fmt.Sprintf("order %s is still open", key.Id)}
+}
+
+// addBuy matches a buy sequenced at now and rests what is left of it.
+func (w *worker) addBuy(order *pqueue.OrderNode, now time.Time) {
+	q := w.getMatchQueues(order.StockId())
+	filled := false
+	if alloc := w.m.allocations[order.StockId()]; alloc != nil {
+		filled = w.allocate(order, q, alloc, now)
+	} else {
+		filled = w.fillableBuy(order, q, now)
+	}
+	if !filled {
+		q.PushBuy(order)
+	}
+}
+
+func (w *worker) fillableBuy(b *pqueue.OrderNode, q *pqueue.MatchQueues, now time.Time) bool {
+	for {
+		s := q.PeekSell()
+		if s == nil {
+			return false
+		}
+		if b.Price() >= s.Price() {
+			if b.Quantity() > s.Quantity() {
+				quantity := s.Quantity()
+				price := price(b.Price(), s.Price())
+				b.ReduceQuantity(quantity)
+				w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, quantity, pb.BUY, now)
+				s.Remove()
+				w.slab.Free(s)
+				continue // The sell has been used up
+			}
+			if s.Quantity() > b.Quantity() {
+				quantity := b.Quantity()
+				price := price(b.Price(), s.Price())
+				s.ReduceQuantity(quantity)
+				w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, quantity, pb.BUY, now)
+				w.slab.Free(b)
+				return true // The buy has been used up
+			}
+			if s.Quantity() == b.Quantity() {
+				quantity := b.Quantity()
+				price := price(b.Price(), s.Price())
+				w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, quantity, pb.BUY, now)
+				s.Remove()
+				w.slab.Free(s)
+				w.slab.Free(b)
+				return true // The buy and sell have been used up
+			}
+		} else {
+			return false
+		}
+	}
+}
+
+func (w *worker) getMatchQueues(stockId uint64) *pqueue.MatchQueues {
+	q := w.matchQueues[stockId]
+	if q == nil {
+		q = &pqueue.MatchQueues{}
+		w.matchQueues[stockId] = q
+	}
+	return q
+}
+
+// addSell matches a sell sequenced at now and rests what is left of it.
+func (w *worker) addSell(s *pqueue.OrderNode, now time.Time) {
+	q := w.getMatchQueues(s.StockId())
+	filled := false
+	if alloc := w.m.allocations[s.StockId()]; alloc != nil {
+		filled = w.allocate(s, q, alloc, now)
+	} else {
+		filled = w.fillableSell(s, q, now)
+	}
+	if !filled {
+		q.PushSell(s)
+	}
+}
+
+func (w *worker) fillableSell(s *pqueue.OrderNode, q *pqueue.MatchQueues, now time.Time) bool {
+	for {
+		b := q.PeekBuy()
+		if b == nil {
+			return false
+		}
+		if b.Price() >= s.Price() {
+			if b.Quantity() > s.Quantity() {
+				amount := s.Quantity()
+				price := price(b.Price(), s.Price())
+				b.ReduceQuantity(amount)
+				w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, amount, pb.SELL, now)
+				s.Remove()
+				w.slab.Free(s)
+				return true // The sell has been used up
+			}
+			if s.Quantity() > b.Quantity() {
+				amount := b.Quantity()
+				price := price(b.Price(), s.Price())
+				s.ReduceQuantity(amount)
+				w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, amount, pb.SELL, now)
+				b.Remove()
+				w.slab.Free(b) // The buy has been used up
+				continue
+			}
+			if s.Quantity() == b.Quantity() {
+				amount := b.Quantity()
+				price := price(b.Price(), s.Price())
+				w.m.completeTrade(pb.PARTIAL, pb.FULL, b, s, price, amount, pb.SELL, now)
+				b.Remove()
+				w.slab.Free(b)
+				w.slab.Free(s)
+				return true // The sell and buy have been used up
+			}
+		} else {
+			return false
+		}
+	}
+}
+
+func (w *worker) cancel(o *pqueue.OrderNode) {
+	q := w.getMatchQueues(o.StockId())
+	ro := q.Cancel(o)
+	if ro != nil {
+		w.m.completeCancelled(ro, "cancel request")
+		w.slab.Free(ro)
+	} else {
+		w.m.completeNotCancelled(o)
+	}
+	w.slab.Free(o)
+}
+
+// amend replaces the price and quantity of a resting order, the order
+// goes back through matching and loses its time priority.
+func (w *worker) amend(o *pqueue.OrderNode, now time.Time) {
+	q := w.getMatchQueues(o.StockId())
+	ro := q.Find(o)
+	if ro == nil {
+		w.m.completeNotCancelled(o)
+		w.slab.Free(o)
+		return
+	}
+
+	// The amended order keeps the ids of the one it replaces
+	am := pb.Order{}
+	o.CopyTo(&am)
+	am.Kind = ro.Kind()
+	am.TimeInForce = ro.TimeInForce()
+	am.ExpireDate = ro.ExpireDate()
+	am.OrderId = ro.OrderId()
+	am.TradeId = ro.TradeId()
+	am.ClientOrderId = ro.ClientOrderId()
+	if reject := w.m.ledger.rehold(ro, am.GetQuantity(), am.GetPrice()); reject != nil {
+		// The resting order stays as it was
+		req := pb.Order{}
+		o.CopyTo(&req)
+		w.m.completeRejected(&req, reject)
+		w.slab.Free(o)
+		return
+	}
+	ro.Remove()
+	w.slab.Free(ro)
+	entered := o.Entered()
+	o.CopyFrom(&am)
+	o.Enter(entered)
+	w.m.completeAmended(o)
+
+	if am.GetQuantity() == 0 {
+		w.slab.Free(o)
+		return
+	}
+	if am.GetKind() == pb.BUY {
+		w.addBuy(o, now)
+	} else {
+		w.addSell(o, now)
+	}
+}
+
+// openOrders returns the resting orders of a trader in this shard, of one
+// stock unless stockId is 0.
+func (w *worker) openOrders(traderId uint32, stockId uint64) []*pb.OpenOrder {
+	stocks := []uint64{stockId}
+	if stockId == 0 {
+		stocks = w.stockIds()
+	}
+
+	now := time.Now().UnixNano()
+	var result []*pb.OpenOrder
+	for _, stockId := range stocks {
+		q, found := w.matchQueues[stockId]
+		if !found {
+			continue
+		}
+		for _, o := range q.TraderOrders(traderId) {
+			result = append(result, &pb.OpenOrder{
+				OrderId:       o.OrderId(),
+				TradeId:       o.TradeId(),
+				ClientOrderId: o.ClientOrderId(),
+				StockId:       o.StockId(),
+				Kind:          o.Kind(),
+				Price:         o.Price(),
+				Remaining:     o.Quantity(),
+				Entered:       o.Entered(),
+				TimeInBook:    now - o.Entered(),
+			})
+		}
+	}
+	return result
+}
+
+// cancelAll takes orders out of the book of stockId reporting each as cancelled.
+func (w *worker) cancelAll(stockId uint64, orders []*pqueue.OrderNode, reason string) {
+	if len(orders) == 0 {
+		return
+	}
+	q := w.getMatchQueues(stockId)
+	for _, o := range orders {
+		if ro := q.Cancel(o); ro != nil {
+			w.m.completeCancelled(ro, reason)
+			w.slab.Free(ro)
+		}
+	}
+	w.m.publishDepth(stockId, q)
+}
+
+// stockIds returns every stock with a book in this shard in ascending order.
+func (w *worker) stockIds() []uint64 {
+	stocks := make([]uint64, 0, len(w.matchQueues))
+	for stockId := range w.matchQueues {
+		stocks = append(stocks, stockId)
+	}
+	sort.Slice(stocks, func(i, j int) bool { return stocks[i] < stocks[j] })
+	return stocks
+}
@@ -0,0 +1,93 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// MaxScale is the most decimal places a Decimal can have, ten to its power
+// still fits in 64 bits.
+const MaxScale = 19
+
+var (
+	ErrDecimalSyntax    = errors.New("not a decimal")
+	ErrDecimalOverflow  = errors.New("decimal overflows 64 bits")
+	ErrDecimalPrecision = errors.New("decimal has too many decimal places")
+)
+
+// Pow10 is ten to the power of scale, scale must not exceed MaxScale.
+func Pow10(scale uint32) uint64 {
+	f := uint64(1)
+	for i := uint32(0); i < scale; i++ {
+		f *= 10
+	}
+	return f
+}
+
+func NewDecimal(units uint64, scale uint32) *Decimal {
+	return &Decimal{Units: units, Scale: scale}
+}
+
+// ParseDecimal reads an unsigned decimal such as "123.45", keeping as many
+// decimal places as it is written with.
+func ParseDecimal(s string) (*Decimal, error) {
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return nil, fmt.Errorf("%w: %q", ErrDecimalSyntax, s)
+	}
+	if len(fraction) > MaxScale {
+		return nil, fmt.Errorf("%w: %q", ErrDecimalPrecision, s)
+	}
+	units, err := strconv.ParseUint(whole+fraction, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("%w: %q", ErrDecimalOverflow, s)
+		}
+		return nil, fmt.Errorf("%w: %q", ErrDecimalSyntax, s)
+	}
+	return NewDecimal(units, uint32(len(fraction))), nil
+}
+
+// Text formats d with all its decimal places, "123.45".
+func (d *Decimal) Text() string {
+	units := strconv.FormatUint(d.GetUnits(), 10)
+	scale := int(d.GetScale())
+	if scale == 0 {
+		return units
+	}
+	if len(units) <= scale {
+		units = strings.Repeat("0", scale-len(units)+1) + units
+	}
+	return units[:len(units)-scale] + "." + units[len(units)-scale:]
+}
+
+// Rescale returns d in units of scale decimal places. It fails when d has
+// digits other than zero past scale places or does not fit in 64 bits.
+func (d *Decimal) Rescale(scale uint32) (uint64, error) {
+	if d.GetScale() > MaxScale || scale > MaxScale {
+		return 0, fmt.Errorf("%w: scale %d", ErrDecimalPrecision, max32(d.GetScale(), scale))
+	}
+	units := d.GetUnits()
+	if d.GetScale() > scale {
+		f := Pow10(d.GetScale() - scale)
+		if units%f != 0 {
+			return 0, fmt.Errorf("%w: %s has more than %d", ErrDecimalPrecision, d.Text(), scale)
+		}
+		return units / f, nil
+	}
+	hi, lo := bits.Mul64(units, Pow10(scale-d.GetScale()))
+	if hi != 0 {
+		return 0, fmt.Errorf("%w: %s at %d decimal places", ErrDecimalOverflow, d.Text(), scale)
+	}
+	return lo, nil
+}
+
+func max32(a uint32, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,6 +1,6 @@
 @echo Off
 if %errorlevel% ==0 (
-    protoc --go_out . *.proto
+    protoc --go_out . --go-grpc_out . *.proto
     @echo Off
     pause
 ) else (
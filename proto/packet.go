@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 )
 
 const (
@@ -15,6 +16,54 @@ const (
 	PARTIAL
 	FULL
 	LIST
+	AMEND
+	REJECTED
+	POSITION
+	EXPIRED
+)
+
+// Time in force of an order, GTD orders carry an expire date as YYYYMMDD.
+const (
+	TIF_GTC = iota
+	TIF_DAY
+	TIF_GTD
+)
+
+// Reject codes a REJECTED order carries, the reason says which limit and by how much.
+const (
+	REJECT_NONE = iota
+	REJECT_MAX_QUANTITY
+	REJECT_MAX_NOTIONAL
+	REJECT_MAX_OPEN_ORDERS
+	REJECT_MAX_OPEN_NOTIONAL
+	REJECT_PRICE_DEVIATION
+	REJECT_MAX_POSITION
+	REJECT_INSUFFICIENT_CASH
+	REJECT_INSUFFICIENT_HOLDINGS
+	REJECT_TRADER_DISABLED
+	REJECT_HALTED
+	REJECT_RATE_LIMIT
+	REJECT_MARKET_CLOSED
+	REJECT_INVALID_EXPIRY
+	REJECT_NOT_PERMITTED
+	REJECT_NOT_LEADER
+	REJECT_INVALID_DECIMAL
+	REJECT_NOTIONAL_OVERFLOW
+	REJECT_DUPLICATE_ORDER
+	REJECT_OVERFILLED
+)
+
+// Actions of an AdminCommand.
+const (
+	ADMIN_NONE = iota
+	ADMIN_DISABLE_TRADER
+	ADMIN_ENABLE_TRADER
+	ADMIN_CANCEL_STOCK
+	ADMIN_KILL
+	ADMIN_RESUME
+	ADMIN_OPEN_STOCK
+	ADMIN_CLOSE_STOCK
+	ADMIN_END_OF_DAY
 )
 
 const (
@@ -23,6 +72,23 @@ const (
 	Sell         = "t_1002"
 	Cancel       = "t_1003"
 	NotCancelled = "t_1004"
+	Heartbeat    = "t_1005"
+	TestRequest  = "t_1006"
+	Login        = "t_1007"
+	Resend       = "t_1008"
+	SeqReset     = "t_1009"
+	Amend        = "t_1010"
+	Logout       = "t_1011"
+	TradeTick    = "t_1012"
+	BookDepth    = "t_1013"
+	List         = "t_1014"
+	Rejected     = "t_1015"
+	Positions    = "t_1016"
+	Admin        = "t_1017"
+	Expired      = "t_1018"
+	Follow       = "t_1019"
+	Replicate    = "t_1020"
+	Confirm      = "t_1021"
 )
 
 type Packet struct {
@@ -35,31 +101,52 @@ type Packet struct {
 	Data       []byte
 }
 
+// NewPacket frames data with the current protocol version.
+func NewPacket(tag string, data []byte) *Packet {
+	return &Packet{
+		VersionLen: 2,
+		Version:    []byte("V1"),
+		TagLen:     uint32(len(tag)),
+		Tag:        []byte(tag),
+		Timestamp:  uint32(time.Now().Unix()),
+		DataLen:    uint32(len(data)),
+		Data:       data,
+	}
+}
+
 func (p *Packet) Pack(writer io.Writer) error {
-	var err error
-	err = binary.Write(writer, binary.LittleEndian, &p.VersionLen)
-	err = binary.Write(writer, binary.LittleEndian, &p.Version)
-	err = binary.Write(writer, binary.LittleEndian, &p.TagLen)
-	err = binary.Write(writer, binary.LittleEndian, &p.Tag)
-	err = binary.Write(writer, binary.LittleEndian, &p.Timestamp)
-	err = binary.Write(writer, binary.LittleEndian, &p.DataLen)
-	err = binary.Write(writer, binary.LittleEndian, &p.Data)
-	return err
+	fields := []interface{}{&p.VersionLen, &p.Version, &p.TagLen, &p.Tag, &p.Timestamp, &p.DataLen, &p.Data}
+	for _, field := range fields {
+		if err := binary.Write(writer, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (p *Packet) Unpack(reader io.Reader) error {
-	var err error
-	err = binary.Read(reader, binary.LittleEndian, &p.VersionLen)
+	if err := binary.Read(reader, binary.LittleEndian, &p.VersionLen); err != nil {
+		return err
+	}
 	p.Version = make([]byte, p.VersionLen)
-	err = binary.Read(reader, binary.LittleEndian, &p.Version)
-	err = binary.Read(reader, binary.LittleEndian, &p.TagLen)
+	if err := binary.Read(reader, binary.LittleEndian, &p.Version); err != nil {
+		return err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &p.TagLen); err != nil {
+		return err
+	}
 	p.Tag = make([]byte, p.TagLen)
-	err = binary.Read(reader, binary.LittleEndian, &p.Tag)
-	err = binary.Read(reader, binary.LittleEndian, &p.Timestamp)
-	err = binary.Read(reader, binary.LittleEndian, &p.DataLen)
+	if err := binary.Read(reader, binary.LittleEndian, &p.Tag); err != nil {
+		return err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &p.Timestamp); err != nil {
+		return err
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &p.DataLen); err != nil {
+		return err
+	}
 	p.Data = make([]byte, p.DataLen)
-	err = binary.Read(reader, binary.LittleEndian, &p.Data)
-	return err
+	return binary.Read(reader, binary.LittleEndian, &p.Data)
 }
 
 func (p *Packet) GetTag() []byte {
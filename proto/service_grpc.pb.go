@@ -0,0 +1,304 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.17.3
+// source: service.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// TradeServiceClient is the client API for TradeService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TradeServiceClient interface {
+	SubmitOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*OrderAck, error)
+	CancelOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*OrderAck, error)
+	AmendOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*OrderAck, error)
+	SubscribeExecutions(ctx context.Context, in *ExecutionSubscription, opts ...grpc.CallOption) (TradeService_SubscribeExecutionsClient, error)
+	SubscribeBook(ctx context.Context, in *BookSubscription, opts ...grpc.CallOption) (TradeService_SubscribeBookClient, error)
+}
+
+type tradeServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTradeServiceClient(cc grpc.ClientConnInterface) TradeServiceClient {
+	return &tradeServiceClient{cc}
+}
+
+func (c *tradeServiceClient) SubmitOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*OrderAck, error) {
+	out := new(OrderAck)
+	err := c.cc.Invoke(ctx, "/proto.TradeService/SubmitOrder", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tradeServiceClient) CancelOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*OrderAck, error) {
+	out := new(OrderAck)
+	err := c.cc.Invoke(ctx, "/proto.TradeService/CancelOrder", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tradeServiceClient) AmendOrder(ctx context.Context, in *Order, opts ...grpc.CallOption) (*OrderAck, error) {
+	out := new(OrderAck)
+	err := c.cc.Invoke(ctx, "/proto.TradeService/AmendOrder", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tradeServiceClient) SubscribeExecutions(ctx context.Context, in *ExecutionSubscription, opts ...grpc.CallOption) (TradeService_SubscribeExecutionsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TradeService_ServiceDesc.Streams[0], "/proto.TradeService/SubscribeExecutions", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tradeServiceSubscribeExecutionsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TradeService_SubscribeExecutionsClient interface {
+	Recv() (*Order, error)
+	grpc.ClientStream
+}
+
+type tradeServiceSubscribeExecutionsClient struct {
+	grpc.ClientStream
+}
+
+func (x *tradeServiceSubscribeExecutionsClient) Recv() (*Order, error) {
+	m := new(Order)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *tradeServiceClient) SubscribeBook(ctx context.Context, in *BookSubscription, opts ...grpc.CallOption) (TradeService_SubscribeBookClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TradeService_ServiceDesc.Streams[1], "/proto.TradeService/SubscribeBook", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &tradeServiceSubscribeBookClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TradeService_SubscribeBookClient interface {
+	Recv() (*MarketDataUpdate, error)
+	grpc.ClientStream
+}
+
+type tradeServiceSubscribeBookClient struct {
+	grpc.ClientStream
+}
+
+func (x *tradeServiceSubscribeBookClient) Recv() (*MarketDataUpdate, error) {
+	m := new(MarketDataUpdate)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TradeServiceServer is the server API for TradeService service.
+// All implementations must embed UnimplementedTradeServiceServer
+// for forward compatibility
+type TradeServiceServer interface {
+	SubmitOrder(context.Context, *Order) (*OrderAck, error)
+	CancelOrder(context.Context, *Order) (*OrderAck, error)
+	AmendOrder(context.Context, *Order) (*OrderAck, error)
+	SubscribeExecutions(*ExecutionSubscription, TradeService_SubscribeExecutionsServer) error
+	SubscribeBook(*BookSubscription, TradeService_SubscribeBookServer) error
+	mustEmbedUnimplementedTradeServiceServer()
+}
+
+// UnimplementedTradeServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedTradeServiceServer struct {
+}
+
+func (UnimplementedTradeServiceServer) SubmitOrder(context.Context, *Order) (*OrderAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SubmitOrder not implemented")
+}
+func (UnimplementedTradeServiceServer) CancelOrder(context.Context, *Order) (*OrderAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelOrder not implemented")
+}
+func (UnimplementedTradeServiceServer) AmendOrder(context.Context, *Order) (*OrderAck, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AmendOrder not implemented")
+}
+func (UnimplementedTradeServiceServer) SubscribeExecutions(*ExecutionSubscription, TradeService_SubscribeExecutionsServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeExecutions not implemented")
+}
+func (UnimplementedTradeServiceServer) SubscribeBook(*BookSubscription, TradeService_SubscribeBookServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeBook not implemented")
+}
+func (UnimplementedTradeServiceServer) mustEmbedUnimplementedTradeServiceServer() {}
+
+// UnsafeTradeServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TradeServiceServer will
+// result in compilation errors.
+type UnsafeTradeServiceServer interface {
+	mustEmbedUnimplementedTradeServiceServer()
+}
+
+func RegisterTradeServiceServer(s grpc.ServiceRegistrar, srv TradeServiceServer) {
+	s.RegisterService(&TradeService_ServiceDesc, srv)
+}
+
+func _TradeService_SubmitOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Order)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TradeServiceServer).SubmitOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TradeService/SubmitOrder",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TradeServiceServer).SubmitOrder(ctx, req.(*Order))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TradeService_CancelOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Order)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TradeServiceServer).CancelOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TradeService/CancelOrder",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TradeServiceServer).CancelOrder(ctx, req.(*Order))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TradeService_AmendOrder_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Order)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TradeServiceServer).AmendOrder(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/proto.TradeService/AmendOrder",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TradeServiceServer).AmendOrder(ctx, req.(*Order))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TradeService_SubscribeExecutions_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExecutionSubscription)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TradeServiceServer).SubscribeExecutions(m, &tradeServiceSubscribeExecutionsServer{stream})
+}
+
+type TradeService_SubscribeExecutionsServer interface {
+	Send(*Order) error
+	grpc.ServerStream
+}
+
+type tradeServiceSubscribeExecutionsServer struct {
+	grpc.ServerStream
+}
+
+func (x *tradeServiceSubscribeExecutionsServer) Send(m *Order) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TradeService_SubscribeBook_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BookSubscription)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TradeServiceServer).SubscribeBook(m, &tradeServiceSubscribeBookServer{stream})
+}
+
+type TradeService_SubscribeBookServer interface {
+	Send(*MarketDataUpdate) error
+	grpc.ServerStream
+}
+
+type tradeServiceSubscribeBookServer struct {
+	grpc.ServerStream
+}
+
+func (x *tradeServiceSubscribeBookServer) Send(m *MarketDataUpdate) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TradeService_ServiceDesc is the grpc.ServiceDesc for TradeService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TradeService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.TradeService",
+	HandlerType: (*TradeServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "SubmitOrder",
+			Handler:    _TradeService_SubmitOrder_Handler,
+		},
+		{
+			MethodName: "CancelOrder",
+			Handler:    _TradeService_CancelOrder_Handler,
+		},
+		{
+			MethodName: "AmendOrder",
+			Handler:    _TradeService_AmendOrder_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeExecutions",
+			Handler:       _TradeService_SubscribeExecutions_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "SubscribeBook",
+			Handler:       _TradeService_SubscribeBook_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "service.proto",
+}